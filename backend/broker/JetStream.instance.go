@@ -0,0 +1,122 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go-gerbang/types"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Streams of the notification queue. The dead-letter subject is under
+// gateway.> so the pub/sub API can not reach it.
+const (
+	NotificationStream      = "NOTIFICATION"
+	NotificationSubject     = "user.notification"
+	NotificationConsumer    = "notification-mailer"
+	NotificationDeadStream  = "NOTIFICATION_DEAD"
+	NotificationDeadSubject = "gateway.notification.dead"
+	UserEventStream         = "USER_EVENTS"
+)
+
+// Streams of the outbound webhooks. The audit events published on NATS are
+// captured in a stream as well, the dispatcher reads both and queues one
+// delivery per matching webhook.
+const (
+	AuditEventStream   = "AUDIT_EVENTS"
+	AuditEventSubjects = "audit.>"
+	WebhookStream      = "WEBHOOK"
+	WebhookSubject     = "gateway.webhook.delivery"
+	WebhookDispatcher  = "webhook-dispatcher"
+	WebhookConsumer    = "webhook-sender"
+)
+
+// Headers of a queued webhook delivery.
+const (
+	WebhookHeaderId      = "Gateway-Webhook-Id"
+	WebhookHeaderEvent   = "Gateway-Webhook-Event"
+	WebhookHeaderEventId = "Gateway-Webhook-Event-Id"
+)
+
+// Headers of a dead-lettered notification.
+const (
+	DeadLetterHeaderError      = "Gateway-Dead-Error"
+	DeadLetterHeaderDeliveries = "Gateway-Dead-Deliveries"
+	DeadLetterHeaderSequence   = "Gateway-Dead-Sequence"
+	DeadLetterHeaderQueuedAt   = "Gateway-Dead-Queued-At"
+)
+
+var JetStream jetstream.JetStream
+
+// StartingJetStream creates the streams of the notification queue, of the
+// user lifecycle and audit events and of the webhooks, existing streams are
+// updated to the current configuration.
+func StartingJetStream() error {
+	if NatsClient == nil {
+		return fmt.Errorf("nats client is not connected")
+	}
+
+	js, err := jetstream.New(NatsClient)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      NotificationStream,
+		Subjects:  []string{NotificationSubject},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+		MaxAge:    7 * 24 * time.Hour,
+	}); err != nil {
+		return fmt.Errorf("stream %s: %w", NotificationStream, err)
+	}
+
+	if _, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     NotificationDeadStream,
+		Subjects: []string{NotificationDeadSubject},
+		Storage:  jetstream.FileStorage,
+		MaxAge:   30 * 24 * time.Hour,
+	}); err != nil {
+		return fmt.Errorf("stream %s: %w", NotificationDeadStream, err)
+	}
+
+	if _, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       UserEventStream,
+		Subjects:   types.UserEvents,
+		Storage:    jetstream.FileStorage,
+		MaxAge:     7 * 24 * time.Hour,
+		Duplicates: 10 * time.Minute,
+	}); err != nil {
+		return fmt.Errorf("stream %s: %w", UserEventStream, err)
+	}
+
+	if _, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     AuditEventStream,
+		Subjects: []string{AuditEventSubjects},
+		Storage:  jetstream.FileStorage,
+		MaxAge:   24 * time.Hour,
+	}); err != nil {
+		return fmt.Errorf("stream %s: %w", AuditEventStream, err)
+	}
+
+	if _, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       WebhookStream,
+		Subjects:   []string{WebhookSubject},
+		Retention:  jetstream.WorkQueuePolicy,
+		Storage:    jetstream.FileStorage,
+		MaxAge:     7 * 24 * time.Hour,
+		Duplicates: 10 * time.Minute,
+	}); err != nil {
+		return fmt.Errorf("stream %s: %w", WebhookStream, err)
+	}
+
+	JetStream = js
+	fmt.Printf("✅ NATS JetStream streams ready\n")
+
+	return nil
+}
@@ -1,21 +1,74 @@
 package broker
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"go-gerbang/config"
+	"go-gerbang/types"
 
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
 )
 
+// NatsAuth is the content of NATS_AUTH_FILE. Every account groups the users
+// of one client, authenticated with a user/password (bcrypt hash accepted)
+// or an nkey, and the subjects they may publish and subscribe to. They share
+// the subjects of the gateway, an account without permissions may use every
+// subject but NatsReservedSubjects.
+//
+//	{"accounts": [{"name": "orders", "users": [{"user": "orders", "password": "..."}],
+//	  "permissions": {"publish": {"allow": ["orders.>"]}, "subscribe": {"allow": ["orders.>", "_INBOX.>"]}}}]}
+type NatsAuth struct {
+	Accounts []NatsAccount `json:"accounts"`
+}
+
+type NatsAccount struct {
+	Name        string              `json:"name"`
+	Users       []NatsUser          `json:"users"`
+	Permissions *server.Permissions `json:"permissions"`
+}
+
+type NatsUser struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Nkey     string `json:"nkey"`
+}
+
+// NatsReservedSubjects carry the cluster events, emails, audit trail, user
+// events and JetStream API of the gateway. They are denied to the
+// NATS_AUTH_FILE users unless their account allows a subject under them
+// explicitly, the replies of other clients under _INBOX.> as well for
+// subscribe.
+var (
+	NatsReservedSubjects = append([]string{"gateway.>", NotificationSubject, AuditEventSubjects, "$JS.>"}, types.UserEvents...)
+	NatsReservedInboxes  = []string{"_INBOX.>"}
+)
+
+// StartingNatsServer starts the embedded server, nothing is started when
+// NATS_MODE is external.
 func StartingNatsServer() (*server.Server, error) {
-	natsServer, err := server.NewServer(&server.Options{
-		Host: "0.0.0.0",
-		Port: 9001,
-	})
+	if !config.NatsEmbedded() {
+		fmt.Printf("✅ NATS server is external\n")
+		return nil, nil
+	}
+
+	opts, err := natsServerOptions()
+	if err != nil {
+		log.Printf("failed to configure NATS server: %v", err)
+		return nil, fmt.Errorf("failed to configure NATS server: %w", err)
+	}
+
+	natsServer, err := server.NewServer(opts)
 	if err != nil {
 		log.Printf("failed to create NATS server: %v", err)
 		return nil, fmt.Errorf("failed to create NATS server: %w", err)
@@ -28,21 +81,232 @@ func StartingNatsServer() (*server.Server, error) {
 		return nil, fmt.Errorf("NATS server failed to start")
 	}
 
-	fmt.Printf("✅ NATS server running :9001\n")
+	fmt.Printf("✅ NATS server running %s:%d\n", opts.Host, opts.Port)
+	if opts.HTTPPort > 0 {
+		fmt.Printf("✅ NATS monitoring running %s:%d\n", opts.HTTPHost, opts.HTTPPort)
+	}
 
 	return natsServer, nil
 }
 
+func natsServerOptions() (*server.Options, error) {
+	storeDir := config.NatsStoreDir
+	if storeDir == "" {
+		storeDir = filepath.Join(config.BasePath, "data", "jetstream")
+	}
+
+	opts := &server.Options{
+		Host:               natsHost(),
+		Port:               config.NatsPort,
+		MaxPayload:         int32(config.NatsMaxPayload),
+		JetStream:          true,
+		StoreDir:           storeDir,
+		JetStreamMaxMemory: int64(config.NatsJetStreamMaxMemory),
+		JetStreamMaxStore:  int64(config.NatsJetStreamMaxStore),
+		HTTPHost:           config.NatsMonitorHost,
+		HTTPPort:           config.NatsMonitorPort,
+	}
+	if opts.HTTPHost == "" {
+		opts.HTTPHost = "127.0.0.1"
+	}
+
+	if config.NatsTLSCertFile != "" {
+		tlsConfig, err := server.GenTLSConfig(&server.TLSConfigOpts{
+			CertFile: config.NatsTLSCertFile,
+			KeyFile:  config.NatsTLSKeyFile,
+			CaFile:   config.NatsTLSCAFile,
+			Verify:   config.NatsTLSVerify,
+		})
+		if err != nil {
+			return nil, err
+		}
+		opts.TLS = true
+		opts.TLSConfig = tlsConfig
+		opts.TLSVerify = config.NatsTLSVerify
+		opts.TLSTimeout = 2
+	}
+
+	if err := natsServerAuth(opts); err != nil {
+		return nil, err
+	}
+
+	if !isLoopbackHost(opts.Host) && len(opts.Users) == 0 && len(opts.Nkeys) == 0 {
+		return nil, fmt.Errorf("NATS_HOST %s is reachable from the network, set NATS_USER or NATS_NKEY_SEED_FILE", opts.Host)
+	}
+
+	return opts, nil
+}
+
+// natsServerAuth adds the gateway user, NATS_USER or the public key of
+// NATS_NKEY_SEED_FILE, with every permission and the users of
+// NATS_AUTH_FILE. Without any of them the server accepts anonymous clients,
+// on a loopback host only.
+func natsServerAuth(opts *server.Options) error {
+	auth := NatsAuth{}
+	if config.NatsAuthFile != "" {
+		raw, err := os.ReadFile(config.NatsAuthFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(raw, &auth); err != nil {
+			return fmt.Errorf("%s: %w", config.NatsAuthFile, err)
+		}
+	}
+
+	if config.NatsUser != "" {
+		opts.Users = append(opts.Users, &server.User{Username: config.NatsUser, Password: config.NatsPassword})
+	}
+	if config.NatsNkeySeedFile != "" {
+		publicKey, err := natsNkeyPublic(config.NatsNkeySeedFile)
+		if err != nil {
+			return err
+		}
+		opts.Nkeys = append(opts.Nkeys, &server.NkeyUser{Nkey: publicKey})
+	}
+
+	if len(auth.Accounts) > 0 && len(opts.Users) == 0 && len(opts.Nkeys) == 0 {
+		return fmt.Errorf("NATS_AUTH_FILE needs NATS_USER or NATS_NKEY_SEED_FILE for the gateway")
+	}
+
+	for _, account := range auth.Accounts {
+		for _, user := range account.Users {
+			switch {
+			case user.Nkey != "":
+				if !nkeys.IsValidPublicUserKey(user.Nkey) {
+					return fmt.Errorf("account %s: invalid user nkey %s", account.Name, user.Nkey)
+				}
+				opts.Nkeys = append(opts.Nkeys, &server.NkeyUser{Nkey: user.Nkey, Permissions: natsAccountPermissions(account.Permissions)})
+			case user.User != "":
+				opts.Users = append(opts.Users, &server.User{Username: user.User, Password: user.Password, Permissions: natsAccountPermissions(account.Permissions)})
+			default:
+				return fmt.Errorf("account %s: a user needs a user or an nkey", account.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// natsAccountPermissions adds NatsReservedSubjects, and NatsReservedInboxes
+// for subscribe, to the deny lists of permissions, but for the ones the allow
+// list names a subject under.
+func natsAccountPermissions(permissions *server.Permissions) *server.Permissions {
+	result := &server.Permissions{
+		Publish:   &server.SubjectPermission{},
+		Subscribe: &server.SubjectPermission{},
+	}
+	if permissions != nil {
+		result.Response = permissions.Response
+		if permissions.Publish != nil {
+			*result.Publish = *permissions.Publish
+		}
+		if permissions.Subscribe != nil {
+			*result.Subscribe = *permissions.Subscribe
+		}
+	}
+
+	result.Publish.Deny = natsDeny(result.Publish, NatsReservedSubjects)
+	result.Subscribe.Deny = natsDeny(result.Subscribe, slices.Concat(NatsReservedSubjects, NatsReservedInboxes))
+
+	return result
+}
+
+// natsDeny is the deny list of subjects with the reserved subjects its allow
+// list does not grant.
+func natsDeny(subjects *server.SubjectPermission, reserved []string) []string {
+	deny := slices.Clone(subjects.Deny)
+	for _, subject := range reserved {
+		if !natsGrants(subjects.Allow, subject) {
+			deny = append(deny, subject)
+		}
+	}
+	return deny
+}
+
+// natsGrants reports whether allow names reserved or a subject under it,
+// a wildcard such as ">" is not an explicit grant.
+func natsGrants(allow []string, reserved string) bool {
+	prefix, wildcard := strings.CutSuffix(reserved, ">")
+	for _, subject := range allow {
+		if subject == reserved || (wildcard && strings.HasPrefix(subject, prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func natsNkeyPublic(seedFile string) (string, error) {
+	seed, err := os.ReadFile(seedFile)
+	if err != nil {
+		return "", err
+	}
+
+	keyPair, err := nkeys.FromSeed([]byte(strings.TrimSpace(string(seed))))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", seedFile, err)
+	}
+	defer keyPair.Wipe()
+
+	return keyPair.PublicKey()
+}
+
+func natsHost() string {
+	if config.NatsHost == "" {
+		return "127.0.0.1"
+	}
+	return config.NatsHost
+}
+
 var NatsClient *nats.Conn
 
+// StartingNatsClient connects to NATS_SERVER_URL, or to the embedded server
+// when it is empty.
 func StartingNatsClient() {
-	serverURL := config.Config("NATS_SERVER_URL")
+	serverURL := config.NatsServerUrl
+	if serverURL == "" {
+		host := natsHost()
+		if host == "0.0.0.0" || host == "::" {
+			host = "127.0.0.1"
+		}
+		serverURL = "nats://" + host + ":" + strconv.Itoa(config.NatsPort)
+	}
+
+	opts := []nats.Option{
+		nats.Name("go-gerbang"),
+		nats.MaxReconnects(-1),
+	}
+	if config.NatsUser != "" {
+		opts = append(opts, nats.UserInfo(config.NatsUser, config.NatsPassword))
+	}
+	if config.NatsNkeySeedFile != "" {
+		opt, err := nats.NkeyOptionFromSeed(config.NatsNkeySeedFile)
+		if err != nil {
+			log.Printf("Error loading NATS nkey seed: %v", err)
+			return
+		}
+		opts = append(opts, opt)
+	}
+	if config.NatsTLSCAFile != "" {
+		opts = append(opts, nats.RootCAs(config.NatsTLSCAFile))
+	}
+	if config.NatsClientCertFile != "" {
+		opts = append(opts, nats.ClientCert(config.NatsClientCertFile, config.NatsClientKeyFile))
+	}
 
 	var err error
-	NatsClient, err = nats.Connect(serverURL)
+	NatsClient, err = nats.Connect(serverURL, opts...)
 	if err != nil {
 		log.Printf("Error connecting to NATS server: %v", err)
+		return
 	}
 
-	fmt.Printf("✅ NATS client connected at:%s\n", serverURL)
+	fmt.Printf("✅ NATS client connected at:%s\n", NatsClient.ConnectedUrlRedacted())
 }
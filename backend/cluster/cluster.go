@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go-gerbang/broker"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// InstanceId identifies this gateway process in cluster messages, in the
+// node list and as leader lock owner.
+var InstanceId = uuid.NewString()
+
+const subjectPrefix = "gateway.cluster."
+
+// Cluster events, every instance runs the handlers registered with On when
+// another instance publishes one of them.
+const (
+	EventServicesReload = "services.reload"
+	EventConfigReload   = "config.reload"
+	EventMailInvalidate = "mail.invalidate"
+	EventCsrfActivated  = "csrf.activated"
+	EventPolicyReload   = "policy.reload"
+)
+
+type Event struct {
+	Kind      string          `json:"kind"`
+	Origin    string          `json:"origin"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Timestamp int64           `json:"timestamp"`
+}
+
+// Decode unmarshals the payload of the event into v.
+func (e Event) Decode(v interface{}) error {
+	if len(e.Payload) == 0 {
+		return nil
+	}
+	return json.Unmarshal(e.Payload, v)
+}
+
+var (
+	handlersMutex sync.RWMutex
+	eventHandlers = map[string][]func(Event){}
+	startOnce     sync.Once
+)
+
+// On registers fn for events of kind published by other instances.
+func On(kind string, fn func(Event)) {
+	handlersMutex.Lock()
+	defer handlersMutex.Unlock()
+
+	eventHandlers[kind] = append(eventHandlers[kind], fn)
+}
+
+// Publish broadcasts an event to the other instances. The caller applies the
+// change locally itself, its own events are ignored on receipt.
+func Publish(kind string, payload interface{}) error {
+	event := Event{
+		Kind:      kind,
+		Origin:    InstanceId,
+		Timestamp: time.Now().UnixMilli(),
+	}
+
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		event.Payload = raw
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if broker.NatsClient == nil {
+		return fmt.Errorf("nats client is not connected")
+	}
+
+	return broker.NatsClient.Publish(subjectPrefix+kind, data)
+}
+
+// Start subscribes to the cluster events and starts the heartbeat and the
+// leader election. It is safe to call more than once.
+func Start() {
+	startOnce.Do(func() {
+		if broker.NatsClient == nil {
+			log.Printf("error: cluster: nats client is not connected, running standalone")
+		} else if _, err := broker.NatsClient.Subscribe(subjectPrefix+">", dispatch); err != nil {
+			log.Printf("error: cluster: subscribe: %s", err)
+		}
+
+		go heartbeat()
+		go elect()
+	})
+}
+
+func dispatch(msg *nats.Msg) {
+	var event Event
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		log.Printf("error: cluster: %s: %s", msg.Subject, err)
+		return
+	}
+	if event.Origin == InstanceId {
+		return
+	}
+
+	handlersMutex.RLock()
+	fns := eventHandlers[event.Kind]
+	handlersMutex.RUnlock()
+
+	for _, fn := range fns {
+		fn(event)
+	}
+}
+
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
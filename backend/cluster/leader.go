@@ -0,0 +1,152 @@
+package cluster
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"go-gerbang/database"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	leaderKey      = "gateway:cluster:leader"
+	nodesKey       = "gateway:cluster:nodes"
+	leaderTTL      = 15 * time.Second
+	renewInterval  = 5 * time.Second
+	nodeStaleAfter = 30 * time.Second
+)
+
+var isLeader atomic.Bool
+
+// renewScript extends the lease only while this instance still owns it.
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+type Node struct {
+	InstanceId string `json:"instance_id"`
+	Hostname   string `json:"hostname"`
+	StartedAt  int64  `json:"started_at"`
+	LastSeen   int64  `json:"last_seen"`
+	Leader     bool   `json:"leader"`
+}
+
+// IsLeader reports whether this instance holds the leader lease.
+func IsLeader() bool {
+	return isLeader.Load()
+}
+
+// Leader returns the instance id holding the lease, empty when none does.
+func Leader() string {
+	leader, err := database.RedisDb.Get(database.RedisCtx, leaderKey).Result()
+	if err != nil {
+		return ""
+	}
+	return leader
+}
+
+// elect keeps trying to take the leader lease in Redis and renews it while
+// held. Losing Redis means losing the lease, two leaders never run at once
+// longer than leaderTTL.
+func elect() {
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+
+	for {
+		ctx := database.RedisCtx
+		if isLeader.Load() {
+			renewed, err := renewScript.Run(ctx, database.RedisDb, []string{leaderKey}, InstanceId, leaderTTL.Milliseconds()).Int()
+			if err != nil || renewed == 0 {
+				isLeader.Store(false)
+				log.Printf("cluster: %s lost leadership", InstanceId)
+			}
+		} else {
+			acquired, err := database.RedisDb.SetNX(ctx, leaderKey, InstanceId, leaderTTL).Result()
+			if err == nil && acquired {
+				isLeader.Store(true)
+				log.Printf("cluster: %s is the leader", InstanceId)
+			}
+		}
+
+		<-ticker.C
+	}
+}
+
+func heartbeat() {
+	node := Node{
+		InstanceId: InstanceId,
+		Hostname:   hostname(),
+		StartedAt:  time.Now().Unix(),
+	}
+
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+
+	for {
+		node.LastSeen = time.Now().Unix()
+		if data, err := json.Marshal(node); err == nil {
+			database.RedisDb.HSet(database.RedisCtx, nodesKey, InstanceId, data)
+		}
+
+		<-ticker.C
+	}
+}
+
+// Nodes lists the instances seen recently, stale entries are removed.
+func Nodes() ([]Node, error) {
+	entries, err := database.RedisDb.HGetAll(database.RedisCtx, nodesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	leader := Leader()
+	nodes := []Node{}
+	for id, raw := range entries {
+		var node Node
+		if err := json.Unmarshal([]byte(raw), &node); err != nil {
+			continue
+		}
+		if time.Since(time.Unix(node.LastSeen, 0)) > nodeStaleAfter {
+			database.RedisDb.HDel(database.RedisCtx, nodesKey, id)
+			continue
+		}
+		node.Leader = node.InstanceId == leader
+		nodes = append(nodes, node)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].StartedAt < nodes[j].StartedAt
+	})
+
+	return nodes, nil
+}
+
+// Every runs fn every interval on the leader only. The first run happens one
+// interval after start so a fresh leader does not repeat a job just done by
+// the previous one.
+func Every(name string, interval time.Duration, fn func() error) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if !IsLeader() {
+				continue
+			}
+			if err := fn(); err != nil {
+				log.Printf("error: cluster job %s: %s", name, err)
+			}
+		}
+	}()
+}
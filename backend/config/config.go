@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -24,8 +26,47 @@ func Config(key string) string {
 	return os.Getenv(key)
 }
 
+// ConfigList reads key as a comma separated list.
+func ConfigList(key string, fallback string) []string {
+	value := Config(key)
+	if value == "" {
+		value = fallback
+	}
+
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// ConfigInt reads key as an integer, fallback is used when it is unset or
+// not a number.
+func ConfigInt(key string, fallback int) int {
+	value, err := strconv.Atoi(Config(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
 var ConfigPath = Config("CONFIG_PATH_JSON")
 
+// Where the proxied services are read from: "file" (default) for the
+// CONFIG_PATH_JSON file, "database" for the services table
+var ServiceConfigSource = Config("SERVICE_CONFIG_SOURCE")
+
+const (
+	ServiceConfigSourceFile     = "file"
+	ServiceConfigSourceDatabase = "database"
+)
+
+func ServicesFromDatabase() bool {
+	return ServiceConfigSource == ServiceConfigSourceDatabase
+}
+
 var APP_PORT = Config("PORT_APIGATEWAY")
 
 var AuthTimeCache = 1 * time.Hour
@@ -41,6 +82,109 @@ var CookieKey = Config("KEY_COOKIE_APIGATEWAY")
 var SecureCookiesString = Config("SECURE_COOKIES")
 var CookieSameSite = Config("COOKIES_SAME_SITE")
 
+// TLS, TLS_CLIENT_AUTH is "none", "request" or "require"
+var TLSCertFile = Config("TLS_CERT_FILE")
+var TLSKeyFile = Config("TLS_KEY_FILE")
+var TLSClientCAFile = Config("TLS_CLIENT_CA_FILE")
+var TLSClientAuth = Config("TLS_CLIENT_AUTH")
+
+// Listener of native gRPC calls (HTTP/2) to the grpc services, empty
+// disables it. It uses the TLS settings above when TLS_CERT_FILE is set
+var GrpcPort = Config("PORT_GRPC")
+
+// Bootstrap credential of /migration until one is stored in
+// basic_auth_credentials, the password is a bcrypt hash
+var MigrationBasicAuthUser = Config("MIGRATION_BASIC_AUTH_USER")
+var MigrationBasicAuthHash = Config("MIGRATION_BASIC_AUTH_PASSWORD_HASH")
+
+// Global rate limit applied before any route, counted per client IP, 0
+// disables it
+var GlobalRateLimit = ConfigInt("GLOBAL_RATE_LIMIT", 1000)
+var GlobalRateLimitWindow = ConfigInt("GLOBAL_RATE_LIMIT_WINDOW", 60) // seconds
+var RateLimitExemptIps = ConfigList("RATE_LIMIT_EXEMPT_IPS", "127.0.0.1,::1")
+
+// Reverse proxies allowed to set X-Forwarded-For, empty trusts none
+var TrustedProxies = ConfigList("TRUSTED_PROXIES", "")
+
+// Casbin policies are reloaded from the database on this interval, 0 only
+// reloads them when another instance changes them
+var PolicyReloadInterval = ConfigInt("POLICY_RELOAD_INTERVAL", 30) // seconds
+
+// Let /forward-auth answer for paths without a service, they always need
+// RBAC and an API key scoped for "*"
+var ForwardAuthAllowUnmatched = Config("FORWARD_AUTH_ALLOW_UNMATCHED") == "true"
+
+// Serve /docs and /docs/openapi.json without admin access
+var ApiDocsPublic = Config("API_DOCS_PUBLIC") == "true"
+
+// Cluster jobs, run by the elected leader only. 0 disables a job
+var LogRetentionDays = ConfigInt("LOG_RETENTION_DAYS", 30)
+var HealthCheckInterval = ConfigInt("HEALTH_CHECK_INTERVAL", 30) // seconds
+
+// NATS, NATS_MODE "external" starts no embedded server and only connects
+// to NATS_SERVER_URL (comma separated for a cluster). The client connects to
+// the embedded server when NATS_SERVER_URL is empty
+var NatsMode = Config("NATS_MODE")
+var NatsServerUrl = Config("NATS_SERVER_URL")
+
+const (
+	NatsModeEmbedded = "embedded"
+	NatsModeExternal = "external"
+)
+
+func NatsEmbedded() bool {
+	return NatsMode != NatsModeExternal
+}
+
+// Embedded NATS server, listens on 127.0.0.1:9001 when unset
+var NatsHost = Config("NATS_HOST")
+var NatsPort = ConfigInt("NATS_PORT", 9001)
+var NatsMaxPayload = ConfigInt("NATS_MAX_PAYLOAD", 0) // bytes, 0 is the server default (1MB)
+
+// Credential of the gateway, also required by the embedded server when set.
+// NATS_AUTH_FILE lists the other clients, see broker.NatsAuth
+var NatsUser = Config("NATS_USER")
+var NatsPassword = Config("NATS_PASSWORD")
+var NatsNkeySeedFile = Config("NATS_NKEY_SEED_FILE")
+var NatsAuthFile = Config("NATS_AUTH_FILE")
+
+// TLS of the embedded server, NATS_TLS_VERIFY requires client certificates
+// signed by NATS_TLS_CA_FILE. The client trusts NATS_TLS_CA_FILE and
+// presents NATS_CLIENT_CERT_FILE when set
+var NatsTLSCertFile = Config("NATS_TLS_CERT_FILE")
+var NatsTLSKeyFile = Config("NATS_TLS_KEY_FILE")
+var NatsTLSCAFile = Config("NATS_TLS_CA_FILE")
+var NatsTLSVerify = Config("NATS_TLS_VERIFY") == "true"
+var NatsClientCertFile = Config("NATS_CLIENT_CERT_FILE")
+var NatsClientKeyFile = Config("NATS_CLIENT_KEY_FILE")
+
+// JetStream storage of the embedded NATS server, BasePath/data/jetstream
+// when empty. The limits are in bytes, 0 lets the server size them
+var NatsStoreDir = Config("NATS_STORE_DIR")
+var NatsJetStreamMaxMemory = ConfigInt("NATS_JETSTREAM_MAX_MEMORY", 0)
+var NatsJetStreamMaxStore = ConfigInt("NATS_JETSTREAM_MAX_STORE", 0)
+
+// Monitoring endpoints (/varz, /connz, /jsz) of the embedded server, 0
+// disables them
+var NatsMonitorHost = Config("NATS_MONITOR_HOST")
+var NatsMonitorPort = ConfigInt("NATS_MONITOR_PORT", 0)
+
+// Notification queue, a failed email is retried after NOTIFICATION_BACKOFF
+// doubled on every attempt, then dead-lettered after NOTIFICATION_MAX_DELIVER
+var NotificationMaxDeliver = ConfigInt("NOTIFICATION_MAX_DELIVER", 5)
+var NotificationBackoff = ConfigInt("NOTIFICATION_BACKOFF", 30) // seconds
+
+// Outbox relay, runs on the leader. Published events are kept
+// OUTBOX_RETENTION_DAYS, 0 keeps them
+var OutboxRelayInterval = ConfigInt("OUTBOX_RELAY_INTERVAL", 2) // seconds
+var OutboxRetentionDays = ConfigInt("OUTBOX_RETENTION_DAYS", 7)
+
+// Outbound webhooks, a failed delivery is retried after WEBHOOK_BACKOFF
+// doubled on every attempt, up to WEBHOOK_MAX_ATTEMPTS
+var WebhookMaxAttempts = ConfigInt("WEBHOOK_MAX_ATTEMPTS", 8)
+var WebhookBackoff = ConfigInt("WEBHOOK_BACKOFF", 10) // seconds
+var WebhookTimeout = ConfigInt("WEBHOOK_TIMEOUT", 10) // seconds
+
 // DEV
 var SecureCookies = false //change true to prod false to dev
 
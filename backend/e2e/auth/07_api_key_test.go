@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"go-gerbang/e2e/helpers"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiKeyLifecycle(t *testing.T) {
+	client := helpers.NewClient()
+
+	token, err := helpers.LoginToken(client, "test_user", "Password123!")
+	assert.NoError(t, err)
+
+	ownerId, err := helpers.LoginUserId(client, token)
+	assert.NoError(t, err)
+
+	headers := map[string]string{"Authorization": token}
+
+	payload := map[string]interface{}{
+		"name":      "e2e machine client",
+		"ownerId":   ownerId,
+		"scopes":    []string{"*:read"},
+		"rateLimit": 60,
+	}
+
+	resp, apiRes, err := helpers.DoJSON(client, http.MethodPost, helpers.BaseURL()+"/auth/api-key/", payload, headers)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, apiRes.Status)
+
+	data := apiRes.Data.(map[string]interface{})
+	assert.NotEmpty(t, data["key"])
+
+	item := data["item"].(map[string]interface{})
+	idApiKey := item["idApiKey"].(string)
+
+	resp, apiRes, err = helpers.DoJSON(client, http.MethodPost, helpers.BaseURL()+"/auth/api-key/"+idApiKey+"/rotate", nil, headers)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, apiRes.Status)
+
+	resp, apiRes, err = helpers.DoJSON(client, http.MethodDelete, helpers.BaseURL()+"/auth/api-key/"+idApiKey, nil, headers)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, apiRes.Status)
+
+	resp, _, err = helpers.DoJSON(client, http.MethodDelete, helpers.BaseURL()+"/auth/api-key/"+idApiKey, nil, headers)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"go-gerbang/e2e/helpers"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func forwardAuthRequest(uri string, token string) *http.Request {
+	req, _ := http.NewRequest(http.MethodGet, helpers.BaseURL()+"/forward-auth", nil)
+	req.Header.Set("X-Forwarded-Method", http.MethodGet)
+	req.Header.Set("X-Forwarded-Uri", uri)
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	return req
+}
+
+func TestForwardAuth(t *testing.T) {
+	client := helpers.NewClient()
+
+	resp, _, err := helpers.Do(client, forwardAuthRequest("/api/skor/list?page=1", ""))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	token, err := helpers.LoginToken(client, "test_user", "Password123!")
+	assert.NoError(t, err)
+
+	resp, _, err = helpers.Do(client, forwardAuthRequest("/api/skor/list?page=1", token))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "test_user", resp.Header.Get("X-Auth-Username"))
+}
+
+func TestForwardAuthUnmatchedPathIsDenied(t *testing.T) {
+	client := helpers.NewClient()
+
+	token, err := helpers.LoginToken(client, "test_user", "Password123!")
+	assert.NoError(t, err)
+
+	resp, _, err := helpers.Do(client, forwardAuthRequest("/external/app?page=1", token))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestForwardAuthQueryOnlyAddsChecks(t *testing.T) {
+	client := helpers.NewClient()
+
+	token, err := helpers.LoginToken(client, "test_user", "Password123!")
+	assert.NoError(t, err)
+
+	// test_user has no RBAC policy on /api/skor, rbac=true must deny it
+	req := forwardAuthRequest("/api/skor/list", token)
+	req.URL.RawQuery = "rbac=true"
+	resp, _, err := helpers.Do(client, req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	req = forwardAuthRequest("/api/skor/list", token)
+	req.URL.RawQuery = "rbac=false&session=false"
+	resp, _, err = helpers.Do(client, req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestForwardAuthCleansPath(t *testing.T) {
+	client := helpers.NewClient()
+
+	token, err := helpers.LoginToken(client, "test_user", "Password123!")
+	assert.NoError(t, err)
+
+	// resolved by the proxy to /external/app, which has no service
+	resp, _, err := helpers.Do(client, forwardAuthRequest("/api/skor/../../external/app", token))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, _, err = helpers.Do(client, forwardAuthRequest("/external/../api/skor/list", token))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	for _, uri := range []string{"/api/skor%2F..%2F..%2Fexternal/app", "/api/skor/..%5Cexternal"} {
+		resp, _, err = helpers.Do(client, forwardAuthRequest(uri, token))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, uri)
+	}
+}
+
+func TestForwardAuthIgnoresApiKeyOfJWTService(t *testing.T) {
+	client := helpers.NewClient()
+
+	token, err := helpers.LoginToken(client, "test_user", "Password123!")
+	assert.NoError(t, err)
+
+	// /api/skor authenticates with JWT only, the key is not looked at
+	req := forwardAuthRequest("/api/skor/list", token)
+	req.Header.Set("X-API-Key", "gg_invalid")
+	resp, _, err := helpers.Do(client, req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req = forwardAuthRequest("/api/skor/list", "")
+	req.Header.Set("X-API-Key", "gg_invalid")
+	resp, _, err = helpers.Do(client, req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
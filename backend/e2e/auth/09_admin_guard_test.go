@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"go-gerbang/e2e/helpers"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminEndpointsRequireAuth(t *testing.T) {
+	client := helpers.NewClient()
+
+	endpoints := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/restart"},
+		{http.MethodPost, "/config-file"},
+		{http.MethodPost, "/upload-file"},
+		{http.MethodGet, "/Configuration/execute"},
+		{http.MethodPost, "/Configuration"},
+		{http.MethodPost, "/publish"},
+		{http.MethodGet, "/log-stats-proxy"},
+	}
+
+	for _, endpoint := range endpoints {
+		// with a CSRF token, only the missing login is refused
+		resp, _, err := helpers.DoJSON(client, endpoint.method, helpers.BaseURL()+endpoint.path, nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, endpoint.path)
+	}
+}
+
+func TestAdminEndpointsRequireCsrf(t *testing.T) {
+	client := helpers.NewClient()
+
+	endpoints := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/restart"},
+		{http.MethodPost, "/config-file"},
+		{http.MethodPost, "/config-file/dry-run"},
+		{http.MethodPost, "/config-file/versions/1/rollback"},
+		{http.MethodPost, "/upload-file"},
+		{http.MethodPost, "/Configuration"},
+		{http.MethodDelete, "/Configuration/MODULE_CONFIG"},
+		{http.MethodPost, "/cache/purge"},
+		{http.MethodPost, "/cluster/csrf"},
+		{http.MethodPost, "/notification/dead-letter/retry"},
+		{http.MethodPost, "/notification/dead-letter/1/retry"},
+		{http.MethodDelete, "/notification/dead-letter/1"},
+		{http.MethodDelete, "/notification/dead-letter"},
+	}
+
+	for _, endpoint := range endpoints {
+		req, _ := http.NewRequest(endpoint.method, helpers.BaseURL()+endpoint.path, nil)
+		resp, body, err := helpers.Do(client, req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, endpoint.path)
+		assert.Equal(t, "CSRF validation failed", body.Message, endpoint.path)
+	}
+}
+
+func TestMigrationRequiresBasicAuth(t *testing.T) {
+	client := helpers.NewClient()
+
+	req, _ := http.NewRequest(http.MethodGet, helpers.BaseURL()+"/migration", nil)
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+
+	req, _ = http.NewRequest(http.MethodGet, helpers.BaseURL()+"/migration", nil)
+	req.SetBasicAuth("admin", "@dmin9192")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+}
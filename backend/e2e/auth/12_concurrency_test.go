@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-gerbang/e2e/helpers"
+	"go-gerbang/proxyroute"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceConcurrencyRequiresAdmin(t *testing.T) {
+	client := helpers.NewClient()
+
+	req, _ := http.NewRequest(http.MethodGet, helpers.BaseURL()+"/service/concurrency", nil)
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Contains(t, []int{http.StatusUnauthorized, http.StatusForbidden}, resp.StatusCode)
+}
+
+func concurrencyStats(t *testing.T, path string) proxyroute.ConcurrencyStats {
+	for _, stats := range proxyroute.GetConcurrencyStats() {
+		if stats.Path == path {
+			return stats
+		}
+	}
+	t.Fatalf("no limiter for %s", path)
+	return proxyroute.ConcurrencyStats{}
+}
+
+// blockingUpstream answers once release is closed.
+func blockingUpstream(release chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func proxyGet(t *testing.T, app *fiber.App, path string) *http.Response {
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil), fiber.TestConfig{Timeout: 10 * time.Second})
+	require.NoError(t, err)
+	return resp
+}
+
+func TestConcurrencyQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	upstream := blockingUpstream(release)
+	defer upstream.Close()
+
+	app := helpers.ProxyApp(types.Service{
+		Service:     "concurrency-queue",
+		Path:        "/e2e/concurrency-queue",
+		Url:         upstream.URL,
+		Concurrency: &types.Concurrency{MaxInFlight: 1, QueueSize: 1, QueueTimeout: 5000},
+	})
+
+	statuses := make(chan int, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- proxyGet(t, app, "/e2e/concurrency-queue/").StatusCode
+		}()
+	}
+
+	// One request holds the slot, the other waits in the queue
+	assert.Eventually(t, func() bool {
+		stats := concurrencyStats(t, "/e2e/concurrency-queue")
+		return stats.InFlight == 1 && stats.Queued == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	resp := proxyGet(t, app, "/e2e/concurrency-queue/")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get(fiber.HeaderRetryAfter))
+
+	close(release)
+	wg.Wait()
+	close(statuses)
+	for status := range statuses {
+		assert.Equal(t, http.StatusOK, status)
+	}
+
+	stats := concurrencyStats(t, "/e2e/concurrency-queue")
+	assert.Equal(t, 0, stats.InFlight)
+	assert.Equal(t, 0, stats.Queued)
+	assert.Equal(t, int64(1), stats.Rejected)
+}
+
+func TestConcurrencyQueueTimeout(t *testing.T) {
+	release := make(chan struct{})
+	upstream := blockingUpstream(release)
+	defer upstream.Close()
+
+	app := helpers.ProxyApp(types.Service{
+		Service:     "concurrency-timeout",
+		Path:        "/e2e/concurrency-timeout",
+		Url:         upstream.URL,
+		Concurrency: &types.Concurrency{MaxInFlight: 1, QueueSize: 5, QueueTimeout: 100},
+	})
+
+	done := make(chan int)
+	go func() {
+		done <- proxyGet(t, app, "/e2e/concurrency-timeout/").StatusCode
+	}()
+	assert.Eventually(t, func() bool {
+		return concurrencyStats(t, "/e2e/concurrency-timeout").InFlight == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	start := time.Now()
+	resp := proxyGet(t, app, "/e2e/concurrency-timeout/")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.Equal(t, 0, concurrencyStats(t, "/e2e/concurrency-timeout").Queued)
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+}
+
+func TestConcurrencyAIMDBacksOffAboveTarget(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+
+	app := helpers.ProxyApp(
+		types.Service{
+			Service:     "concurrency-aimd-slow",
+			Path:        "/e2e/concurrency-aimd-slow",
+			Url:         slow.URL,
+			Concurrency: &types.Concurrency{MaxInFlight: 10, MinInFlight: 2, Adaptive: types.ConcurrencyAIMD, LatencyTarget: 5},
+		},
+		types.Service{
+			Service:     "concurrency-aimd-fast",
+			Path:        "/e2e/concurrency-aimd-fast",
+			Url:         fast.URL,
+			Concurrency: &types.Concurrency{MaxInFlight: 10, Adaptive: types.ConcurrencyAIMD, LatencyTarget: 1000},
+		},
+	)
+
+	for i := 0; i < 30; i++ {
+		assert.Equal(t, http.StatusOK, proxyGet(t, app, "/e2e/concurrency-aimd-slow/").StatusCode)
+		assert.Equal(t, http.StatusOK, proxyGet(t, app, "/e2e/concurrency-aimd-fast/").StatusCode)
+	}
+
+	slowStats := concurrencyStats(t, "/e2e/concurrency-aimd-slow")
+	assert.Less(t, slowStats.Limit, 10)
+	assert.GreaterOrEqual(t, slowStats.Limit, 2)
+	assert.Greater(t, slowStats.Latency, 5.0)
+
+	// Under the target the limit stays at the max
+	assert.Equal(t, 10, concurrencyStats(t, "/e2e/concurrency-aimd-fast").Limit)
+}
+
+func TestConcurrencyGradientFollowsLatency(t *testing.T) {
+	var mu sync.Mutex
+	// A steady base latency, sub-millisecond ones are too noisy
+	delay := 5 * time.Millisecond
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		d := delay
+		mu.Unlock()
+		time.Sleep(d)
+	}))
+	defer upstream.Close()
+
+	app := helpers.ProxyApp(types.Service{
+		Service:     "concurrency-gradient",
+		Path:        "/e2e/concurrency-gradient",
+		Url:         upstream.URL,
+		Concurrency: &types.Concurrency{MaxInFlight: 20, MinInFlight: 2, Adaptive: types.ConcurrencyGradient},
+	})
+
+	for i := 0; i < 30; i++ {
+		proxyGet(t, app, "/e2e/concurrency-gradient/")
+	}
+	fastLimit := concurrencyStats(t, "/e2e/concurrency-gradient").Limit
+
+	// Latency far above the lowest one seen shrinks the limit
+	mu.Lock()
+	delay = 50 * time.Millisecond
+	mu.Unlock()
+	for i := 0; i < 20; i++ {
+		proxyGet(t, app, "/e2e/concurrency-gradient/")
+	}
+
+	stats := concurrencyStats(t, "/e2e/concurrency-gradient")
+	assert.Less(t, stats.Limit, fastLimit)
+	assert.GreaterOrEqual(t, stats.Limit, 2)
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go-gerbang/database"
+	"go-gerbang/e2e/helpers"
+	"go-gerbang/handlers"
+	"go-gerbang/proxyroute"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachePurgeRequiresAdmin(t *testing.T) {
+	client := helpers.NewClient()
+
+	resp, _, err := helpers.DoJSON(client, http.MethodPost, helpers.BaseURL()+"/cache/purge", map[string]interface{}{
+		"prefix": "/api/grc/master",
+	}, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, []int{http.StatusUnauthorized, http.StatusForbidden}, resp.StatusCode)
+}
+
+// cacheApp proxies a random path to upstream with cache, so every run
+// starts without entries in Redis.
+func cacheApp(t *testing.T, upstream *httptest.Server, cache types.Cache) (*fiber.App, string) {
+	require.NoError(t, database.RedisDb.Ping(database.RedisCtx).Err(), "cache tests need Redis")
+
+	path := "/e2e/cache-" + handlers.RandomStringV1(8)
+	app := helpers.ProxyApp(types.Service{
+		Service: "cache-" + path,
+		Path:    path,
+		Url:     upstream.URL,
+		Cache:   &cache,
+	})
+	return app, path
+}
+
+func cacheGet(t *testing.T, app *fiber.App, path string, header map[string]string) (*http.Response, string) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestCacheHitAndPurge(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set(proxyroute.HeaderCacheTag, "e2e-products")
+		w.Write([]byte("products"))
+	}))
+	defer upstream.Close()
+
+	app, path := cacheApp(t, upstream, types.Cache{TTL: 60})
+
+	resp, body := cacheGet(t, app, path+"/list", nil)
+	assert.Equal(t, "MISS", resp.Header.Get(proxyroute.HeaderCache))
+	assert.Equal(t, "products", body)
+
+	resp, body = cacheGet(t, app, path+"/list", nil)
+	assert.Equal(t, "HIT", resp.Header.Get(proxyroute.HeaderCache))
+	assert.Equal(t, "products", body)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// The query is part of the key
+	resp, _ = cacheGet(t, app, path+"/list?page=2", nil)
+	assert.Equal(t, "MISS", resp.Header.Get(proxyroute.HeaderCache))
+
+	purged, err := proxyroute.PurgeCachePrefix(path + "/list")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	resp, _ = cacheGet(t, app, path+"/list", nil)
+	assert.Equal(t, "MISS", resp.Header.Get(proxyroute.HeaderCache))
+
+	_, err = proxyroute.PurgeCacheTag("e2e-products")
+	assert.NoError(t, err)
+	resp, _ = cacheGet(t, app, path+"/list", nil)
+	assert.Equal(t, "MISS", resp.Header.Get(proxyroute.HeaderCache))
+	assert.Equal(t, int32(4), calls.Load())
+}
+
+func TestCacheKeepsEncodingsApart(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(fiber.HeaderVary, fiber.HeaderAcceptEncoding)
+		w.Write([]byte("encoding=" + r.Header.Get(fiber.HeaderAcceptEncoding)))
+	}))
+	defer upstream.Close()
+
+	app, path := cacheApp(t, upstream, types.Cache{TTL: 60})
+
+	_, body := cacheGet(t, app, path+"/", map[string]string{fiber.HeaderAcceptEncoding: "gzip"})
+	assert.Equal(t, "encoding=gzip", body)
+
+	resp, body := cacheGet(t, app, path+"/", map[string]string{fiber.HeaderAcceptEncoding: "identity"})
+	assert.Equal(t, "MISS", resp.Header.Get(proxyroute.HeaderCache))
+	assert.Equal(t, "encoding=identity", body)
+
+	resp, body = cacheGet(t, app, path+"/", map[string]string{fiber.HeaderAcceptEncoding: "gzip"})
+	assert.Equal(t, "HIT", resp.Header.Get(proxyroute.HeaderCache))
+	assert.Equal(t, "encoding=gzip", body)
+}
+
+func TestCacheSkipsVaryOutsideTheKey(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set(fiber.HeaderVary, "X-Tenant")
+		w.Write([]byte("tenant=" + r.Header.Get("X-Tenant")))
+	}))
+	defer upstream.Close()
+
+	app, path := cacheApp(t, upstream, types.Cache{TTL: 60})
+
+	cacheGet(t, app, path+"/", map[string]string{"X-Tenant": "a"})
+	resp, body := cacheGet(t, app, path+"/", map[string]string{"X-Tenant": "b"})
+	assert.Equal(t, "MISS", resp.Header.Get(proxyroute.HeaderCache))
+	assert.Equal(t, "tenant=b", body)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// Once the header is in the key the response is stored per tenant
+	app, path = cacheApp(t, upstream, types.Cache{TTL: 60, Key: types.CacheKey{Headers: []string{"X-Tenant"}}})
+
+	cacheGet(t, app, path+"/", map[string]string{"X-Tenant": "a"})
+	resp, body = cacheGet(t, app, path+"/", map[string]string{"X-Tenant": "a"})
+	assert.Equal(t, "HIT", resp.Header.Get(proxyroute.HeaderCache))
+	assert.Equal(t, "tenant=a", body)
+}
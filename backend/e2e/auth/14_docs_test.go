@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-gerbang/e2e/helpers"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	client := helpers.NewClient()
+
+	resp, err := client.Get(helpers.BaseURL() + "/docs/openapi.json")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	// admin only unless API_DOCS_PUBLIC=true
+	if resp.StatusCode != http.StatusOK {
+		assert.Contains(t, []int{http.StatusUnauthorized, http.StatusForbidden}, resp.StatusCode)
+		return
+	}
+
+	var doc struct {
+		OpenAPI string                            `json:"openapi"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&doc))
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+	assert.Contains(t, doc.Paths, "/api/v1/auth/login")
+	assert.Contains(t, doc.Paths["/api/v1/auth/login"], "post")
+}
+
+const validationSpec = `openapi: 3.0.3
+info: {title: items, version: "1"}
+paths:
+  /items:
+    get:
+      parameters:
+        - {name: page, in: query, required: true, schema: {type: integer}}
+      responses:
+        "200": {description: ok}
+`
+
+func openAPIApp(t *testing.T, spec string) *fiber.App {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(upstream.Close)
+
+	return helpers.ProxyApp(types.Service{
+		Service: "openapi",
+		Path:    "/e2e/openapi",
+		Url:     upstream.URL,
+		OpenAPI: &types.OpenAPI{Spec: spec, Validate: true},
+	})
+}
+
+func TestOpenAPIValidation(t *testing.T) {
+	spec := filepath.Join(t.TempDir(), "items.yaml")
+	require.NoError(t, os.WriteFile(spec, []byte(validationSpec), 0o600))
+	app := openAPIApp(t, spec)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/e2e/openapi/items?page=1", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/e2e/openapi/items?page=x", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/e2e/openapi/other", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestOpenAPIValidationRefusesWithoutSpec(t *testing.T) {
+	app := openAPIApp(t, filepath.Join(t.TempDir(), "missing.yaml"))
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/e2e/openapi/items?page=1", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
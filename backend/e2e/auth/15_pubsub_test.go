@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-gerbang/broker"
+	"go-gerbang/config"
+	"go-gerbang/e2e/helpers"
+	"go-gerbang/handlers"
+	"go-gerbang/middleware"
+	"go-gerbang/models"
+	"go-gerbang/types"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeRequiresAuth(t *testing.T) {
+	client := helpers.NewClient()
+
+	for _, path := range []string{"/subscribe?subject=orders.*", "/subscribe/ws?subject=orders.*"} {
+		resp, err := client.Get(helpers.BaseURL() + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, path)
+		resp.Body.Close()
+	}
+}
+
+func TestValidSubject(t *testing.T) {
+	assert.True(t, middleware.ValidSubject("orders.created", false))
+	assert.True(t, middleware.ValidSubject("orders.*.created", true))
+	assert.True(t, middleware.ValidSubject("orders.>", true))
+
+	for _, subject := range []string{"", "orders..created", "orders created", "orders.*", "orders.>"} {
+		assert.False(t, middleware.ValidSubject(subject, false), subject)
+	}
+	for _, subject := range []string{"orders.>.created", "orders.a*", "orders.>b"} {
+		assert.False(t, middleware.ValidSubject(subject, true), subject)
+	}
+}
+
+func TestSubjectCovers(t *testing.T) {
+	covered := [][2]string{
+		{"orders.created", "orders.created"},
+		{"orders.*", "orders.created"},
+		{"orders.*", "orders.*"},
+		{"orders.>", "orders.created.eu"},
+		{"orders.>", "orders.*"},
+		{"orders.>", "orders.>"},
+		{">", "orders"},
+	}
+	for _, c := range covered {
+		assert.True(t, middleware.SubjectCovers(c[0], c[1]), "%s covers %s", c[0], c[1])
+	}
+
+	notCovered := [][2]string{
+		{"orders.created", "orders.*"},
+		{"orders.*", "orders.>"},
+		{"orders.*", "orders.created.eu"},
+		{"orders.>", "orders"},
+		{"orders.created", "orders.deleted"},
+		{"orders.*.eu", "orders.created"},
+	}
+	for _, c := range notCovered {
+		assert.False(t, middleware.SubjectCovers(c[0], c[1]), "%s does not cover %s", c[0], c[1])
+	}
+}
+
+func TestSubjectsCollide(t *testing.T) {
+	collide := [][2]string{
+		{"orders.created", "orders.created"},
+		{"orders.*", "orders.created"},
+		{"orders.created", "*.created"},
+		{"gateway.>", "gateway.cluster.reload"},
+		{"gateway.>", "*.cluster"},
+		{"audit.>", ">"},
+	}
+	for _, c := range collide {
+		assert.True(t, middleware.SubjectsCollide(c[0], c[1]), "%s collides with %s", c[0], c[1])
+		assert.True(t, middleware.SubjectsCollide(c[1], c[0]), "%s collides with %s", c[1], c[0])
+	}
+
+	apart := [][2]string{
+		{"orders.created", "orders.deleted"},
+		{"orders.*", "orders.created.eu"},
+		{"gateway.>", "gateway"},
+		{"user.notification", "user.*.sent"},
+	}
+	for _, c := range apart {
+		assert.False(t, middleware.SubjectsCollide(c[0], c[1]), "%s is apart from %s", c[0], c[1])
+	}
+}
+
+func TestReservedSubjects(t *testing.T) {
+	handlers.MapMicroServiceMutex.Lock()
+	handlers.MapMicroService = &types.ConfigServices{Services: []types.Service{{
+		Service: "orders-worker",
+		Path:    "/api/orders",
+		Type:    types.ServiceTypeNats,
+		Nats:    &types.NatsTarget{Subject: "svc.orders"},
+	}}}
+	handlers.MapMicroServiceMutex.Unlock()
+
+	for _, subject := range []string{"gateway.cluster.reload", "user.notification", "_INBOX.abc", "$JS.API.INFO", "svc.orders", "svc.*", ">"} {
+		assert.True(t, middleware.IsReservedSubject(subject), subject)
+	}
+	assert.False(t, middleware.IsReservedSubject("orders.created"))
+
+	// Only the gateway publishes audit events, whatever the roles
+	user := &models.UserData{}
+	for _, subject := range []string{"audit.user.login", "audit.>", "svc.orders"} {
+		allowed, err := middleware.HasSubjectPermission(user, subject, middleware.SubjectActPublish)
+		assert.NoError(t, err)
+		assert.False(t, allowed, subject)
+	}
+}
+
+// setNatsConfig points the embedded server at a random port and a temporary
+// store, with the given host and credentials, for the test.
+func setNatsConfig(t *testing.T, host, user, authFile string) {
+	savedHost, savedPort, savedStoreDir := config.NatsHost, config.NatsPort, config.NatsStoreDir
+	savedUser, savedPassword, savedAuthFile := config.NatsUser, config.NatsPassword, config.NatsAuthFile
+	t.Cleanup(func() {
+		config.NatsHost, config.NatsPort, config.NatsStoreDir = savedHost, savedPort, savedStoreDir
+		config.NatsUser, config.NatsPassword, config.NatsAuthFile = savedUser, savedPassword, savedAuthFile
+	})
+
+	config.NatsHost, config.NatsPort, config.NatsStoreDir = host, -1, t.TempDir()
+	config.NatsUser, config.NatsPassword, config.NatsAuthFile = user, "gateway-secret", authFile
+}
+
+func TestNatsServerRefusesAnonymousNetworkBind(t *testing.T) {
+	setNatsConfig(t, "0.0.0.0", "", "")
+	natsServer, err := broker.StartingNatsServer()
+	if natsServer != nil {
+		natsServer.Shutdown()
+	}
+	assert.Error(t, err)
+}
+
+// natsDenied reports whether the server refused the subscription or
+// publish made by do.
+func natsDenied(t *testing.T, url, user string, do func(conn *nats.Conn) error) bool {
+	violations := make(chan error, 1)
+	conn, err := nats.Connect(url, nats.UserInfo(user, user+"-secret"), nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+		if errors.Is(err, nats.ErrPermissionViolation) {
+			select {
+			case violations <- err:
+			default:
+			}
+		}
+	}))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, do(conn))
+	require.NoError(t, conn.Flush())
+
+	select {
+	case <-violations:
+		return true
+	case <-time.After(200 * time.Millisecond):
+		return false
+	}
+}
+
+func TestNatsAuthFileReservesGatewaySubjects(t *testing.T) {
+	authFile := filepath.Join(t.TempDir(), "nats-auth.json")
+	require.NoError(t, os.WriteFile(authFile, []byte(`{"accounts": [
+		{"name": "open", "users": [{"user": "open", "password": "open-secret"}]},
+		{"name": "orders", "users": [{"user": "orders", "password": "orders-secret"}],
+		 "permissions": {"publish": {"allow": [">"]}, "subscribe": {"allow": ["orders.>", "_INBOX.>"]}}},
+		{"name": "auditor", "users": [{"user": "auditor", "password": "auditor-secret"}],
+		 "permissions": {"subscribe": {"allow": ["audit.user.>", "_INBOX.>"]}}}
+	]}`), 0o600))
+	setNatsConfig(t, "127.0.0.1", "gateway", authFile)
+
+	natsServer, err := broker.StartingNatsServer()
+	require.NoError(t, err)
+	defer natsServer.Shutdown()
+	url := natsServer.ClientURL()
+
+	subscribe := func(subject string) func(conn *nats.Conn) error {
+		return func(conn *nats.Conn) error {
+			_, err := conn.SubscribeSync(subject)
+			return err
+		}
+	}
+	publish := func(subject string) func(conn *nats.Conn) error {
+		return func(conn *nats.Conn) error {
+			return conn.Publish(subject, []byte("{}"))
+		}
+	}
+
+	reserved := append([]string{"gateway.cluster", broker.NotificationSubject, "audit.user.create", "$JS.API.INFO"}, types.UserEvents...)
+	for _, subject := range reserved {
+		assert.True(t, natsDenied(t, url, "open", subscribe(subject)), "open subscribe %s", subject)
+		assert.True(t, natsDenied(t, url, "open", publish(subject)), "open publish %s", subject)
+		assert.True(t, natsDenied(t, url, "orders", publish(subject)), "orders publish %s", subject)
+	}
+
+	// replies of other clients, unless the account allows _INBOX.>
+	assert.True(t, natsDenied(t, url, "open", subscribe("_INBOX.>")))
+	assert.False(t, natsDenied(t, url, "open", publish("_INBOX.reply")))
+	assert.False(t, natsDenied(t, url, "orders", subscribe("_INBOX.>")))
+
+	assert.False(t, natsDenied(t, url, "open", subscribe("orders.>")))
+	assert.False(t, natsDenied(t, url, "orders", publish("orders.created")))
+	assert.False(t, natsDenied(t, url, "auditor", subscribe("audit.user.>")))
+	assert.True(t, natsDenied(t, url, "auditor", subscribe("audit.>")))
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-gerbang/e2e/helpers"
+	"go-gerbang/handlers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookRequiresAdmin(t *testing.T) {
+	client := helpers.NewClient()
+
+	endpoints := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/webhook/all"},
+		{http.MethodGet, "/webhook/00000000-0000-0000-0000-000000000000/deliveries"},
+		{http.MethodPost, "/webhook/00000000-0000-0000-0000-000000000000/test"},
+	}
+
+	for _, endpoint := range endpoints {
+		req, _ := http.NewRequest(endpoint.method, helpers.BaseURL()+endpoint.path, nil)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Contains(t, []int{http.StatusUnauthorized, http.StatusForbidden}, resp.StatusCode, endpoint.path)
+		resp.Body.Close()
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"event":"user.created"}`)
+	signature := handlers.SignWebhook("whsec_test", 1700000000, body)
+
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte("1700000000." + string(body)))
+	assert.Equal(t, "t=1700000000,v1="+hex.EncodeToString(mac.Sum(nil)), signature)
+
+	assert.NotEqual(t, signature, handlers.SignWebhook("whsec_other", 1700000000, body))
+	assert.NotEqual(t, signature, handlers.SignWebhook("whsec_test", 1700000001, body))
+	assert.NotEqual(t, signature, handlers.SignWebhook("whsec_test", 1700000000, []byte(`{"event":"user.blocked"}`)))
+}
+
+func TestGenerateWebhookSecret(t *testing.T) {
+	secret := handlers.GenerateWebhookSecret()
+	assert.True(t, strings.HasPrefix(secret, handlers.WebhookSecretPrefix))
+	assert.GreaterOrEqual(t, len(secret), len(handlers.WebhookSecretPrefix)+32)
+	assert.NotEqual(t, secret, handlers.GenerateWebhookSecret())
+}
+
+// webhookReceiver records the deliveries it gets.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	r.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestWebhookDeliveryIsSigned(t *testing.T) {
+	client := helpers.NewClient()
+	token := helpers.AdminToken(t, client)
+	header := map[string]string{"Authorization": token}
+
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	secret := handlers.GenerateWebhookSecret()
+	resp, res, err := helpers.DoJSON(client, http.MethodPost, helpers.BaseURL()+"/webhook/", map[string]interface{}{
+		"name":   "e2e-" + handlers.RandomStringV1(6),
+		"url":    server.URL,
+		"events": []string{"user.*"},
+		"secret": secret,
+	}, header)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.True(t, res.Status, res.Message)
+
+	data := res.Data.(map[string]interface{})
+	idWebhook := data["item"].(map[string]interface{})["idWebhook"].(string)
+	defer helpers.DoJSON(client, http.MethodDelete, helpers.BaseURL()+"/webhook/"+idWebhook, nil, header)
+
+	// The secret is only returned on create
+	_, res, err = helpers.DoJSON(client, http.MethodGet, helpers.BaseURL()+"/webhook/"+idWebhook, nil, header)
+	require.NoError(t, err)
+	assert.NotContains(t, res.Data, "secret")
+
+	resp, res, err = helpers.DoJSON(client, http.MethodPost, helpers.BaseURL()+"/webhook/"+idWebhook+"/test", nil, header)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, res.Status, res.Message)
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	require.Len(t, receiver.requests, 1)
+
+	req, body := receiver.requests[0], receiver.bodies[0]
+	assert.Equal(t, "webhook.test", req.Header.Get("X-Gateway-Event"))
+	assert.Equal(t, "1", req.Header.Get("X-Gateway-Attempt"))
+	assert.NotEmpty(t, req.Header.Get("X-Gateway-Event-Id"))
+
+	// The receiver recomputes the signature from the timestamp it carries
+	signature := req.Header.Get("X-Gateway-Signature")
+	ts, _, ok := strings.Cut(strings.TrimPrefix(signature, "t="), ",")
+	require.True(t, ok, signature)
+	timestamp, err := strconv.ParseInt(ts, 10, 64)
+	require.NoError(t, err)
+	assert.InDelta(t, time.Now().Unix(), timestamp, 60)
+	assert.Equal(t, handlers.SignWebhook(secret, timestamp, body), signature)
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-gerbang/e2e/helpers"
+	"go-gerbang/handlers"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeJSON(t *testing.T, value string) interface{} {
+	var document interface{}
+	require.NoError(t, json.Unmarshal([]byte(value), &document))
+	return document
+}
+
+func encodeJSON(t *testing.T, document interface{}) string {
+	encoded, err := json.Marshal(document)
+	require.NoError(t, err)
+	return string(encoded)
+}
+
+func TestParseJSONPath(t *testing.T) {
+	for _, path := range []string{"$", "$.a", "$.a.b", "$['a b']", "$.items[0]", "$.items[*].id", "$.*", "$..password"} {
+		_, err := handlers.ParseJSONPath(path)
+		assert.NoError(t, err, path)
+	}
+
+	for _, path := range []string{"", "a.b", "$.", "$..", "$..*", "$[", "$[-1]", "$[x]", "$a"} {
+		_, err := handlers.ParseJSONPath(path)
+		assert.Error(t, err, path)
+	}
+}
+
+func TestJSONPathDeleteRenameReplace(t *testing.T) {
+	document := decodeJSON(t, `{"user":{"name":"a","password":"p"},"items":[{"id":1,"secret":"s"},{"id":2,"secret":"t"}],"nested":{"deep":{"password":"q"}}}`)
+
+	for _, path := range []string{"$.user.password", "$.items[*].secret"} {
+		jsonPath, err := handlers.ParseJSONPath(path)
+		require.NoError(t, err)
+		jsonPath.Delete(document)
+	}
+
+	rename, _ := handlers.ParseJSONPath("$.user.name")
+	rename.Rename(document, "username")
+
+	mask, _ := handlers.ParseJSONPath("$..password")
+	mask.Replace(document, "***")
+
+	assert.JSONEq(t, `{"user":{"username":"a"},"items":[{"id":1},{"id":2}],"nested":{"deep":{"password":"***"}}}`, encodeJSON(t, document))
+}
+
+func TestJSONPathPick(t *testing.T) {
+	document := decodeJSON(t, `{"id":1,"user":{"name":"a","email":"e"},"items":[{"id":1,"price":2},{"id":2,"price":3}]}`)
+
+	var kept interface{}
+	for _, path := range []string{"$.id", "$.user.name", "$.items[*].id"} {
+		jsonPath, err := handlers.ParseJSONPath(path)
+		require.NoError(t, err)
+		picked, ok := jsonPath.Pick(document)
+		require.True(t, ok, path)
+		kept = handlers.MergeJSON(kept, picked)
+	}
+	assert.JSONEq(t, `{"id":1,"user":{"name":"a"},"items":[{"id":1},{"id":2}]}`, encodeJSON(t, kept))
+
+	missing, _ := handlers.ParseJSONPath("$.user.phone")
+	_, ok := missing.Pick(document)
+	assert.False(t, ok)
+}
+
+func bodyTransformApp(upstream *httptest.Server) *fiber.App {
+	return helpers.ProxyApp(types.Service{
+		Service: "body-transform",
+		Path:    "/e2e/body-transform",
+		Url:     upstream.URL,
+		BodyTransform: &types.BodyTransforms{
+			Response: &types.BodyTransform{
+				Deny: []string{"$.password"},
+				Mask: []types.FieldMask{{Paths: []string{"$.email"}}},
+			},
+		},
+	})
+}
+
+func bodyTransformGet(t *testing.T, app *fiber.App, path string) (*http.Response, string) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set(fiber.HeaderAcceptEncoding, "gzip")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestBodyTransformDecodesCompressedUpstream(t *testing.T) {
+	// The upstream compresses whenever it is asked to
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		body := `{"name":"a","email":"a@example.com","password":"p"}`
+		if strings.Contains(r.Header.Get(fiber.HeaderAcceptEncoding), "gzip") {
+			w.Header().Set(fiber.HeaderContentEncoding, "gzip")
+			writer := gzip.NewWriter(w)
+			writer.Write([]byte(body))
+			writer.Close()
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	resp, body := bodyTransformGet(t, bodyTransformApp(upstream), "/e2e/body-transform/user")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(fiber.HeaderContentEncoding))
+	assert.JSONEq(t, `{"name":"a","email":"***"}`, body)
+}
+
+func TestBodyTransformRejectsUnreadableResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		switch r.URL.Path {
+		case "/encoded":
+			w.Header().Set(fiber.HeaderContentEncoding, "br")
+			w.Write([]byte("not readable"))
+		default:
+			w.Write([]byte(`{"password":"p"`))
+		}
+	}))
+	defer upstream.Close()
+
+	app := bodyTransformApp(upstream)
+	for _, path := range []string{"/e2e/body-transform/encoded", "/e2e/body-transform/truncated"} {
+		resp, body := bodyTransformGet(t, app, path)
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode, path)
+		assert.NotContains(t, body, "password", path)
+	}
+}
@@ -0,0 +1,70 @@
+package helpers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// LoginToken logs in with identity and password and returns the access token
+// ready to be used as Authorization header.
+func LoginToken(client *http.Client, identity, password string) (string, error) {
+	payload := map[string]string{
+		"identity": identity,
+		"password": password,
+	}
+
+	_, apiRes, err := DoJSON(client, http.MethodPost, BaseURL()+"/api/v1/auth/login", payload, nil)
+	if err != nil {
+		return "", err
+	}
+
+	data, ok := apiRes.Data.(map[string]interface{})
+	if !ok {
+		return "", errors.New(apiRes.Message)
+	}
+
+	token, ok := data["token"].(string)
+	if !ok {
+		return "", errors.New("token is not found on login response")
+	}
+
+	return "Bearer " + token, nil
+}
+
+// LoginUserId returns the idAccount of the logged in user.
+func LoginUserId(client *http.Client, token string) (string, error) {
+	req, _ := http.NewRequest(http.MethodGet, BaseURL()+"/api/v1/auth/get-jwt-info", nil)
+	req.Header.Set("Authorization", token)
+
+	_, apiRes, err := Do(client, req)
+	if err != nil {
+		return "", err
+	}
+
+	data, ok := apiRes.Data.(map[string]interface{})
+	if !ok {
+		return "", errors.New(apiRes.Message)
+	}
+
+	idAccount, _ := data["idAccount"].(string)
+	return idAccount, nil
+}
+
+// AdminToken logs in with E2E_ADMIN_IDENTITY and E2E_ADMIN_PASSWORD, a user
+// holding gateway:admin. The test is skipped when they are not set.
+func AdminToken(t *testing.T, client *http.Client) string {
+	t.Helper()
+
+	identity, password := os.Getenv("E2E_ADMIN_IDENTITY"), os.Getenv("E2E_ADMIN_PASSWORD")
+	if identity == "" || password == "" {
+		t.Skip("E2E_ADMIN_IDENTITY and E2E_ADMIN_PASSWORD are not set")
+	}
+
+	token, err := LoginToken(client, identity, password)
+	if err != nil {
+		t.Fatalf("admin login: %s", err)
+	}
+	return token
+}
@@ -0,0 +1,49 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+
+	"go-gerbang/broker"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// StartJetStream runs an embedded NATS server on a random port for the
+// test and creates the streams of the gateway on it.
+func StartJetStream(t *testing.T) {
+	t.Helper()
+
+	natsServer, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("nats server: %s", err)
+	}
+	go natsServer.Start()
+	if !natsServer.ReadyForConnections(10 * time.Second) {
+		t.Fatalf("nats server is not ready")
+	}
+
+	client, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatalf("nats client: %s", err)
+	}
+
+	broker.NatsClient = client
+	if err := broker.StartingJetStream(); err != nil {
+		t.Fatalf("jetstream: %s", err)
+	}
+
+	t.Cleanup(func() {
+		broker.JetStream = nil
+		broker.NatsClient = nil
+		client.Close()
+		natsServer.Shutdown()
+	})
+}
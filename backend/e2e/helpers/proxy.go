@@ -0,0 +1,26 @@
+package helpers
+
+import (
+	"go-gerbang/handlers"
+	"go-gerbang/proxyroute"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"go.uber.org/zap"
+)
+
+// ProxyApp serves services with the proxy routes of the gateway, without
+// the rest of the gateway, so a test can point them at its own upstream.
+func ProxyApp(services ...types.Service) *fiber.App {
+	if handlers.ZapLogger == nil {
+		handlers.ZapLogger = zap.NewNop()
+	}
+
+	handlers.MapMicroServiceMutex.Lock()
+	handlers.MapMicroService = &types.ConfigServices{Services: services}
+	handlers.MapMicroServiceMutex.Unlock()
+
+	app := fiber.New()
+	proxyroute.RegisterRoutes(app)
+	return app
+}
@@ -74,3 +74,18 @@ func doJSON(
 
 	return resp, &apiRes, nil
 }
+
+func Do(client *http.Client, req *http.Request) (*http.Response, *APIResponse, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(resp.Body)
+
+	var apiRes APIResponse
+	_ = json.Unmarshal(raw, &apiRes)
+
+	return resp, &apiRes, nil
+}
@@ -0,0 +1,93 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-gerbang/broker"
+	"go-gerbang/e2e/helpers"
+	"go-gerbang/services"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterRequiresAdmin(t *testing.T) {
+	client := helpers.NewClient()
+
+	endpoints := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/notification/queue"},
+		{http.MethodGet, "/notification/dead-letter"},
+		{http.MethodPost, "/notification/dead-letter/retry"},
+		{http.MethodDelete, "/notification/dead-letter"},
+	}
+
+	for _, endpoint := range endpoints {
+		req, _ := http.NewRequest(endpoint.method, helpers.BaseURL()+endpoint.path, nil)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, endpoint.path)
+		resp.Body.Close()
+	}
+}
+
+func TestDeadLetterListIsRedacted(t *testing.T) {
+	helpers.StartJetStream(t)
+
+	email := types.SendingEmailToBroker{
+		Sender:   "default",
+		Template: "reset_password",
+		Variables: map[string]string{
+			"username": "test_user",
+			"password": "Rahasia-123",
+			"resetUrl": "https://example.com/auth/forget-password?token=secret",
+		},
+		Body:   `<a href="https://example.com/auth/forget-password?token=secret">reset</a>`,
+		Emails: []types.Email{{Name: "test_user", EmailAddr: "test_user@example.com"}},
+	}
+	data, err := json.Marshal(email)
+	require.NoError(t, err)
+
+	msg := nats.NewMsg(broker.NotificationDeadSubject)
+	msg.Header.Set(broker.DeadLetterHeaderError, "smtp: connection refused")
+	msg.Header.Set(broker.DeadLetterHeaderDeliveries, "5")
+	msg.Data = data
+	_, err = broker.JetStream.PublishMsg(context.Background(), msg)
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Get("/dead-letter", services.GetAllDeadLetter)
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/dead-letter", nil))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+
+	var res struct {
+		Status bool                  `json:"status"`
+		Data   []services.DeadLetter `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(body, &res))
+	require.Len(t, res.Data, 1)
+	assert.Equal(t, 5, res.Data[0].Deliveries)
+	assert.Equal(t, "smtp: connection refused", res.Data[0].Error)
+
+	assert.NotContains(t, string(body), "Rahasia-123")
+	assert.NotContains(t, string(body), "token=secret")
+	assert.Contains(t, string(body), "test_user@example.com")
+
+	var listed types.SendingEmailToBroker
+	require.NoError(t, json.Unmarshal(res.Data[0].Data, &listed))
+	assert.Equal(t, "test_user", listed.Variables["username"])
+	assert.Equal(t, "[REDACTED]", listed.Variables["password"])
+	assert.Equal(t, "[REDACTED]", listed.Variables["resetUrl"])
+	assert.Equal(t, "[REDACTED]", listed.Body)
+}
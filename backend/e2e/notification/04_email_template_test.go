@@ -0,0 +1,122 @@
+package notification
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-gerbang/e2e/helpers"
+	"go-gerbang/handlers"
+	"go-gerbang/services"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailTemplateRequiresAdmin(t *testing.T) {
+	client := helpers.NewClient()
+
+	endpoints := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/email-template/all"},
+		{http.MethodGet, "/email-template/builtin"},
+		{http.MethodPost, "/email-template/preview"},
+		{http.MethodPost, "/email-template/"},
+	}
+
+	for _, endpoint := range endpoints {
+		req, _ := http.NewRequest(endpoint.method, helpers.BaseURL()+endpoint.path, nil)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Contains(t, []int{http.StatusUnauthorized, http.StatusForbidden}, resp.StatusCode, endpoint.path)
+		resp.Body.Close()
+	}
+}
+
+const hostileName = `<script>alert("x")</script>`
+
+func TestRenderEmailTemplateEscapesHtmlOnly(t *testing.T) {
+	content := handlers.EmailTemplateContent{
+		Subject:  "Welcome {{.fullName}}",
+		Title:    "Hi {{.fullName}}",
+		BodyHtml: `<p>{{.fullName}}</p><a href="{{.resetUrl}}">reset</a>`,
+		BodyText: "Hi {{.fullName}}, {{.resetUrl}}",
+		Footer:   "from {{.sender}}",
+	}
+	variables := map[string]string{
+		"fullName": hostileName,
+		"resetUrl": "javascript:alert(1)",
+		"sender":   "<b>GOGERBANG</b>",
+	}
+
+	email, err := handlers.RenderEmailTemplate(content, "en", "", variables)
+	require.NoError(t, err)
+
+	// body, title and footer are escaped in the html part
+	assert.NotContains(t, email.Html, "<script>")
+	assert.NotContains(t, email.Html, "<b>GOGERBANG</b>")
+	assert.Contains(t, email.Html, "&lt;script&gt;")
+	assert.NotContains(t, email.Html, "javascript:")
+	assert.Contains(t, email.Html, "#ZgotmplZ")
+
+	// subject and text are plain text, nothing to escape
+	assert.Equal(t, "Welcome "+hostileName, email.Subject)
+	assert.Contains(t, email.Text, "Hi "+hostileName+", javascript:alert(1)")
+	assert.Contains(t, email.Text, "from <b>GOGERBANG</b>")
+}
+
+func TestParseEmailTemplateReportsSyntaxErrors(t *testing.T) {
+	assert.NoError(t, handlers.ParseEmailTemplate(handlers.EmailTemplateContent{Subject: "{{.fullName}}", BodyHtml: "<p>{{.fullName}}</p>"}))
+	assert.Error(t, handlers.ParseEmailTemplate(handlers.EmailTemplateContent{Subject: "{{.fullName"}))
+	assert.Error(t, handlers.ParseEmailTemplate(handlers.EmailTemplateContent{BodyHtml: "{{if .resetUrl}}"}))
+}
+
+func TestBuiltinEmailTemplatesRender(t *testing.T) {
+	app := fiber.New()
+	app.Get("/email-template/builtin", services.GetBuiltinEmailTemplate)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/email-template/builtin", nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result struct {
+		Data []struct {
+			Name     string                        `json:"name"`
+			Locale   string                        `json:"locale"`
+			Template handlers.EmailTemplateContent `json:"template"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.NotEmpty(t, result.Data)
+
+	resetUrl := "https://example.com/auth/forget-password?token=abc&next=/"
+	variables := map[string]string{
+		"sender":   "GOGERBANG",
+		"fullName": hostileName,
+		"username": "test_user",
+		"email":    "test_user@example.com",
+		"resetUrl": resetUrl,
+	}
+
+	for _, builtin := range result.Data {
+		email, err := handlers.RenderEmailTemplate(builtin.Template, builtin.Locale, "", variables)
+		require.NoError(t, err, builtin.Name+"/"+builtin.Locale)
+
+		assert.NotEmpty(t, email.Subject, builtin.Name)
+		assert.NotContains(t, email.Html, "<script>", builtin.Name)
+		assert.Contains(t, email.Html, `lang="`+builtin.Locale+`"`, builtin.Name)
+		assert.Contains(t, email.Text, hostileName, builtin.Name)
+		assert.NotContains(t, email.Html, "<no value>", builtin.Name)
+		assert.NotContains(t, email.Text, "<no value>", builtin.Name)
+
+		if strings.Contains(builtin.Template.BodyHtml, ".resetUrl") {
+			assert.Contains(t, email.Html, `href="https://example.com/auth/forget-password?token=abc&amp;next=/"`, builtin.Name)
+			assert.Contains(t, email.Text, resetUrl, builtin.Name)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package notification
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go-gerbang/models"
+	"go-gerbang/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountInfoNeverCarriesThePassword(t *testing.T) {
+	user := &models.User{
+		FullName: "Test User",
+		Username: "test_user",
+		Email:    "test_user@example.com",
+		Password: "Rahasia-123",
+	}
+
+	email := services.UserInformationEmail("SMTP", "GOGERBANG", "en", user, "")
+	assert.Equal(t, services.EmailTemplateAccountInfo, email.Template)
+	assert.Equal(t, "test_user", email.Variables["username"])
+	assert.NotContains(t, email.Variables, "password")
+	assert.NotContains(t, email.Variables, "resetUrl")
+
+	setPasswordUrl := "https://example.com/auth/forget-password?token=abc"
+	email = services.UserInformationEmail("SMTP", "GOGERBANG", "en", user, setPasswordUrl)
+	assert.Equal(t, setPasswordUrl, email.Variables["resetUrl"])
+
+	// What the outbox stores
+	raw, err := json.Marshal(email)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "Rahasia-123")
+}
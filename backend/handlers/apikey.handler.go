@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const ApiKeyPrefix = "gg"
+
+// GenerateApiKey returns the raw key shown once to the client, the lookup
+// prefix and the hash that is stored. The raw key has the form
+// gg_<prefix>_<secret>.
+func GenerateApiKey() (raw string, prefix string, hash string) {
+	prefix = strings.ReplaceAll(RandomString(10), "-", "x")
+	secret := RandomStringV1(32)
+	raw = ApiKeyPrefix + "_" + prefix + "_" + secret
+	return raw, prefix, HashApiKey(raw)
+}
+
+func HashApiKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func ParseApiKeyPrefix(raw string) (string, error) {
+	chunks := strings.SplitN(raw, "_", 3)
+	if len(chunks) != 3 || chunks[0] != ApiKeyPrefix || chunks[1] == "" || chunks[2] == "" {
+		return "", fmt.Errorf("malformed api key")
+	}
+	return chunks[1], nil
+}
+
+// IsIpAllowed reports whether ip matches one of the entries, an entry can be
+// a single address or a CIDR block. An empty list allows every address.
+func IsIpAllowed(ip string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	parsed := net.ParseIP(ip)
+	for _, entry := range allowed {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			_, block, err := net.ParseCIDR(entry)
+			if err == nil && parsed != nil && block.Contains(parsed) {
+				return true
+			}
+			continue
+		}
+		if entry == ip {
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,7 +4,9 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"math/big"
 	"net/url"
 	"os"
@@ -99,23 +101,45 @@ var (
 	MapMicroService      *types.ConfigServices
 )
 
+// FindServiceByPath returns the service with the longest path prefix matching
+// path.
+func FindServiceByPath(path string) (types.Service, bool) {
+	MapMicroServiceMutex.RLock()
+	defer MapMicroServiceMutex.RUnlock()
+
+	var found types.Service
+	matched := false
+	if MapMicroService == nil {
+		return found, false
+	}
+
+	for _, service := range MapMicroService.Services {
+		if strings.HasPrefix(path, service.Path) && (!matched || len(service.Path) > len(found.Path)) {
+			found = service
+			matched = true
+		}
+	}
+
+	return found, matched
+}
+
 func LoadConfig(filename string) (*types.ConfigServices, error) {
-	file, err := os.Open(filename)
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("could not open config file: %w", err)
 	}
-	defer file.Close()
 
-	var config types.ConfigServices
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&config); err != nil {
-		return nil, fmt.Errorf("could not decode config file: %w", err)
+	config, _, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not load config file: %w", err)
 	}
 
-	return &config, nil
+	return config, nil
 }
 
-func WatchConfigFile(filename string) {
+// WatchConfigFile reloads MapMicroService when filename changes and calls
+// onReload with the new config, an invalid file keeps the active config.
+func WatchConfigFile(filename string, onReload func(cfg *types.ConfigServices)) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		panic(err)
@@ -135,12 +159,16 @@ func WatchConfigFile(filename string) {
 				// fmt.Println("Config file changed, reloading...")
 				cfg, err := LoadConfig(filename)
 				if err != nil {
-					// fmt.Println("Error reloading config:", err)
+					logConfigReloadError(filename, err)
 					continue
 				}
 				MapMicroServiceMutex.Lock()
 				MapMicroService = cfg
 				MapMicroServiceMutex.Unlock()
+				SyncConfigVersion(cfg)
+				if onReload != nil {
+					onReload(cfg)
+				}
 				// fmt.Println("Config reloaded successfully")
 			}
 		case err := <-watcher.Errors:
@@ -149,6 +177,19 @@ func WatchConfigFile(filename string) {
 	}
 }
 
+// logConfigReloadError reports why a changed config file was not applied,
+// the previous config stays active.
+func logConfigReloadError(filename string, err error) {
+	log.Printf("error: config %s is not reloaded, keeping the active config: %s", filename, err)
+
+	var validationErr *ConfigValidationError
+	if errors.As(err, &validationErr) {
+		for _, issue := range ConfigIssueErrors(validationErr.Issues) {
+			log.Printf("error: config %s: service #%d %s %s: %s", filename, issue.Index, issue.Path, issue.Field, issue.Message)
+		}
+	}
+}
+
 func SaveConfig(filename string, config *types.ConfigServices) error {
 	data, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
@@ -225,6 +266,30 @@ func ForbiddenErrorResponse(c fiber.Ctx, err error) error {
 	})
 }
 
+func TooManyRequestsErrorResponse(c fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusTooManyRequests).JSON(&ErrorStruct{
+		Message: err.Error(),
+		Status:  false,
+		Code:    fiber.StatusTooManyRequests,
+	})
+}
+
+func ServiceUnavailableErrorResponse(c fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusServiceUnavailable).JSON(&ErrorStruct{
+		Message: err.Error(),
+		Status:  false,
+		Code:    fiber.StatusServiceUnavailable,
+	})
+}
+
+func BadGatewayErrorResponse(c fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusBadGateway).JSON(&ErrorStruct{
+		Message: err.Error(),
+		Status:  false,
+		Code:    fiber.StatusBadGateway,
+	})
+}
+
 func UnprocessableEntityErrorResponse(c fiber.Ctx, err error) error {
 	return c.Status(fiber.StatusUnprocessableEntity).JSON(&ErrorStruct{
 		Message: err.Error(),
@@ -233,6 +298,16 @@ func UnprocessableEntityErrorResponse(c fiber.Ctx, err error) error {
 	})
 }
 
+// ValidationErrorResponse rejects a request with the field errors of
+// ValidateStruct.
+func ValidationErrorResponse(c fiber.Ctx, message string, errors map[string]map[string]interface{}) error {
+	return c.Status(fiber.StatusBadRequest).JSON(&SuccessStruct{
+		Status:  false,
+		Message: message,
+		Data:    errors,
+	})
+}
+
 func NotFoundErrorResponse(c fiber.Ctx, err error) error {
 	return c.Status(fiber.StatusNotFound).JSON(&ErrorStruct{
 		Message: err.Error(),
@@ -243,22 +318,31 @@ func NotFoundErrorResponse(c fiber.Ctx, err error) error {
 
 var validate = validator.New()
 
-func ValidateStruct(data interface{}) map[string]map[string]interface{} {
-	errors := make(map[string]map[string]interface{})
+var tagDescriptionValidation = map[string]string{
+	"required": "Tidak Boleh Kosong",
+	"email":    "Harus Email",
+}
 
-	tagDescriptionValidation := map[string]string{
-		"required": "Tidak Boleh Kosong",
-		"email":    "Harus Email",
+// FieldError is one entry of the validation errors returned by
+// ValidateStruct, desc falls back to the description of tag.
+func FieldError(tag string, desc string) map[string]interface{} {
+	if description, ok := tagDescriptionValidation[tag]; ok {
+		desc = description
+	}
+	return map[string]interface{}{
+		"invalid": true,
+		"desc":    desc,
+		"descRaw": tag,
 	}
+}
+
+func ValidateStruct(data interface{}) map[string]map[string]interface{} {
+	errors := make(map[string]map[string]interface{})
 
 	err := validate.Struct(data)
 	if err != nil {
 		for _, err := range err.(validator.ValidationErrors) {
-			errors[LowerFirstCase(err.StructField())] = map[string]interface{}{
-				"invalid": true,
-				"desc":    tagDescriptionValidation[err.Tag()],
-				"descRaw": err.Tag(),
-			}
+			errors[LowerFirstCase(err.StructField())] = FieldError(err.Tag(), "")
 		}
 	}
 
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+const auditRedacted = "[REDACTED]"
+
+// auditSensitiveKeys are never written to the audit trail, matched case
+// insensitively on the JSON key.
+var auditSensitiveKeys = []string{"password", "secret", "token", "authkey", "keyhash", "pinhash"}
+
+// AuditSnapshot turns v into its JSON form with the sensitive fields
+// redacted. nil stays nil so creates and deletes keep an empty side.
+func AuditSnapshot(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+
+	var snapshot interface{}
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return nil
+	}
+
+	return redactAudit(snapshot)
+}
+
+// RedactJSON returns raw with the values of the sensitive fields and of
+// extra keys redacted. Raw that is not JSON can not be inspected and is
+// redacted as a whole.
+func RedactJSON(raw []byte, extra ...string) json.RawMessage {
+	var document interface{}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		quoted, _ := json.Marshal(auditRedacted)
+		return quoted
+	}
+
+	redacted, err := json.Marshal(redactAudit(document, extra...))
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+func redactAudit(v interface{}, extra ...string) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			if isAuditSensitive(k, extra) {
+				if item != nil && item != "" {
+					value[k] = auditRedacted
+				}
+				continue
+			}
+			value[k] = redactAudit(item, extra...)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactAudit(item, extra...)
+		}
+	}
+	return v
+}
+
+func isAuditSensitive(key string, extra []string) bool {
+	key = strings.ToLower(strings.ReplaceAll(key, "_", ""))
+	for _, sensitive := range auditSensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	for _, sensitive := range extra {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// CsvCell quotes a value that a spreadsheet would run as a formula, one
+// starting with =, +, -, @, a tab or a carriage return.
+func CsvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// AuditDiff compares two snapshots field by field. Objects are compared per
+// key, anything else is reported as a whole under "".
+func AuditDiff(before, after interface{}) map[string]AuditChange {
+	diff := map[string]AuditChange{}
+
+	beforeMap, beforeOk := before.(map[string]interface{})
+	afterMap, afterOk := after.(map[string]interface{})
+	if !beforeOk || !afterOk {
+		if !reflect.DeepEqual(before, after) {
+			diff[""] = AuditChange{Before: before, After: after}
+		}
+		return diff
+	}
+
+	for k, b := range beforeMap {
+		a, ok := afterMap[k]
+		if !ok || !reflect.DeepEqual(a, b) {
+			diff[k] = AuditChange{Before: b, After: a}
+		}
+	}
+	for k, a := range afterMap {
+		if _, ok := beforeMap[k]; !ok {
+			diff[k] = AuditChange{Before: nil, After: a}
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"go-gerbang/types"
+)
+
+const (
+	ConfigIssueError   = "error"
+	ConfigIssueWarning = "warning"
+)
+
+// ConfigIssue is one finding of the config validation. Index is the position
+// of the service in the services array, -1 for the document itself.
+type ConfigIssue struct {
+	Index    int    `json:"index"`
+	Service  string `json:"service,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Field    string `json:"field,omitempty"`
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+type ConfigValidationError struct {
+	Issues []ConfigIssue
+}
+
+func (e *ConfigValidationError) Error() string {
+	errs := ConfigIssueErrors(e.Issues)
+	if len(errs) == 0 {
+		return "config is invalid"
+	}
+	return fmt.Sprintf("config is invalid: %s (%d errors)", errs[0].Message, len(errs))
+}
+
+func ConfigIssueErrors(issues []ConfigIssue) []ConfigIssue {
+	errs := []ConfigIssue{}
+	for _, issue := range issues {
+		if issue.Severity == ConfigIssueError {
+			errs = append(errs, issue)
+		}
+	}
+	return errs
+}
+
+// DecodeConfig strictly decodes a config document, unknown fields and
+// trailing data are rejected so typos in field names are not ignored.
+func DecodeConfig(data []byte) (*types.ConfigServices, error) {
+	var config types.ConfigServices
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, &ConfigValidationError{Issues: []ConfigIssue{decodeIssue(data, err)}}
+	}
+	if decoder.More() {
+		return nil, &ConfigValidationError{Issues: []ConfigIssue{{
+			Index:    -1,
+			Code:     "decode",
+			Severity: ConfigIssueError,
+			Message:  "unexpected data after the config document",
+		}}}
+	}
+
+	return &config, nil
+}
+
+func decodeIssue(data []byte, err error) ConfigIssue {
+	issue := ConfigIssue{
+		Index:    -1,
+		Code:     "decode",
+		Severity: ConfigIssueError,
+		Message:  err.Error(),
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		line := bytes.Count(data[:min(int(syntaxErr.Offset), len(data))], []byte("\n")) + 1
+		issue.Message = fmt.Sprintf("line %d: %s", line, syntaxErr.Error())
+	case errors.As(err, &typeErr):
+		issue.Field = typeErr.Field
+	}
+
+	return issue
+}
+
+// ValidateConfig reports every problem of config instead of stopping at the
+// first one.
+func ValidateConfig(config *types.ConfigServices) []ConfigIssue {
+	issues := []ConfigIssue{}
+	paths := map[string]int{}
+
+	add := func(i int, service types.Service, field, code, severity, message string) {
+		issues = append(issues, ConfigIssue{
+			Index:    i,
+			Service:  service.Service,
+			Path:     service.Path,
+			Field:    field,
+			Code:     code,
+			Severity: severity,
+			Message:  message,
+		})
+	}
+
+	for i, service := range config.Services {
+		if service.Service == "" {
+			add(i, service, "service", "missing_name", ConfigIssueWarning, "service has no name, logs and api key scopes can not refer to it")
+		}
+
+		switch {
+		case service.Path == "":
+			add(i, service, "path", "empty_path", ConfigIssueError, "path is required")
+		case !strings.HasPrefix(service.Path, "/"):
+			add(i, service, "path", "invalid_path", ConfigIssueError, "path must start with /")
+		default:
+			if first, ok := paths[service.Path]; ok {
+				add(i, service, "path", "duplicate_path", ConfigIssueError, fmt.Sprintf("path is already used by service #%d", first))
+			} else {
+				paths[service.Path] = i
+			}
+		}
+
+		if service.Type == types.ServiceTypeNats {
+			if service.Nats == nil || service.Nats.Subject == "" {
+				add(i, service, "nats.subject", "empty_nats_subject", ConfigIssueError, "nats.subject is required for a nats service")
+			} else if strings.ContainsAny(service.Nats.Subject, " \t*>") || strings.HasPrefix(service.Nats.Subject, ".") || strings.HasSuffix(service.Nats.Subject, ".") || strings.Contains(service.Nats.Subject, "..") {
+				add(i, service, "nats.subject", "invalid_nats_subject", ConfigIssueError, "nats.subject must be a plain subject, without spaces or wildcards")
+			}
+			if service.Nats != nil && service.Nats.Timeout < 0 {
+				add(i, service, "nats.timeout", "invalid_nats_timeout", ConfigIssueError, "nats.timeout can not be negative")
+			}
+		} else if service.Url == "" {
+			add(i, service, "url", "empty_url", ConfigIssueError, "url is required")
+		} else if u, err := url.Parse(service.Url); err != nil {
+			add(i, service, "url", "invalid_url", ConfigIssueError, err.Error())
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			add(i, service, "url", "invalid_url", ConfigIssueError, "url scheme must be http or https")
+		} else if u.Host == "" {
+			add(i, service, "url", "invalid_url", ConfigIssueError, "url has no host")
+		}
+
+		switch service.Type {
+		case "", types.ServiceTypeHttp:
+		case types.ServiceTypeGrpc:
+			if !strings.HasSuffix(service.Path, "/") {
+				add(i, service, "path", "invalid_grpc_path", ConfigIssueError, "path of a grpc service is its full name with a trailing /, like /orders.v1.OrderService/")
+			}
+			ignored := []struct {
+				field string
+				set   bool
+			}{
+				{"concurrency", service.Concurrency != nil},
+				{"cache", service.Cache != nil},
+				{"coalesce", service.Coalesce},
+				{"body_transform", service.BodyTransform != nil},
+				{"openapi", service.OpenAPI != nil},
+			}
+			for _, option := range ignored {
+				if option.set {
+					add(i, service, option.field, "unsupported_for_grpc", ConfigIssueWarning, option.field+" has no effect on grpc services")
+				}
+			}
+		case types.ServiceTypeNats:
+			if service.UpstreamTLS != nil {
+				add(i, service, "upstream_tls", "unsupported_for_nats", ConfigIssueWarning, "upstream_tls has no effect on nats services")
+			}
+		default:
+			add(i, service, "type", "invalid_type", ConfigIssueError, "type must be http, grpc or nats")
+		}
+
+		if service.Nats != nil && service.Type != types.ServiceTypeNats {
+			add(i, service, "nats", "nats_without_type", ConfigIssueWarning, "nats has no effect unless type is nats")
+		}
+
+		if service.RbacProtection && !service.AuthProtection && !service.MtlsProtection {
+			add(i, service, "rbac_protection", "rbac_without_auth", ConfigIssueError, "rbac_protection needs auth_protection or mtls_protection to know the user")
+		}
+
+		switch service.AuthMode {
+		case "", types.AuthModeJWT, types.AuthModeApiKey, types.AuthModeJWTOrApiKey:
+		default:
+			add(i, service, "auth_mode", "invalid_auth_mode", ConfigIssueError, "auth_mode must be jwt, api_key or jwt_or_api_key")
+		}
+		if service.AuthMode != "" && !service.AuthProtection {
+			add(i, service, "auth_mode", "auth_mode_without_auth", ConfigIssueWarning, "auth_mode has no effect without auth_protection")
+		}
+
+		for r, rule := range service.RateLimits {
+			field := fmt.Sprintf("rate_limits[%d]", r)
+			switch rule.By {
+			case types.RateLimitByIp, types.RateLimitByUser, types.RateLimitByApiKey, types.RateLimitByRoute:
+			default:
+				add(i, service, field+".by", "invalid_rate_limit", ConfigIssueError, "by must be ip, user, api_key or route")
+			}
+			if rule.Limit <= 0 || rule.Window <= 0 {
+				add(i, service, field, "invalid_rate_limit", ConfigIssueError, "limit and window must be greater than 0")
+			}
+			switch rule.Algorithm {
+			case "", types.RateLimitSlidingWindow, types.RateLimitTokenBucket:
+			default:
+				add(i, service, field+".algorithm", "invalid_rate_limit", ConfigIssueError, "algorithm must be sliding_window or token_bucket")
+			}
+			if (rule.By == types.RateLimitByUser || rule.By == types.RateLimitByApiKey) && !service.AuthProtection && !service.MtlsProtection {
+				add(i, service, field+".by", "rate_limit_without_auth", ConfigIssueWarning, "the caller is never authenticated, the rule counts by IP")
+			}
+		}
+
+		if limit := service.Concurrency; limit != nil {
+			if limit.MaxInFlight <= 0 {
+				add(i, service, "concurrency.max_in_flight", "invalid_concurrency", ConfigIssueError, "max_in_flight must be greater than 0")
+			}
+			if limit.MinInFlight < 0 || limit.MinInFlight > limit.MaxInFlight {
+				add(i, service, "concurrency.min_in_flight", "invalid_concurrency", ConfigIssueError, "min_in_flight must be between 0 and max_in_flight")
+			}
+			if limit.QueueSize < 0 || limit.QueueTimeout < 0 || limit.LatencyTarget < 0 {
+				add(i, service, "concurrency", "invalid_concurrency", ConfigIssueError, "queue_size, queue_timeout and latency_target can not be negative")
+			}
+			switch limit.Adaptive {
+			case "", types.ConcurrencyGradient:
+			case types.ConcurrencyAIMD:
+				if limit.LatencyTarget == 0 {
+					add(i, service, "concurrency.latency_target", "invalid_concurrency", ConfigIssueError, "aimd needs a latency_target")
+				}
+			default:
+				add(i, service, "concurrency.adaptive", "invalid_concurrency", ConfigIssueError, "adaptive must be aimd or gradient")
+			}
+		}
+
+		if cache := service.Cache; cache != nil {
+			if cache.TTL <= 0 {
+				add(i, service, "cache.ttl", "invalid_cache", ConfigIssueError, "ttl must be greater than 0")
+			}
+			if cache.StaleWhileRevalidate < 0 || cache.MaxBodySize < 0 {
+				add(i, service, "cache", "invalid_cache", ConfigIssueError, "stale_while_revalidate and max_body_size can not be negative")
+			}
+			for _, prefix := range cache.Paths {
+				if !strings.HasPrefix(prefix, service.Path) {
+					add(i, service, "cache.paths", "invalid_cache", ConfigIssueError, fmt.Sprintf("%q is not under the service path", prefix))
+				}
+			}
+			if (service.AuthProtection || service.MtlsProtection) && !cache.Key.User && !cache.Key.Role {
+				add(i, service, "cache.key", "shared_private_cache", ConfigIssueError, "the service needs auth, the cache key must vary by user or role")
+			}
+		}
+
+		if policy := service.Headers; policy != nil {
+			for _, issue := range validateHeaderRules(policy.Request) {
+				add(i, service, "headers.request", "invalid_header_rule", ConfigIssueError, issue)
+			}
+			for _, issue := range validateHeaderRules(policy.Response) {
+				add(i, service, "headers.response", "invalid_header_rule", ConfigIssueError, issue)
+			}
+		}
+
+		if transform := service.BodyTransform; transform != nil {
+			for _, issue := range validateBodyTransform(transform.Request) {
+				add(i, service, "body_transform.request", "invalid_body_transform", ConfigIssueError, issue)
+			}
+			for _, issue := range validateBodyTransform(transform.Response) {
+				add(i, service, "body_transform.response", "invalid_body_transform", ConfigIssueError, issue)
+			}
+			if transform.Request != nil && transform.Request.Envelope {
+				add(i, service, "body_transform.request.envelope", "invalid_body_transform", ConfigIssueWarning, "envelope only applies to responses")
+			}
+		}
+
+		if spec := service.OpenAPI; spec != nil && strings.TrimSpace(spec.Spec) == "" {
+			add(i, service, "openapi.spec", "invalid_openapi", ConfigIssueError, "spec is required")
+		}
+
+		if tls := service.UpstreamTLS; tls != nil {
+			if (tls.CertFile == "") != (tls.KeyFile == "") {
+				add(i, service, "upstream_tls", "incomplete_client_cert", ConfigIssueError, "cert_file and key_file must be set together")
+			}
+			if strings.HasPrefix(service.Url, "http://") {
+				add(i, service, "upstream_tls", "tls_on_http", ConfigIssueWarning, "upstream_tls is ignored for an http url")
+			}
+		}
+	}
+
+	return issues
+}
+
+// ParseConfig decodes and validates data, the returned error is a
+// *ConfigValidationError when the document has errors.
+func ParseConfig(data []byte) (*types.ConfigServices, []ConfigIssue, error) {
+	config, err := DecodeConfig(data)
+	if err != nil {
+		var validationErr *ConfigValidationError
+		if errors.As(err, &validationErr) {
+			return nil, validationErr.Issues, err
+		}
+		return nil, nil, err
+	}
+
+	issues := ValidateConfig(config)
+	if len(ConfigIssueErrors(issues)) > 0 {
+		return config, issues, &ConfigValidationError{Issues: issues}
+	}
+
+	return config, issues, nil
+}
+
+var headerTemplateVariable = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+func validateHeaderRules(rules *types.HeaderRules) []string {
+	if rules == nil {
+		return nil
+	}
+
+	issues := []string{}
+	names := append([]string{}, rules.Remove...)
+	for from, to := range rules.Rename {
+		names = append(names, from, to)
+	}
+
+	values := map[string]string{}
+	for name, value := range rules.Set {
+		names = append(names, name)
+		values[name] = value
+	}
+	for name, value := range rules.Add {
+		names = append(names, name)
+		values[name] = value
+	}
+
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" || strings.ContainsAny(name, " :\r\n") {
+			issues = append(issues, fmt.Sprintf("invalid header name %q", name))
+		}
+	}
+
+	for name, value := range values {
+		if strings.ContainsAny(value, "\r\n") {
+			issues = append(issues, fmt.Sprintf("value of %s has a line break", name))
+		}
+		for _, match := range headerTemplateVariable.FindAllStringSubmatch(value, -1) {
+			if !slices.Contains(types.HeaderTemplateVariables, match[1]) {
+				issues = append(issues, fmt.Sprintf("unknown variable ${%s} in %s", match[1], name))
+			}
+		}
+	}
+
+	sort.Strings(issues)
+	return issues
+}
+
+func validateBodyTransform(transform *types.BodyTransform) []string {
+	if transform == nil {
+		return nil
+	}
+
+	issues := []string{}
+	check := func(path string, field bool) {
+		parsed, err := ParseJSONPath(path)
+		if err != nil {
+			issues = append(issues, err.Error())
+		} else if field && !parsed.EndsWithField() {
+			issues = append(issues, fmt.Sprintf("json path %q must select object fields", path))
+		}
+	}
+
+	for _, path := range transform.Allow {
+		check(path, false)
+	}
+	for _, path := range transform.Deny {
+		check(path, true)
+	}
+	for path, to := range transform.Rename {
+		check(path, true)
+		if strings.TrimSpace(to) == "" {
+			issues = append(issues, fmt.Sprintf("rename of %q has no new name", path))
+		}
+	}
+	for _, mask := range transform.Mask {
+		if len(mask.Paths) == 0 {
+			issues = append(issues, "mask needs paths")
+		}
+		for _, path := range mask.Paths {
+			check(path, false)
+		}
+	}
+
+	sort.Strings(issues)
+	return issues
+}
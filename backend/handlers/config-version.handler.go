@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"go-gerbang/models"
+	"go-gerbang/types"
+
+	"gorm.io/gorm"
+)
+
+// ActiveConfigVersion is the version of the config currently loaded in
+// MapMicroService, 0 when it is not known yet.
+var ActiveConfigVersion atomic.Int64
+
+// configVersionMutex serializes writing the file and recording its version,
+// so the watcher sees the version of a write before it looks for it.
+var configVersionMutex sync.Mutex
+
+type ServiceChange struct {
+	Path   string                 `json:"path"`
+	Before types.Service          `json:"before"`
+	After  types.Service          `json:"after"`
+	Fields map[string]AuditChange `json:"fields"`
+}
+
+type ConfigDiff struct {
+	Added   []types.Service `json:"added"`
+	Removed []types.Service `json:"removed"`
+	Changed []ServiceChange `json:"changed"`
+}
+
+func ConfigChecksum(config *types.ConfigServices) (string, []byte, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), data, nil
+}
+
+// SaveConfigVersion stores config as a new version and writes it to
+// filename in the same transaction, a failed write leaves no version and a
+// failed insert leaves the file untouched. Without the versions table only
+// the file is written.
+func SaveConfigVersion(filename string, config *types.ConfigServices, version *models.ConfigVersion) error {
+	configVersionMutex.Lock()
+	defer configVersionMutex.Unlock()
+
+	if !models.HasConfigVersionTable() {
+		return SaveConfig(filename, config)
+	}
+
+	if err := fillConfigVersion(config, version); err != nil {
+		return err
+	}
+
+	if err := models.CreateConfigVersionWith(version, func() error {
+		return SaveConfig(filename, config)
+	}); err != nil {
+		return err
+	}
+
+	ActiveConfigVersion.Store(int64(version.Version))
+	return nil
+}
+
+// RecordConfigVersion stores config as a new version when it was applied
+// somewhere else than the config file.
+func RecordConfigVersion(config *types.ConfigServices, version *models.ConfigVersion) error {
+	configVersionMutex.Lock()
+	defer configVersionMutex.Unlock()
+
+	return recordConfigVersion(config, version)
+}
+
+func recordConfigVersion(config *types.ConfigServices, version *models.ConfigVersion) error {
+	if !models.HasConfigVersionTable() {
+		return nil
+	}
+
+	if err := fillConfigVersion(config, version); err != nil {
+		return err
+	}
+
+	if err := models.CreateConfigVersion(version).Error; err != nil {
+		return err
+	}
+
+	ActiveConfigVersion.Store(int64(version.Version))
+	return nil
+}
+
+func fillConfigVersion(config *types.ConfigServices, version *models.ConfigVersion) error {
+	checksum, data, err := ConfigChecksum(config)
+	if err != nil {
+		return err
+	}
+
+	version.Checksum = checksum
+	version.Config = data
+	version.ServiceSize = len(config.Services)
+	return nil
+}
+
+// SyncConfigVersion sets ActiveConfigVersion for a config read from disk. A
+// config that matches no stored version was edited outside of the API and
+// is recorded with the "file" source.
+func SyncConfigVersion(config *types.ConfigServices) {
+	configVersionMutex.Lock()
+	defer configVersionMutex.Unlock()
+
+	if !models.HasConfigVersionTable() {
+		return
+	}
+
+	checksum, _, err := ConfigChecksum(config)
+	if err != nil {
+		log.Printf("error: config version checksum: %s", err)
+		return
+	}
+
+	latest := new(models.ConfigVersion)
+	err = models.FindLatestConfigVersionByChecksum(latest, checksum)
+	if err == nil {
+		ActiveConfigVersion.Store(int64(latest.Version))
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("error: config version lookup: %s", err)
+		return
+	}
+
+	version := &models.ConfigVersion{
+		Source:  models.ConfigVersionSourceFile,
+		Comment: "changed on disk",
+	}
+	if err := recordConfigVersion(config, version); err != nil {
+		log.Printf("error: record config version: %s", err)
+	}
+}
+
+// DiffConfigServices compares two configs by service path.
+func DiffConfigServices(from, to *types.ConfigServices) ConfigDiff {
+	diff := ConfigDiff{
+		Added:   []types.Service{},
+		Removed: []types.Service{},
+		Changed: []ServiceChange{},
+	}
+
+	before := map[string]types.Service{}
+	for _, service := range from.Services {
+		before[service.Path] = service
+	}
+
+	after := map[string]types.Service{}
+	for _, service := range to.Services {
+		after[service.Path] = service
+
+		old, ok := before[service.Path]
+		if !ok {
+			diff.Added = append(diff.Added, service)
+			continue
+		}
+
+		// Status is runtime health, not configuration
+		old.Status, service.Status = false, false
+		fields := AuditDiff(serviceFields(old), serviceFields(service))
+		if len(fields) > 0 {
+			diff.Changed = append(diff.Changed, ServiceChange{
+				Path:   service.Path,
+				Before: old,
+				After:  service,
+				Fields: fields,
+			})
+		}
+	}
+
+	for _, service := range from.Services {
+		if _, ok := after[service.Path]; !ok {
+			diff.Removed = append(diff.Removed, service)
+		}
+	}
+
+	return diff
+}
+
+func serviceFields(service types.Service) interface{} {
+	raw, err := json.Marshal(service)
+	if err != nil {
+		return nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
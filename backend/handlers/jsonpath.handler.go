@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSONPath supports the subset used by the body transforms: $ for the
+// root, .name or ['name'] for a field, [n] for an array index, .* or [*]
+// for every member and ..name for a field at any depth. Paths work on
+// values decoded by encoding/json into map[string]interface{} and
+// []interface{}.
+type JSONPath []jsonPathToken
+
+type jsonPathKind int
+
+const (
+	jsonPathField jsonPathKind = iota
+	jsonPathIndex
+	jsonPathWildcard
+	jsonPathDescendant
+)
+
+type jsonPathToken struct {
+	kind  jsonPathKind
+	name  string
+	index int
+}
+
+func ParseJSONPath(path string) (JSONPath, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("json path %q must start with $", path)
+	}
+
+	tokens := JSONPath{}
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			name, remaining := cutJSONPathName(rest[2:])
+			if name == "" || name == "*" {
+				return nil, fmt.Errorf("json path %q: .. needs a field name", path)
+			}
+			tokens = append(tokens, jsonPathToken{kind: jsonPathDescendant, name: name})
+			rest = remaining
+
+		case strings.HasPrefix(rest, "."):
+			name, remaining := cutJSONPathName(rest[1:])
+			switch name {
+			case "":
+				return nil, fmt.Errorf("json path %q: empty field name", path)
+			case "*":
+				tokens = append(tokens, jsonPathToken{kind: jsonPathWildcard})
+			default:
+				tokens = append(tokens, jsonPathToken{kind: jsonPathField, name: name})
+			}
+			rest = remaining
+
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("json path %q: missing ]", path)
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+
+			switch {
+			case inner == "*":
+				tokens = append(tokens, jsonPathToken{kind: jsonPathWildcard})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				tokens = append(tokens, jsonPathToken{kind: jsonPathField, name: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("json path %q: invalid index %q", path, inner)
+				}
+				tokens = append(tokens, jsonPathToken{kind: jsonPathIndex, index: index})
+			}
+
+		default:
+			return nil, fmt.Errorf("json path %q: unexpected %q", path, rest)
+		}
+	}
+
+	return tokens, nil
+}
+
+func cutJSONPathName(value string) (string, string) {
+	end := strings.IndexAny(value, ".[")
+	if end < 0 {
+		return value, ""
+	}
+	return value[:end], value[end:]
+}
+
+// EndsWithField reports whether the path selects object members, which is
+// required to remove or rename what it selects.
+func (p JSONPath) EndsWithField() bool {
+	if len(p) == 0 {
+		return false
+	}
+	kind := p[len(p)-1].kind
+	return kind == jsonPathField || kind == jsonPathWildcard || kind == jsonPathDescendant
+}
+
+// Each calls fn with the container and the key (string for objects, int
+// for arrays) of every value the path selects.
+func (p JSONPath) Each(node interface{}, fn func(parent interface{}, key interface{})) {
+	if len(p) == 0 {
+		return
+	}
+
+	token, rest := p[0], p[1:]
+	last := len(rest) == 0
+
+	switch token.kind {
+	case jsonPathField:
+		if object, ok := node.(map[string]interface{}); ok {
+			if value, ok := object[token.name]; ok {
+				if last {
+					fn(object, token.name)
+				} else {
+					rest.Each(value, fn)
+				}
+			}
+		}
+
+	case jsonPathIndex:
+		if array, ok := node.([]interface{}); ok && token.index < len(array) {
+			if last {
+				fn(array, token.index)
+			} else {
+				rest.Each(array[token.index], fn)
+			}
+		}
+
+	case jsonPathWildcard:
+		switch value := node.(type) {
+		case map[string]interface{}:
+			for _, key := range jsonPathKeys(value) {
+				if last {
+					fn(value, key)
+				} else {
+					rest.Each(value[key], fn)
+				}
+			}
+		case []interface{}:
+			for i := range value {
+				if last {
+					fn(value, i)
+				} else {
+					rest.Each(value[i], fn)
+				}
+			}
+		}
+
+	case jsonPathDescendant:
+		field := append(JSONPath{{kind: jsonPathField, name: token.name}}, rest...)
+		switch value := node.(type) {
+		case map[string]interface{}:
+			field.Each(value, fn)
+			for _, key := range jsonPathKeys(value) {
+				p.Each(value[key], fn)
+			}
+		case []interface{}:
+			for _, item := range value {
+				p.Each(item, fn)
+			}
+		}
+	}
+}
+
+// Delete removes the object members the path selects.
+func (p JSONPath) Delete(node interface{}) {
+	p.Each(node, func(parent interface{}, key interface{}) {
+		if object, ok := parent.(map[string]interface{}); ok {
+			delete(object, key.(string))
+		}
+	})
+}
+
+// Rename moves the object members the path selects to name.
+func (p JSONPath) Rename(node interface{}, name string) {
+	p.Each(node, func(parent interface{}, key interface{}) {
+		if object, ok := parent.(map[string]interface{}); ok && key.(string) != name {
+			object[name] = object[key.(string)]
+			delete(object, key.(string))
+		}
+	})
+}
+
+// Replace sets every value the path selects to value.
+func (p JSONPath) Replace(node interface{}, value interface{}) {
+	p.Each(node, func(parent interface{}, key interface{}) {
+		switch container := parent.(type) {
+		case map[string]interface{}:
+			container[key.(string)] = value
+		case []interface{}:
+			container[key.(int)] = value
+		}
+	})
+}
+
+// Pick returns a copy of node holding only what the path selects, keeping
+// the objects and arrays around it.
+func (p JSONPath) Pick(node interface{}) (interface{}, bool) {
+	if len(p) == 0 {
+		return node, true
+	}
+
+	token, rest := p[0], p[1:]
+
+	switch token.kind {
+	case jsonPathField:
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok := object[token.name]
+		if !ok {
+			return nil, false
+		}
+		picked, ok := rest.Pick(value)
+		if !ok {
+			return nil, false
+		}
+		return map[string]interface{}{token.name: picked}, true
+
+	case jsonPathIndex:
+		array, ok := node.([]interface{})
+		if !ok || token.index >= len(array) {
+			return nil, false
+		}
+		picked, ok := rest.Pick(array[token.index])
+		if !ok {
+			return nil, false
+		}
+		result := make([]interface{}, token.index+1)
+		result[token.index] = picked
+		return result, true
+
+	case jsonPathWildcard:
+		switch value := node.(type) {
+		case map[string]interface{}:
+			result := map[string]interface{}{}
+			for key, item := range value {
+				if picked, ok := rest.Pick(item); ok {
+					result[key] = picked
+				}
+			}
+			return result, len(result) > 0
+		case []interface{}:
+			result := make([]interface{}, len(value))
+			found := false
+			for i, item := range value {
+				if picked, ok := rest.Pick(item); ok {
+					result[i] = picked
+					found = true
+				}
+			}
+			return result, found
+		}
+		return nil, false
+
+	case jsonPathDescendant:
+		field := append(JSONPath{{kind: jsonPathField, name: token.name}}, rest...)
+		switch value := node.(type) {
+		case map[string]interface{}:
+			var result interface{}
+			found := false
+			if picked, ok := field.Pick(value); ok {
+				result, found = picked, true
+			}
+			for key, item := range value {
+				if picked, ok := p.Pick(item); ok {
+					result = MergeJSON(result, map[string]interface{}{key: picked})
+					found = true
+				}
+			}
+			return result, found
+		case []interface{}:
+			result := make([]interface{}, len(value))
+			found := false
+			for i, item := range value {
+				if picked, ok := p.Pick(item); ok {
+					result[i] = picked
+					found = true
+				}
+			}
+			return result, found
+		}
+	}
+
+	return nil, false
+}
+
+// MergeJSON merges b into a, objects by key and arrays by index.
+func MergeJSON(a, b interface{}) interface{} {
+	switch bValue := b.(type) {
+	case map[string]interface{}:
+		aValue, ok := a.(map[string]interface{})
+		if !ok {
+			return b
+		}
+		for key, item := range bValue {
+			aValue[key] = MergeJSON(aValue[key], item)
+		}
+		return aValue
+	case []interface{}:
+		aValue, ok := a.([]interface{})
+		if !ok {
+			return b
+		}
+		for len(aValue) < len(bValue) {
+			aValue = append(aValue, nil)
+		}
+		for i, item := range bValue {
+			if item != nil {
+				aValue[i] = MergeJSON(aValue[i], item)
+			}
+		}
+		return aValue
+	case nil:
+		return a
+	}
+	return b
+}
+
+func jsonPathKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	return keys
+}
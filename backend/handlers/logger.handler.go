@@ -156,6 +156,14 @@ func StartLogWorker(ctx context.Context, queue <-chan logEntry) {
 			}
 			entry.Duration = durationVal
 
+			if cache, ok := fieldMap["cache"].(string); ok {
+				entry.Cache = cache
+			}
+			if grpcStatus, ok := fieldMap["grpc_status"].(int64); ok {
+				status := int16(grpcStatus)
+				entry.GrpcStatus = &status
+			}
+
 			if err := database.GDB.WithContext(ctx).Create(&entry).Error; err != nil {
 				fmt.Fprintf(os.Stderr, "GORM log insert failed: %v\n", err)
 			}
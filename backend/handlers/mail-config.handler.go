@@ -69,6 +69,17 @@ func IsValidEmail(email string) bool {
 // 	return result
 // }
 
+// InvalidateMailConfig drops the cached mail configuration, the next call
+// reads it again from the configurations table.
+func InvalidateMailConfig() {
+	mailMutex.Lock()
+	emailServiceNameLastRefresh = time.Time{}
+	emailServiceUrlLastRefresh = time.Time{}
+	emailResendConfigLastRefresh = time.Time{}
+	emailSMTPConfigLastRefresh = time.Time{}
+	mailMutex.Unlock()
+}
+
 func GetEmailSendApi() string {
 	mailMutex.RLock()
 	if time.Since(emailServiceUrlLastRefresh) < cacheTTL && len(emailServiceUrl) > 0 {
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+)
+
+// EmailTemplateContent is the source of an email, Go templates executed
+// with the variables of the notification. BodyHtml is an html/template so
+// the variables are escaped, the other parts are text.
+type EmailTemplateContent struct {
+	Subject  string `json:"subject"`
+	Title    string `json:"title"`
+	BodyHtml string `json:"bodyHtml"`
+	BodyText string `json:"bodyText"`
+	Footer   string `json:"footer"`
+}
+
+type RenderedEmail struct {
+	Subject string `json:"subject"`
+	Html    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+var emailLayout = htmltemplate.Must(htmltemplate.New("layout").Parse(`<div style="margin: 0px; padding: 0px;" bgcolor="#FFFFFF">
+		<table width="100%" height="100%" style="min-width: 348px;" border="0" cellspacing="0" cellpadding="0" lang="{{.Locale}}">
+			<tbody>
+				<tr height="32" style="height: 32px;">
+					<td></td>
+				</tr>
+				<tr align="center">
+					<td>
+						<table border="0" cellspacing="0" cellpadding="0" style="padding-bottom: 20px; max-width: 516px; min-width: 220px;">
+							<tbody>
+								<tr>
+									<td width="8" style="width: 8px;"></td>
+									<td>
+										<div style="border-style: solid; border-width: thin; border-color: rgb(218, 220, 224); border-radius: 8px; padding: 40px 20px;" align="center">
+											{{.Image}}
+											<div style="font-family: Google Sans, Roboto, RobotoDraft, Helvetica, Arial, sans-serif; border-bottom: thin solid rgb(218, 220, 224); color: rgba(0, 0, 0, 0.87); line-height: 32px; padding-bottom: 24px; text-align: center; word-break: break-word;">
+												<div style="font-size: 24px;">
+													{{.Title}}
+												</div>
+											</div>
+											<div style="font-family: Roboto-Regular, Helvetica, Arial, sans-serif; font-size: 14px; color: rgba(0, 0, 0, 0.87); line-height: 20px; padding-top: 20px; text-align: center;">
+												{{.Body}}
+											</div>
+										</div>
+										<div style="font-family: Roboto-Regular, Helvetica, Arial, sans-serif; color: rgba(0, 0, 0, 0.54); font-size: 11px; line-height: 18px; padding-top: 12px; text-align: center;">
+											{{.Footer}}
+										</div>
+									</td>
+									<td width="8" style="width: 8px;"></td>
+								</tr>
+							</tbody>
+						</table>
+					</td>
+				</tr>
+				<tr height="32" style="height: 32px;">
+					<td></td>
+				</tr>
+			</tbody>
+		</table>
+	</div>`))
+
+// ParseEmailTemplate reports the first syntax error of content.
+func ParseEmailTemplate(content EmailTemplateContent) error {
+	for _, part := range []string{content.Subject, content.Title, content.BodyText, content.Footer} {
+		if _, err := template.New("part").Parse(part); err != nil {
+			return err
+		}
+	}
+	_, err := htmltemplate.New("body").Parse(content.BodyHtml)
+	return err
+}
+
+// RenderEmailTemplate executes content with variables and wraps the html
+// body in the email layout. image is the logo of the sender, it comes from
+// the mail configuration and is not escaped.
+func RenderEmailTemplate(content EmailTemplateContent, locale string, image string, variables map[string]string) (*RenderedEmail, error) {
+	subject, err := renderEmailText(content.Subject, variables)
+	if err != nil {
+		return nil, err
+	}
+	title, err := renderEmailText(content.Title, variables)
+	if err != nil {
+		return nil, err
+	}
+	text, err := renderEmailText(content.BodyText, variables)
+	if err != nil {
+		return nil, err
+	}
+	footer, err := renderEmailText(content.Footer, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := htmltemplate.New("body").Parse(content.BodyHtml)
+	if err != nil {
+		return nil, err
+	}
+	var html strings.Builder
+	if err := body.Execute(&html, variables); err != nil {
+		return nil, err
+	}
+
+	layout, err := RenderEmailLayout(locale, image, title, htmltemplate.HTML(html.String()), footer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RenderedEmail{
+		Subject: strings.TrimSpace(subject),
+		Html:    layout,
+		Text:    strings.TrimSpace(title + "\n\n" + text + "\n\n" + footer),
+	}, nil
+}
+
+// RenderEmailLayout wraps a body that is already html, title and footer
+// are escaped.
+func RenderEmailLayout(locale string, image string, title string, body htmltemplate.HTML, footer string) (string, error) {
+	var html strings.Builder
+	err := emailLayout.Execute(&html, map[string]interface{}{
+		"Locale": locale,
+		"Image":  htmltemplate.HTML(image),
+		"Title":  title,
+		"Body":   body,
+		"Footer": footer,
+	})
+	return html.String(), err
+}
+
+func renderEmailText(source string, variables map[string]string) (string, error) {
+	tmpl, err := template.New("part").Parse(source)
+	if err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+	if err := tmpl.Execute(&text, variables); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
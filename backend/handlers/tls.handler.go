@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// CertReloader keeps a key pair and an optional CA bundle in memory and
+// reloads them when the files change on disk, so certificates can be rotated
+// without restarting the gateway.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	cas  *x509.CertPool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewCertReloader(certFile, keyFile, caFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		stop:     make(chan struct{}),
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *CertReloader) Reload() error {
+	var cert *tls.Certificate
+	if r.certFile != "" || r.keyFile != "" {
+		pair, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return fmt.Errorf("could not load key pair %s: %w", r.certFile, err)
+		}
+		cert = &pair
+	}
+
+	var cas *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(filepath.Clean(r.caFile))
+		if err != nil {
+			return fmt.Errorf("could not read CA bundle %s: %w", r.caFile, err)
+		}
+		cas = x509.NewCertPool()
+		if !cas.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in CA bundle %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = cert
+	r.cas = cas
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.cert == nil {
+		return nil, fmt.Errorf("no server certificate loaded")
+	}
+	return r.cert, nil
+}
+
+// GetClientCertificate is used when the gateway itself is the TLS client,
+// an empty certificate is sent when no key pair is configured.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+	return r.cert, nil
+}
+
+func (r *CertReloader) CertPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cas
+}
+
+// ServerTLSConfig builds the listener config, the CA bundle is resolved per
+// handshake so a reloaded bundle is picked up by new connections.
+func (r *CertReloader) ServerTLSConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	base := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+		ClientAuth:     clientAuth,
+	}
+
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = r.CertPool()
+		return cfg, nil
+	}
+
+	return base
+}
+
+// UpstreamTLSConfig builds the config of the gateway as a TLS client. The
+// server chain is verified against the CA bundle of each handshake, or the
+// system roots without one, so a reloaded bundle applies to new connections.
+func (r *CertReloader) UpstreamTLSConfig(serverName string, insecureSkipVerify bool) *tls.Config {
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		ServerName:           serverName,
+		InsecureSkipVerify:   true,
+		GetClientCertificate: r.GetClientCertificate,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if insecureSkipVerify {
+				return nil
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("upstream sent no certificate")
+			}
+
+			opts := x509.VerifyOptions{
+				Roots:         r.CertPool(),
+				DNSName:       cs.ServerName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		},
+	}
+}
+
+// Close stops Watch.
+func (r *CertReloader) Close() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
+// Watch reloads the files when they change, until Close is called.
+func (r *CertReloader) Watch() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Error watching certificates: %v", err)
+		return
+	}
+	defer watcher.Close()
+
+	files := map[string]struct{}{}
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file == "" {
+			continue
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			continue
+		}
+		files[abs] = struct{}{}
+		// Watch the directory, secrets are usually replaced by a rename
+		if err := watcher.Add(filepath.Dir(abs)); err != nil {
+			log.Printf("Error watching %s: %v", filepath.Dir(abs), err)
+		}
+	}
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			abs, _ := filepath.Abs(event.Name)
+			if _, watched := files[abs]; !watched {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				log.Printf("Error reloading certificates, keeping the previous one: %v", err)
+				continue
+			}
+			log.Printf("Certificates reloaded from %s", event.Name)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Certificate watcher error: %v", err)
+		}
+	}
+}
+
+// ParseClientAuth maps TLS_CLIENT_AUTH to the crypto/tls policy: empty or
+// "none" asks no certificate, "request" verifies a certificate when the
+// client sends one, "require" rejects connections without a valid
+// certificate. Both need a CA bundle, client certificates are never
+// verified against the system roots. Any other value is an error.
+func ParseClientAuth(mode string, caFile string) (tls.ClientAuthType, error) {
+	var clientAuth tls.ClientAuthType
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", "none":
+		clientAuth = tls.NoClientCert
+	case "request", "optional":
+		clientAuth = tls.VerifyClientCertIfGiven
+	case "require", "required":
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return tls.NoClientCert, fmt.Errorf("TLS_CLIENT_AUTH %s is not none, request or require", mode)
+	}
+
+	if clientAuth != tls.NoClientCert && caFile == "" {
+		return tls.NoClientCert, fmt.Errorf("TLS_CLIENT_AUTH %s needs TLS_CLIENT_CA_FILE", mode)
+	}
+	return clientAuth, nil
+}
+
+// CertificateIdentities returns the subject common name followed by every
+// SAN of the certificate.
+func CertificateIdentities(cert *x509.Certificate) []string {
+	identities := []string{}
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.EmailAddresses...)
+	identities = append(identities, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	return identities
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+const WebhookSecretPrefix = "whsec_"
+
+func GenerateWebhookSecret() string {
+	return WebhookSecretPrefix + RandomStringV1(32)
+}
+
+// SignWebhook returns the X-Gateway-Signature of a delivery, t=<unix>,v1=<hex>
+// where v1 is the HMAC-SHA256 of "<unix>.<body>" keyed with the secret. The
+// receiver recomputes it and rejects an old timestamp to stop replays.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	ts := strconv.FormatInt(timestamp, 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -2,18 +2,22 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"os"
 	"regexp"
+	"strings"
 	"time"
 
 	"go-gerbang/broker"
 	"go-gerbang/config"
 	"go-gerbang/database"
 	"go-gerbang/handlers"
+	"go-gerbang/middleware"
 	"go-gerbang/proxyroute"
 	"go-gerbang/routes"
+	"go-gerbang/types"
 
 	"github.com/goccy/go-json"
 	"github.com/gofiber/contrib/v3/circuitbreaker"
@@ -25,7 +29,6 @@ import (
 	"github.com/gofiber/fiber/v3/middleware/healthcheck"
 	"github.com/gofiber/fiber/v3/middleware/helmet"
 	"github.com/gofiber/fiber/v3/middleware/idempotency"
-	"github.com/gofiber/fiber/v3/middleware/limiter"
 	"github.com/gofiber/fiber/v3/middleware/recover"
 	"github.com/gofiber/fiber/v3/middleware/requestid"
 	"github.com/gofiber/fiber/v3/middleware/static"
@@ -53,6 +56,9 @@ func main() {
 
 	database.ConnectGormDB()
 	broker.StartingNatsClient()
+	if err := broker.StartingJetStream(); err != nil {
+		log.Printf("Error starting JetStream: %v", err)
+	}
 
 	app := fiber.New(fiber.Config{
 		JSONEncoder:   json.Marshal,
@@ -62,6 +68,10 @@ func main() {
 		AppName:       appName,
 		CaseSensitive: true,
 		ProxyHeader:   "X-Forwarded-For",
+		TrustProxy:    len(config.TrustedProxies) > 0,
+		TrustProxyConfig: fiber.TrustProxyConfig{
+			Proxies: config.TrustedProxies,
+		},
 	})
 
 	app.All("/live", healthcheck.New())
@@ -73,7 +83,8 @@ func main() {
 		AllowOriginsFunc: func(origin string) bool {
 			return allowedOriginRegex.MatchString(origin)
 		},
-		AllowHeaders:     []string{"Authorization", "Content-Type", "X-Sgcsrf-Token"},
+		AllowHeaders:     []string{"Authorization", "Content-Type", "X-Sgcsrf-Token", "X-Grpc-Web", "X-User-Agent", "Grpc-Timeout"},
+		ExposeHeaders:    []string{"X-Config-Version", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After", "X-Cache", "Age", "Grpc-Status", "Grpc-Message"},
 		AllowCredentials: true,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 	}))
@@ -89,24 +100,27 @@ func main() {
 		Key: config.Config("KEY_COOKIE_APIGATEWAY"),
 	}))
 
-	app.Use(etag.New())
-
-	app.Use(requestid.New())
-
-	app.Use(limiter.New(limiter.Config{
+	app.Use(etag.New(etag.Config{
+		// computing the etag would buffer the whole grpc-web or event stream
 		Next: func(c fiber.Ctx) bool {
-			return c.IP() == "127.0.0.1" // limit will apply to this IP
-		},
-		Max:        1000,
-		Expiration: 60 * time.Second,
-		KeyGenerator: func(c fiber.Ctx) string {
-			return c.Get("X-forwarded-for")
-		},
-		LimitReached: func(c fiber.Ctx) error {
-			return c.SendString("be slow bro...")
+			return strings.HasPrefix(c.Get(fiber.HeaderContentType), proxyroute.MIMEGrpcWeb) ||
+				strings.HasPrefix(c.Path(), "/subscribe")
 		},
 	}))
 
+	app.Use(requestid.New())
+
+	if config.GlobalRateLimit > 0 {
+		if config.GlobalRateLimitWindow <= 0 {
+			log.Fatalf("GLOBAL_RATE_LIMIT_WINDOW must be a positive number of seconds")
+		}
+		app.Use(middleware.RateLimit("global", []types.RateLimitRule{{
+			By:     types.RateLimitByIp,
+			Limit:  config.GlobalRateLimit,
+			Window: config.GlobalRateLimitWindow,
+		}}, config.RateLimitExemptIps))
+	}
+
 	app.Use(earlydata.New())
 
 	// app.Get("/", func(c fiber.Ctx) error {
@@ -144,11 +158,43 @@ func main() {
 	// 	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"code": 400, "status": "error", "message": "Not Found Services"})
 	// })
 
-	fmt.Println("✅ server running " + config.Config("PORT_APIGATEWAY"))
-	if err := app.Listen(config.Config("PORT_APIGATEWAY"), fiber.ListenConfig{
+	listenConfig := fiber.ListenConfig{
 		EnablePrefork:         false,
 		DisableStartupMessage: true,
-	}); err != nil {
+	}
+
+	var certReloader *handlers.CertReloader
+	var clientAuth tls.ClientAuthType
+	if config.TLSCertFile != "" {
+		clientAuth, err = handlers.ParseClientAuth(config.TLSClientAuth, config.TLSClientCAFile)
+		if err != nil {
+			log.Fatalf("Error configuring TLS client auth: %v", err)
+		}
+
+		certReloader, err = handlers.NewCertReloader(config.TLSCertFile, config.TLSKeyFile, config.TLSClientCAFile)
+		if err != nil {
+			log.Fatalf("Error loading TLS certificate: %v", err)
+		}
+		go certReloader.Watch()
+
+		listenConfig.TLSConfig = certReloader.ServerTLSConfig(clientAuth)
+	}
+
+	if config.GrpcPort != "" {
+		go func() {
+			var tlsConfig *tls.Config
+			if certReloader != nil {
+				tlsConfig = certReloader.ServerTLSConfig(clientAuth)
+			}
+			fmt.Println("✅ grpc running " + config.GrpcPort)
+			if err := proxyroute.ListenGrpc(config.GrpcPort, tlsConfig); err != nil {
+				log.Fatalf("Error starting grpc server: %v", err)
+			}
+		}()
+	}
+
+	fmt.Println("✅ server running " + config.Config("PORT_APIGATEWAY"))
+	if err := app.Listen(config.Config("PORT_APIGATEWAY"), listenConfig); err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 }
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+
+	"go-gerbang/config"
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/basicauth"
+)
+
+// Gateway admin permissions, they are stored as casbin policies and compared
+// literally (not with keyMatch) so "gateway:config" never matches
+// "gateway:admin".
+const (
+	AdminPermission       = "gateway:admin"
+	AdminPermissionConfig = "gateway:config"
+	AdminPermissionUsers  = "gateway:users"
+	AdminPermissionOps    = "gateway:ops"
+
+	AdminActManage = "manage"
+	AdminActRead   = "read"
+
+	AdminApiKeyScope = "gateway"
+)
+
+// Built-in admin roles, grant one to an auth role with
+// g, role:<id_auth_role>, <admin role>
+const (
+	AdminRoleGateway = "gateway-admin"
+	AdminRoleConfig  = "config-admin"
+	AdminRoleUser    = "user-admin"
+	AdminRoleOps     = "ops-viewer"
+)
+
+var AdminRolePolicies = map[string][]string{
+	AdminRoleGateway: {AdminPermission, AdminActManage},
+	AdminRoleConfig:  {AdminPermissionConfig, AdminActManage},
+	AdminRoleUser:    {AdminPermissionUsers, AdminActManage},
+	AdminRoleOps:     {AdminPermissionOps, AdminActRead},
+}
+
+// SeedAdminPolicies stores the policies of the built-in admin roles, existing
+// policies are left untouched.
+func SeedAdminPolicies() error {
+	authz, err := Enforcer()
+	if err != nil {
+		return err
+	}
+
+	for role, policy := range AdminRolePolicies {
+		if _, err := authz.AddPolicy(role, policy[0], policy[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AdminGuard protects the management endpoints of the gateway. The caller is
+// authenticated with a JWT or an API key scoped for "gateway" when no user is
+// set yet, then one of its roles must hold gateway:admin or permission.
+// GET and HEAD need the read action, other methods need manage.
+func AdminGuard(permission string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		user, ok := c.Locals("user").(*models.UserData)
+		if !ok {
+			var err error
+			if raw := c.Get(HeaderApiKey); raw != "" {
+				user, err = VerifyApiKey(c, raw, AdminApiKeyScope, c.Method())
+				if err != nil {
+					return ApiKeyErrorResponse(c, err)
+				}
+			} else {
+				user, err = Authenticate(c)
+				if err != nil {
+					return handlers.UnauthorizedErrorResponse(c, err)
+				}
+			}
+			c.Locals("user", user)
+		}
+
+		act := AdminActManage
+		if c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+			act = AdminActRead
+		}
+
+		allowed, err := HasAdminPermission(user, permission, act)
+		if err != nil {
+			log.Printf("error: enforcer: %s", err)
+			return handlers.InternalServerErrorResponse(c, fmt.Errorf("failed to check your admin access"))
+		}
+
+		if !allowed {
+			return handlers.ForbiddenErrorResponse(c, fmt.Errorf("you need %s permission", permission))
+		}
+
+		return c.Next()
+	}
+}
+
+func HasAdminPermission(user *models.UserData, permission string, act string) (bool, error) {
+	authz, err := Enforcer()
+	if err != nil {
+		return false, err
+	}
+
+	for _, ua := range user.UserAssignments {
+		perms, err := authz.GetImplicitPermissionsForUser(fmt.Sprintf("role:%d", ua.AuthRoleId))
+		if err != nil {
+			return false, err
+		}
+
+		for _, p := range perms {
+			if len(p) < 3 {
+				continue
+			}
+			if p[1] == AdminPermission {
+				return true, nil
+			}
+			if p[1] == permission && (p[2] == act || p[2] == AdminActManage) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// MigrationBasicAuth protects the bootstrap endpoints that run before any
+// user exists. Credentials stored in basic_auth_credentials win, the
+// MIGRATION_BASIC_AUTH_* env values are only used while that table is empty.
+var MigrationBasicAuth = basicauth.New(basicauth.Config{
+	Realm: "go-gerbang migration",
+	Authorizer: func(username string, password string, c fiber.Ctx) bool {
+		if models.HasBasicAuthCredentialTable() {
+			credentials := []models.BasicAuthCredential{}
+			if err := models.FindBasicAuthCredential(&credentials).Error; err != nil {
+				log.Printf("error: basic auth credential: %s", err)
+				return false
+			}
+
+			if len(credentials) > 0 {
+				for _, credential := range credentials {
+					if credential.Username == username {
+						return handlers.CheckPasswordHash(password, credential.PasswordHash)
+					}
+				}
+				return false
+			}
+		}
+
+		if config.MigrationBasicAuthUser == "" || config.MigrationBasicAuthHash == "" {
+			return false
+		}
+
+		return username == config.MigrationBasicAuthUser && handlers.CheckPasswordHash(password, config.MigrationBasicAuthHash)
+	},
+})
@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-gerbang/database"
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	HeaderApiKey = "X-API-Key"
+	ApiKeyLocals = "apiKey"
+)
+
+type ApiKeyError struct {
+	Status  int
+	Message string
+}
+
+func (e *ApiKeyError) Error() string {
+	return e.Message
+}
+
+// ApiKeyAuth authenticates machine clients with the X-API-Key header and sets
+// the owner of the key on c.Locals("user"), so AuthRBAC can run afterwards.
+// scope is the service name the key must be scoped for.
+func ApiKeyAuth(scope string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		user, err := VerifyApiKey(c, c.Get(HeaderApiKey), scope, c.Method())
+		if err != nil {
+			return ApiKeyErrorResponse(c, err)
+		}
+
+		c.Locals("user", user)
+
+		return c.Next()
+	}
+}
+
+// AuthOrApiKey accepts an X-API-Key header when present and falls back to
+// the JWT flow of Auth otherwise.
+func AuthOrApiKey(scope string) fiber.Handler {
+	apiKeyAuth := ApiKeyAuth(scope)
+	return func(c fiber.Ctx) error {
+		if c.Get(HeaderApiKey) != "" {
+			return apiKeyAuth(c)
+		}
+		return Auth(c)
+	}
+}
+
+// ServiceAuth returns the authentication middleware matching the auth mode of
+// the service.
+func ServiceAuth(service types.Service) fiber.Handler {
+	switch service.AuthMode {
+	case types.AuthModeApiKey:
+		return ApiKeyAuth(service.Service)
+	case types.AuthModeJWTOrApiKey:
+		return AuthOrApiKey(service.Service)
+	default:
+		return Auth
+	}
+}
+
+// VerifyApiKey checks raw against the stored keys, method is the method the
+// scope is checked for.
+func VerifyApiKey(c fiber.Ctx, raw string, scope string, method string) (*models.UserData, error) {
+	if raw == "" {
+		return nil, &ApiKeyError{fiber.StatusUnauthorized, "missing api key"}
+	}
+
+	prefix, err := handlers.ParseApiKeyPrefix(raw)
+	if err != nil {
+		return nil, &ApiKeyError{fiber.StatusUnauthorized, err.Error()}
+	}
+
+	apiKey := new(models.ApiKey)
+	if err := models.FindApiKeyByPrefix(apiKey, prefix); err != nil {
+		return nil, &ApiKeyError{fiber.StatusUnauthorized, "invalid api key"}
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(handlers.HashApiKey(raw))) != 1 {
+		return nil, &ApiKeyError{fiber.StatusUnauthorized, "invalid api key"}
+	}
+
+	if apiKey.IsRevoked() {
+		return nil, &ApiKeyError{fiber.StatusUnauthorized, "api key has been revoked"}
+	}
+
+	if apiKey.IsExpired() {
+		return nil, &ApiKeyError{fiber.StatusUnauthorized, "api key has expired"}
+	}
+
+	if !handlers.IsIpAllowed(c.IP(), apiKey.AllowedIps) {
+		return nil, &ApiKeyError{fiber.StatusForbidden, "api key is not allowed from this IP"}
+	}
+
+	if !HasApiKeyScope(apiKey.Scopes, scope, method) {
+		return nil, &ApiKeyError{fiber.StatusForbidden, "api key is not scoped for this service"}
+	}
+
+	if apiKey.RateLimit > 0 {
+		allowed, err := allowApiKeyRequest(apiKey)
+		if err == nil && !allowed {
+			return nil, &ApiKeyError{fiber.StatusTooManyRequests, "api key rate limit exceeded"}
+		}
+	}
+
+	owner := new(models.User)
+	if err := models.FindUserWithAssignmentsById(owner, apiKey.OwnerId); err != nil {
+		return nil, &ApiKeyError{fiber.StatusUnauthorized, "api key owner is not found"}
+	}
+
+	if owner.StatusAccount != models.UserStatusActive {
+		return nil, &ApiKeyError{fiber.StatusUnauthorized, "api key owner is not active or blocked"}
+	}
+
+	go models.TouchApiKey(apiKey.IdApiKey, c.IP())
+
+	user := handlers.SendSafeUserData(owner, "")
+	c.Locals(ApiKeyLocals, apiKey)
+
+	return &user, nil
+}
+
+// HasApiKeyScope reports whether one of the scopes allows method on service.
+// An empty scope means the route is not bound to a service and any key is
+// accepted.
+func HasApiKeyScope(scopes []string, service string, method string) bool {
+	if service == "" {
+		return true
+	}
+
+	readOnly := method == fiber.MethodGet || method == fiber.MethodHead || method == fiber.MethodOptions
+	for _, scope := range scopes {
+		name, access, _ := strings.Cut(scope, ":")
+		if name != "*" && !strings.EqualFold(name, service) {
+			continue
+		}
+		if access == "" || access == "*" || (access == "read" && readOnly) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func allowApiKeyRequest(apiKey *models.ApiKey) (bool, error) {
+	window := time.Now().Unix() / 60
+	key := fmt.Sprintf("apikey-rate:%s:%d", apiKey.IdApiKey, window)
+
+	count, err := database.RedisDb.Incr(database.RedisCtx, key).Result()
+	if err != nil {
+		return true, err
+	}
+	if count == 1 {
+		database.RedisDb.Expire(database.RedisCtx, key, time.Minute)
+	}
+
+	return count <= int64(apiKey.RateLimit), nil
+}
+
+func ApiKeyErrorResponse(c fiber.Ctx, err error) error {
+	apiKeyErr, ok := err.(*ApiKeyError)
+	if !ok {
+		return handlers.UnauthorizedErrorResponse(c, err)
+	}
+
+	switch apiKeyErr.Status {
+	case fiber.StatusForbidden:
+		return handlers.ForbiddenErrorResponse(c, err)
+	case fiber.StatusTooManyRequests:
+		return handlers.TooManyRequestsErrorResponse(c, err)
+	default:
+		return handlers.UnauthorizedErrorResponse(c, err)
+	}
+}
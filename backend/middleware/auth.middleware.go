@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v3/extractors"
@@ -56,13 +58,15 @@ var SessionStore = session.NewStore(session.Config{
 	AbsoluteTimeout: 24 * time.Hour,
 })
 
-var CsrfActivated = false
+// CsrfActivated skips the CSRF checks when true, it is synchronized across
+// instances through the cluster events.
+var CsrfActivated atomic.Bool
 var CsrfContextKey = "token_csrf"
 var CsrfHeaderName = "X-SGCsrf-Token"
 
 var CsrfProtection = csrf.New(csrf.Config{
 	Next: func(c fiber.Ctx) bool {
-		return CsrfActivated
+		return CsrfActivated.Load()
 	},
 	// TODO: migrate KeyLookup: "header:" + CsrfHeaderName
 	CookieName:        "csrf_header",
@@ -109,7 +113,7 @@ var CsrfStore = session.NewStore(session.Config{
 var CsrfProtectionCookies = csrf.New(csrf.Config{
 	Session: CsrfStore,
 	Next: func(c fiber.Ctx) bool {
-		return CsrfActivated
+		return CsrfActivated.Load()
 	},
 	// TODO: migrate KeyLookup: cookie:__SGCsrf
 	CookieName:     "__SGCsrf",
@@ -158,12 +162,25 @@ func ValidateCaptcha(c fiber.Ctx) error {
 }
 
 func Auth(c fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return handlers.UnauthorizedErrorResponse(c, err)
+	}
+
+	c.Locals("user", user)
+
+	return c.Next()
+}
+
+// Authenticate verifies the access token from the Authorization header or the
+// JWT cookie without touching the handler chain.
+func Authenticate(c fiber.Ctx) (*models.UserData, error) {
 	h := c.Get("Authorization")
 
 	cookie := c.Cookies(CookieJWT)
 
 	if h == "" && cookie == "" {
-		return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("you don't have authorization"))
+		return nil, fmt.Errorf("you don't have authorization")
 	}
 
 	var chunks []string
@@ -174,17 +191,15 @@ func Auth(c fiber.Ctx) error {
 	}
 
 	if len(chunks) < 2 {
-		return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("missing or malformed JWT"))
+		return nil, fmt.Errorf("missing or malformed JWT")
 	}
 
 	user, err := Verify(chunks[1], "access")
 	if err != nil {
-		return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("invalid or expired JWT"))
+		return nil, fmt.Errorf("invalid or expired JWT")
 	}
 
-	c.Locals("user", user)
-
-	return c.Next()
+	return user, nil
 }
 
 func parse(token string) (*jwt.Token, error) {
@@ -233,6 +248,7 @@ func Verify(token string, expectedType string) (*models.UserData, error) {
 	created_at, _ := toInt(claims["created_at"])
 	updated_at, _ := toInt(claims["updated_at"])
 	jti, _ := claims["jti"].(string)
+	user_assignments := toUserAssignments(claims["user_assignments"])
 
 	return &models.UserData{
 		IdAccount:      string(id_account),
@@ -251,10 +267,34 @@ func Verify(token string, expectedType string) (*models.UserData, error) {
 		LoginTime:       int64(login_time),
 		CreatedAt:       int(created_at),
 		UpdatedAt:       int(updated_at),
+		UserAssignments: user_assignments,
 		Jti:             &jti,
 	}, nil
 }
 
+func toUserAssignments(value interface{}) []models.UserAssignment {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	assignments := make([]models.UserAssignment, 0, len(items))
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		accountId, _ := m["account_id"].(string)
+		authRoleId, _ := toInt(m["auth_role_id"])
+		assignments = append(assignments, models.UserAssignment{
+			AccountId:  accountId,
+			AuthRoleId: authRoleId,
+		})
+	}
+
+	return assignments
+}
+
 func toInt8(value interface{}) (int8, error) {
 	if f, ok := value.(float64); ok {
 		return int8(f), nil
@@ -277,9 +317,19 @@ func toInt(value interface{}) (int, error) {
 }
 
 func ValidateSession(c fiber.Ctx) error {
+	if err := CheckSession(c); err != nil {
+		return handlers.UnauthorizedErrorResponse(c, err)
+	}
+
+	return c.Next()
+}
+
+// CheckSession validates the session cookie and, for single login, that the
+// session still owns the active auth key of the user.
+func CheckSession(c fiber.Ctx) error {
 	currSession, err := SessionStore.Get(c)
 	if err != nil {
-		return handlers.UnauthorizedErrorResponse(c, err)
+		return err
 	}
 
 	user := currSession.Get(UserId)
@@ -287,21 +337,21 @@ func ValidateSession(c fiber.Ctx) error {
 	defer currSession.Save()
 
 	if user == nil {
-		return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("your session is null"))
-	} else {
-		user_auth_by_id := fmt.Sprintf("%s-%s", UserActive, user)
+		return fmt.Errorf("your session is null")
+	}
 
-		res, err := database.RedisDb.Get(handlers.Ctx, user_auth_by_id).Result()
-		if err != nil {
-			return c.Next() // if not implement
-		}
+	user_auth_by_id := fmt.Sprintf("%s-%s", UserActive, user)
 
-		if AuthKey != res {
-			return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("you are login other device"))
-		}
+	res, err := database.RedisDb.Get(handlers.Ctx, user_auth_by_id).Result()
+	if err != nil {
+		return nil // if not implement
+	}
 
-		return c.Next()
+	if AuthKey != res {
+		return fmt.Errorf("you are login other device")
 	}
+
+	return nil
 }
 
 func SaveUserSession(c fiber.Ctx, user models.UserData, single_login bool) error {
@@ -352,26 +402,82 @@ type CasbinRule struct {
 	V5    string `gorm:"default:null;size:128;uniqueIndex:unique_index"`
 }
 
-func AuthRBAC(c fiber.Ctx) error {
-	user, ok := c.Locals("user").(*models.UserData)
-	if !ok {
-		return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("user not found"))
+var (
+	enforcer      *casbin.SyncedEnforcer
+	enforcerMutex sync.Mutex
+)
+
+// Enforcer returns the casbin enforcer backed by the casbin_rule table. It is
+// built once and safe for concurrent use, the policies are reloaded every
+// POLICY_RELOAD_INTERVAL and by ReloadPolicy when another instance changes
+// them.
+func Enforcer() (*casbin.SyncedEnforcer, error) {
+	enforcerMutex.Lock()
+	defer enforcerMutex.Unlock()
+
+	if enforcer != nil {
+		return enforcer, nil
+	}
+
+	a, err := gormadapter.NewAdapterByDBWithCustomTable(database.GDB, &CasbinRule{})
+	if err != nil {
+		return nil, err
+	}
+	e, err := casbin.NewSyncedEnforcer(config.BasePath+config.Config("CONFIG_PATH_CASBIN_MODEL"), a)
+	if err != nil {
+		return nil, err
+	}
+	if config.PolicyReloadInterval > 0 {
+		e.StartAutoLoadPolicy(time.Duration(config.PolicyReloadInterval) * time.Second)
+	}
+
+	enforcer = e
+	return enforcer, nil
+}
+
+// ReloadPolicy loads the policies of the database again.
+func ReloadPolicy() error {
+	authz, err := Enforcer()
+	if err != nil {
+		return err
 	}
+	return authz.LoadPolicy()
+}
 
-	a, _ := gormadapter.NewAdapterByDBWithCustomTable(database.GDB, &CasbinRule{})
-	authz, err := casbin.NewEnforcer(config.BasePath+config.Config("CONFIG_PATH_CASBIN_MODEL"), a)
+// EnforceRBAC reports whether one of the roles of the user may call method
+// on path.
+func EnforceRBAC(user *models.UserData, path string, method string) (bool, error) {
+	authz, err := Enforcer()
 	if err != nil {
-		log.Fatalf("error: enforcer: %s", err)
+		return false, err
 	}
 
 	for _, ua := range user.UserAssignments {
 		role := fmt.Sprintf("role:%d", ua.AuthRoleId)
-		fmt.Println(role)
-		allowed, _ := authz.Enforce(role, c.Path(), c.Method())
+		allowed, _ := authz.Enforce(role, path, method)
 		if allowed {
-			return c.Next()
+			return true, nil
 		}
 	}
 
+	return false, nil
+}
+
+func AuthRBAC(c fiber.Ctx) error {
+	user, ok := c.Locals("user").(*models.UserData)
+	if !ok {
+		return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("user not found"))
+	}
+
+	allowed, err := EnforceRBAC(user, c.Path(), c.Method())
+	if err != nil {
+		log.Printf("error: enforcer: %s", err)
+		return handlers.InternalServerErrorResponse(c, fmt.Errorf("failed to check your role access"))
+	}
+
+	if allowed {
+		return c.Next()
+	}
+
 	return handlers.ForbiddenErrorResponse(c, fmt.Errorf("your role don't have access"))
 }
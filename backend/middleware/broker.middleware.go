@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"go-gerbang/broker"
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+	"go-gerbang/types"
+)
+
+// Subject permissions of the pub/sub API, stored as casbin policies
+// p, role:<id_auth_role>, nats:<subject pattern>, publish|subscribe
+// The pattern follows the NATS wildcards, "*" matches one token and ">" the
+// remaining tokens.
+const (
+	SubjectPermissionPrefix = "nats:"
+
+	SubjectActPublish   = "publish"
+	SubjectActSubscribe = "subscribe"
+
+	BrokerApiKeyScope = "events"
+)
+
+// Subjects used by the gateway itself, never reachable through the pub/sub
+// API whatever the policies say. user.notification carries the emails, reset
+// links included. Subjects starting with "$" (system and JetStream API) are
+// reserved as well.
+var ReservedSubjects = []string{"_INBOX.>", "gateway.>", "user.notification"}
+
+// ValidSubject reports whether subject is a NATS subject, wildcards are only
+// accepted when wildcard is true.
+func ValidSubject(subject string, wildcard bool) bool {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return false
+	}
+
+	tokens := strings.Split(subject, ".")
+	for i, token := range tokens {
+		switch {
+		case token == "":
+			return false
+		case token == "*" || token == ">":
+			if !wildcard || (token == ">" && i != len(tokens)-1) {
+				return false
+			}
+		case strings.ContainsAny(token, "*>"):
+			return false
+		}
+	}
+
+	return true
+}
+
+// SubjectCovers reports whether every subject matched by filter is matched
+// by pattern.
+func SubjectCovers(pattern string, filter string) bool {
+	p := strings.Split(pattern, ".")
+	f := strings.Split(filter, ".")
+
+	for i, token := range p {
+		if token == ">" {
+			return len(f) > i
+		}
+		if i >= len(f) || f[i] == ">" {
+			return false
+		}
+		if token != "*" && (token != f[i] || f[i] == "*") {
+			return false
+		}
+	}
+
+	return len(p) == len(f)
+}
+
+// SubjectsCollide reports whether a subject can be matched by both a and b.
+func SubjectsCollide(a string, b string) bool {
+	x := strings.Split(a, ".")
+	y := strings.Split(b, ".")
+
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if x[i] == ">" || y[i] == ">" {
+			return true
+		}
+		if x[i] != "*" && y[i] != "*" && x[i] != y[i] {
+			return false
+		}
+	}
+
+	return len(x) == len(y)
+}
+
+// IsReservedSubject reports whether subject, or a subject matched by it,
+// belongs to the gateway. The subjects of nats services are reserved too,
+// their workers trust the identity headers the gateway sets.
+func IsReservedSubject(subject string) bool {
+	if strings.HasPrefix(subject, "$") {
+		return true
+	}
+
+	for _, pattern := range slices.Concat(ReservedSubjects, natsServiceSubjects()) {
+		if SubjectsCollide(pattern, subject) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func natsServiceSubjects() []string {
+	handlers.MapMicroServiceMutex.RLock()
+	defer handlers.MapMicroServiceMutex.RUnlock()
+
+	subjects := []string{}
+	if handlers.MapMicroService == nil {
+		return subjects
+	}
+	for _, service := range handlers.MapMicroService.Services {
+		if service.Type == types.ServiceTypeNats && service.Nats != nil && service.Nats.Subject != "" {
+			subjects = append(subjects, service.Nats.Subject)
+		}
+	}
+	return subjects
+}
+
+// HasSubjectPermission reports whether one of the roles of the user may act
+// on subject, which may be a wildcard filter for subscribe. Holders of
+// gateway:admin may act on every subject that is not reserved.
+func HasSubjectPermission(user *models.UserData, subject string, act string) (bool, error) {
+	if IsReservedSubject(subject) {
+		return false, nil
+	}
+
+	// audit and user lifecycle events are published by the gateway only
+	if act == SubjectActPublish {
+		for _, event := range append([]string{broker.AuditEventSubjects}, types.UserEvents...) {
+			if SubjectsCollide(event, subject) {
+				return false, nil
+			}
+		}
+	}
+
+	authz, err := Enforcer()
+	if err != nil {
+		return false, err
+	}
+
+	for _, ua := range user.UserAssignments {
+		perms, err := authz.GetImplicitPermissionsForUser(fmt.Sprintf("role:%d", ua.AuthRoleId))
+		if err != nil {
+			return false, err
+		}
+
+		for _, p := range perms {
+			if len(p) < 3 {
+				continue
+			}
+			if p[1] == AdminPermission {
+				return true, nil
+			}
+			pattern, ok := strings.CutPrefix(p[1], SubjectPermissionPrefix)
+			if ok && p[2] == act && SubjectCovers(pattern, subject) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
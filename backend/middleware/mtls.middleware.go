@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const ClientCertLocals = "clientCert"
+
+// ClientCertAuth requires a client certificate verified by the listener
+// (see TLS_CLIENT_AUTH and TLS_CLIENT_CA_FILE) and maps its subject or SAN to
+// a user through the client_cert_bindings table.
+func ClientCertAuth(scope string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		state := c.RequestCtx().TLSConnectionState()
+		if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+			return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("a verified client certificate is required"))
+		}
+
+		leaf := state.VerifiedChains[0][0]
+		identities := handlers.CertificateIdentities(leaf)
+		if len(identities) == 0 {
+			return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("client certificate has no subject"))
+		}
+
+		binding := new(models.ClientCertBinding)
+		if err := models.FindClientCertBindingBySubjects(binding, identities).Error; err != nil {
+			return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("client certificate is not bound to any account"))
+		}
+
+		if binding.Fingerprint != "" {
+			sum := sha256.Sum256(leaf.Raw)
+			if !strings.EqualFold(strings.ReplaceAll(binding.Fingerprint, ":", ""), hex.EncodeToString(sum[:])) {
+				return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("client certificate fingerprint does not match"))
+			}
+		}
+
+		accountId := binding.AccountId
+		if binding.IdApiKey != "" {
+			apiKey := new(models.ApiKey)
+			if err := models.FindApiKeyById(apiKey, binding.IdApiKey); err != nil {
+				return handlers.UnauthorizedErrorResponse(c, err)
+			}
+			if apiKey.IsRevoked() || apiKey.IsExpired() {
+				return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("api client of this certificate is not active"))
+			}
+			if !HasApiKeyScope(apiKey.Scopes, scope, c.Method()) {
+				return handlers.ForbiddenErrorResponse(c, fmt.Errorf("api client of this certificate is not scoped for this service"))
+			}
+			accountId = apiKey.OwnerId
+			c.Locals(ApiKeyLocals, apiKey)
+		}
+
+		owner := new(models.User)
+		if err := models.FindUserWithAssignmentsById(owner, accountId); err != nil {
+			return handlers.UnauthorizedErrorResponse(c, err)
+		}
+
+		if owner.StatusAccount != models.UserStatusActive {
+			return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("account of this certificate is not active or blocked"))
+		}
+
+		user := handlers.SendSafeUserData(owner, "")
+		c.Locals("user", &user)
+		c.Locals(ClientCertLocals, binding.Subject)
+
+		return c.Next()
+	}
+}
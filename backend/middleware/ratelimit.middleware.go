@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go-gerbang/database"
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRateLimitPolicy    = "RateLimit-Policy"
+)
+
+// slidingWindowScript keeps one sorted set entry per request inside the
+// window. Redis TIME is used so instances with skewed clocks agree.
+// Returns {allowed, remaining, reset ms}.
+var slidingWindowScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], 0, now - window)
+local count = redis.call("ZCARD", KEYS[1])
+if count < limit then
+	redis.call("ZADD", KEYS[1], now, ARGV[3])
+	redis.call("PEXPIRE", KEYS[1], window)
+	local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+	return {1, limit - count - 1, window - (now - tonumber(oldest[2]))}
+end
+
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+return {0, 0, window - (now - tonumber(oldest[2]))}
+`)
+
+// tokenBucketScript refills burst tokens at limit per window.
+// Returns {allowed, remaining, reset ms}, reset is the wait for one token
+// when denied and the time to a full bucket otherwise.
+var tokenBucketScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local rate = limit / window
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate))
+
+if allowed == 1 then
+	return {1, math.floor(tokens), math.ceil((burst - tokens) / rate)}
+end
+return {0, 0, math.ceil((1 - tokens) / rate)}
+`)
+
+type rateLimitResult struct {
+	rule      types.RateLimitRule
+	allowed   bool
+	remaining int64
+	resetMs   int64
+}
+
+// RateLimit enforces rules for scope (a service name or "global"). It must
+// run after the authentication middlewares so "user" and "api_key" rules
+// see the caller, anonymous callers fall back to their IP. Redis failures
+// let the request through.
+func RateLimit(scope string, rules []types.RateLimitRule, exempt []string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if len(exempt) > 0 && handlers.IsIpAllowed(c.IP(), exempt) {
+			return c.Next()
+		}
+
+		var tightest *rateLimitResult
+		for i, rule := range rules {
+			if len(rule.Methods) > 0 && !slices.ContainsFunc(rule.Methods, func(m string) bool {
+				return strings.EqualFold(m, c.Method())
+			}) {
+				continue
+			}
+
+			result, err := checkRateLimit(rateLimitKey(c, scope, i, rule), rule)
+			if err != nil {
+				log.Printf("error: rate limit %s: %s", scope, err)
+				continue
+			}
+
+			if !result.allowed {
+				setRateLimitHeaders(c, result)
+				retryAfter := int64(math.Ceil(float64(result.resetMs) / 1000))
+				c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(retryAfter, 10))
+				return handlers.TooManyRequestsErrorResponse(c, fmt.Errorf("rate limit exceeded, retry after %d seconds", retryAfter))
+			}
+
+			if tightest == nil || result.remaining < tightest.remaining {
+				tightest = result
+			}
+		}
+
+		if tightest != nil {
+			setRateLimitHeaders(c, tightest)
+		}
+
+		return c.Next()
+	}
+}
+
+func rateLimitKey(c fiber.Ctx, scope string, index int, rule types.RateLimitRule) string {
+	identity := "ip:" + c.IP()
+
+	switch rule.By {
+	case types.RateLimitByUser:
+		if user, ok := c.Locals("user").(*models.UserData); ok {
+			identity = "user:" + user.IdAccount
+		}
+	case types.RateLimitByApiKey:
+		if apiKey, ok := c.Locals(ApiKeyLocals).(*models.ApiKey); ok {
+			identity = "key:" + apiKey.IdApiKey.String()
+		}
+	case types.RateLimitByRoute:
+		identity = "route:" + c.Method() + " " + c.Path()
+	}
+
+	return fmt.Sprintf("ratelimit:%s:%d:%s", scope, index, identity)
+}
+
+func checkRateLimit(key string, rule types.RateLimitRule) (*rateLimitResult, error) {
+	windowMs := int64(rule.Window) * 1000
+
+	var values []int64
+	var err error
+	if rule.Algorithm == types.RateLimitTokenBucket {
+		burst := rule.Burst
+		if burst <= 0 {
+			burst = rule.Limit
+		}
+		values, err = tokenBucketScript.Run(database.RedisCtx, database.RedisDb, []string{key}, windowMs, rule.Limit, burst).Int64Slice()
+	} else {
+		values, err = slidingWindowScript.Run(database.RedisCtx, database.RedisDb, []string{key}, windowMs, rule.Limit, handlers.RandomStringV1(16)).Int64Slice()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 3 {
+		return nil, fmt.Errorf("unexpected rate limit reply %v", values)
+	}
+
+	return &rateLimitResult{
+		rule:      rule,
+		allowed:   values[0] == 1,
+		remaining: values[1],
+		resetMs:   values[2],
+	}, nil
+}
+
+func setRateLimitHeaders(c fiber.Ctx, result *rateLimitResult) {
+	reset := int64(math.Ceil(float64(result.resetMs) / 1000))
+	c.Set(HeaderRateLimitLimit, strconv.Itoa(result.rule.Limit))
+	c.Set(HeaderRateLimitRemaining, strconv.FormatInt(result.remaining, 10))
+	c.Set(HeaderRateLimitReset, strconv.FormatInt(reset, 10))
+	c.Set(HeaderRateLimitPolicy, fmt.Sprintf("%d;w=%d", result.rule.Limit, result.rule.Window))
+}
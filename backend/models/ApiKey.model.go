@@ -0,0 +1,109 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go-gerbang/database"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// ApiKey is a credential for machine clients. Only the SHA-256 hash of the
+// key is stored, the prefix is kept in clear text so a key can be looked up
+// without scanning the table.
+//
+// Scopes are matched against the service name of the proxied route:
+// "*" allows every service, "GRC" allows every method on GRC and
+// "GRC:read" allows only GET/HEAD/OPTIONS on GRC.
+type ApiKey struct {
+	IdApiKey   uuid.UUID                   `gorm:"type:uuid;primaryKey" json:"idApiKey"`
+	Name       string                      `gorm:"not null;size:128" json:"name" validate:"required"`
+	Prefix     string                      `gorm:"not null;size:16;uniqueIndex" json:"prefix"`
+	KeyHash    string                      `gorm:"not null;size:128" json:"-"`
+	OwnerId    string                      `gorm:"type:uuid;not null;index" json:"ownerId" validate:"required"`
+	Scopes     datatypes.JSONSlice[string] `gorm:"type:jsonb" json:"scopes"`
+	AllowedIps datatypes.JSONSlice[string] `gorm:"type:jsonb" json:"allowedIps"`
+	RateLimit  int                         `gorm:"default:0" json:"rateLimit"` // requests per minute, 0 is unlimited
+	ExpiresAt  int64                       `gorm:"default:0" json:"expiresAt"`
+	RevokedAt  int64                       `gorm:"default:0" json:"revokedAt"`
+	LastUsedAt int64                       `gorm:"default:0" json:"lastUsedAt"`
+	LastUsedIp string                      `gorm:"default:null;size:64" json:"lastUsedIp"`
+	CreatedBy  string                      `gorm:"default:null;size:128" json:"createdBy"`
+	CreatedAt  int                         `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt  int                         `gorm:"default:0;autoUpdateTime" json:"updatedAt"`
+}
+
+func (k *ApiKey) BeforeCreate(tx *gorm.DB) error {
+	if k.IdApiKey == uuid.Nil {
+		k.IdApiKey = uuid.New()
+	}
+	return nil
+}
+
+func (k *ApiKey) IsRevoked() bool {
+	return k.RevokedAt > 0
+}
+
+func (k *ApiKey) IsExpired() bool {
+	return k.ExpiresAt > 0 && time.Now().Unix() > k.ExpiresAt
+}
+
+func CreateApiKey(apiKey *ApiKey) *gorm.DB {
+	return database.GDB.Create(apiKey)
+}
+
+func UpdateApiKey(idApiKey interface{}, data interface{}) *gorm.DB {
+	return database.GDB.Model(&ApiKey{}).Where("id_api_key = ?", idApiKey).Updates(data)
+}
+
+func RotateApiKey(idApiKey interface{}, prefix string, keyHash string) *gorm.DB {
+	return database.GDB.Model(&ApiKey{}).Where("id_api_key = ? AND revoked_at = 0", idApiKey).Updates(map[string]interface{}{
+		"prefix":   prefix,
+		"key_hash": keyHash,
+	})
+}
+
+func RevokeApiKey(idApiKey interface{}) *gorm.DB {
+	return database.GDB.Model(&ApiKey{}).Where("id_api_key = ? AND revoked_at = 0", idApiKey).Update("revoked_at", time.Now().Unix())
+}
+
+func TouchApiKey(idApiKey interface{}, ip string) *gorm.DB {
+	return database.GDB.Model(&ApiKey{}).Where("id_api_key = ?", idApiKey).UpdateColumns(map[string]interface{}{
+		"last_used_at": time.Now().Unix(),
+		"last_used_ip": ip,
+	})
+}
+
+func FindApiKey(dest interface{}, conds ...interface{}) *gorm.DB {
+	return database.GDB.Model(&ApiKey{}).Order("created_at DESC").Find(dest, conds...)
+}
+
+func FindApiKeyById(dest interface{}, idApiKey interface{}) error {
+	err := database.GDB.Where("id_api_key = ?", idApiKey).First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("api key is not found")
+	}
+
+	return err
+}
+
+func FindApiKeyByPrefix(dest interface{}, prefix string) error {
+	err := database.GDB.Where("prefix = ?", prefix).First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("api key is not found")
+	}
+
+	return err
+}
+
+func CountFindApiKey(count *int64, conds ...interface{}) error {
+	tx := database.GDB.Model(&ApiKey{})
+	if len(conds) > 0 {
+		tx = tx.Where(conds[0], conds[1:]...)
+	}
+	return tx.Count(count).Error
+}
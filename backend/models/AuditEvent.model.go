@@ -0,0 +1,125 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"go-gerbang/database"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// AuditEvent records one management action. The table is append-only, the
+// model refuses updates and deletes and the migration adds rules so the
+// database ignores them as well.
+type AuditEvent struct {
+	ID         uint64         `gorm:"primaryKey;autoIncrement;type:bigint" json:"id"`
+	ActorId    string         `gorm:"default:null;size:64;index" json:"actor_id"`
+	ActorName  string         `gorm:"default:null;size:128" json:"actor_name"`
+	Action     string         `gorm:"not null;size:64;index" json:"action"`
+	TargetType string         `gorm:"not null;size:64;index" json:"target_type"`
+	Target     string         `gorm:"default:null;size:255;index" json:"target"`
+	Before     datatypes.JSON `gorm:"type:jsonb" json:"before"`
+	After      datatypes.JSON `gorm:"type:jsonb" json:"after"`
+	Diff       datatypes.JSON `gorm:"type:jsonb" json:"diff"`
+	Ip         string         `gorm:"default:null;size:64" json:"ip"`
+	RequestId  string         `gorm:"default:null;size:64;index" json:"request_id"`
+	UserAgent  string         `gorm:"default:null;size:512" json:"user_agent"`
+	Timestamp  time.Time      `gorm:"type:timestamptz;index" json:"timestamp"`
+}
+
+type AuditEventFilter struct {
+	ActorId    string
+	Action     string
+	TargetType string
+	Target     string
+	RequestId  string
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+}
+
+var ErrAuditEventAppendOnly = errors.New("audit events are append-only")
+
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditEventAppendOnly
+}
+
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditEventAppendOnly
+}
+
+func CreateAuditEvent(event *AuditEvent) *gorm.DB {
+	return database.GDB.Create(event)
+}
+
+func auditEventQuery(filter AuditEventFilter) *gorm.DB {
+	tx := database.GDB.Model(&AuditEvent{})
+	if filter.ActorId != "" {
+		tx = tx.Where("actor_id = ?", filter.ActorId)
+	}
+	if filter.Action != "" {
+		tx = tx.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		tx = tx.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.Target != "" {
+		tx = tx.Where("target = ?", filter.Target)
+	}
+	if filter.RequestId != "" {
+		tx = tx.Where("request_id = ?", filter.RequestId)
+	}
+	if !filter.From.IsZero() {
+		tx = tx.Where("timestamp >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		tx = tx.Where("timestamp <= ?", filter.To)
+	}
+	return tx
+}
+
+func FindAuditEvent(dest *[]AuditEvent, filter AuditEventFilter) *gorm.DB {
+	tx := auditEventQuery(filter).Order("timestamp DESC, id DESC")
+	if filter.Limit > 0 {
+		tx = tx.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		tx = tx.Offset(filter.Offset)
+	}
+	return tx.Find(dest)
+}
+
+func CountFindAuditEvent(count *int64, filter AuditEventFilter) error {
+	return auditEventQuery(filter).Count(count).Error
+}
+
+// EachAuditEvent walks the matching events in batches, oldest first, so an
+// export does not hold the whole table in memory.
+func EachAuditEvent(filter AuditEventFilter, fn func(event *AuditEvent) error) error {
+	batch := []AuditEvent{}
+	tx := auditEventQuery(filter).Order("id ASC")
+	if filter.Limit > 0 {
+		tx = tx.Limit(filter.Limit)
+	}
+
+	return tx.FindInBatches(&batch, 500, func(_ *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// ProtectAuditEvents makes Postgres discard UPDATE and DELETE on
+// audit_events, so rows can not be rewritten outside of the gateway either.
+func ProtectAuditEvents() error {
+	return database.GDB.Exec(`
+		CREATE OR REPLACE RULE audit_events_no_update AS ON UPDATE TO audit_events DO INSTEAD NOTHING;
+		CREATE OR REPLACE RULE audit_events_no_delete AS ON DELETE TO audit_events DO INSTEAD NOTHING;
+	`).Error
+}
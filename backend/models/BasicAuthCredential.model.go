@@ -0,0 +1,37 @@
+package models
+
+import (
+	"go-gerbang/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BasicAuthCredential holds the bcrypt hashed credentials of the basicauth
+// protected bootstrap endpoints (/migration, /migration-admin).
+type BasicAuthCredential struct {
+	Username     string `gorm:"primaryKey;size:128" json:"username" validate:"required"`
+	Password     string `gorm:"-" json:"password" validate:"required"`
+	PasswordHash string `gorm:"not null;size:256" json:"-"`
+	CreatedAt    int    `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt    int    `gorm:"default:0;autoUpdateTime" json:"updatedAt"`
+}
+
+func UpsertBasicAuthCredential(credential *BasicAuthCredential) *gorm.DB {
+	return database.GDB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "username"}},
+		DoUpdates: clause.AssignmentColumns([]string{"password_hash", "updated_at"}),
+	}).Create(credential)
+}
+
+func DeleteBasicAuthCredential(username string) *gorm.DB {
+	return database.GDB.Unscoped().Delete(&BasicAuthCredential{}, "username = ?", username)
+}
+
+func FindBasicAuthCredential(dest interface{}, conds ...interface{}) *gorm.DB {
+	return database.GDB.Model(&BasicAuthCredential{}).Order("username").Find(dest, conds...)
+}
+
+func HasBasicAuthCredentialTable() bool {
+	return database.GDB.Migrator().HasTable(&BasicAuthCredential{})
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"go-gerbang/database"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ClientCertBinding maps a client certificate subject (CN or SAN) to a user
+// account or to an API key. When Fingerprint is set the SHA-256 fingerprint
+// of the leaf certificate must match as well.
+type ClientCertBinding struct {
+	IdClientCertBinding uuid.UUID `gorm:"type:uuid;primaryKey" json:"idClientCertBinding"`
+	Subject             string    `gorm:"not null;size:255;uniqueIndex" json:"subject" validate:"required"`
+	AccountId           string    `gorm:"type:uuid;default:null" json:"accountId"`
+	IdApiKey            string    `gorm:"type:uuid;default:null" json:"idApiKey"`
+	Fingerprint         string    `gorm:"default:null;size:128" json:"fingerprint"`
+	Description         string    `gorm:"default:null;size:512" json:"description"`
+	CreatedAt           int       `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt           int       `gorm:"default:0;autoUpdateTime" json:"updatedAt"`
+}
+
+func (b *ClientCertBinding) BeforeCreate(tx *gorm.DB) error {
+	if b.IdClientCertBinding == uuid.Nil {
+		b.IdClientCertBinding = uuid.New()
+	}
+	return nil
+}
+
+func CreateClientCertBinding(binding *ClientCertBinding) *gorm.DB {
+	return database.GDB.Create(binding)
+}
+
+func UpdateClientCertBinding(idBinding interface{}, data interface{}) *gorm.DB {
+	return database.GDB.Model(&ClientCertBinding{}).Where("id_client_cert_binding = ?", idBinding).Updates(data)
+}
+
+func DeleteClientCertBinding(idBinding interface{}) *gorm.DB {
+	return database.GDB.Unscoped().Delete(&ClientCertBinding{}, "id_client_cert_binding = ?", idBinding)
+}
+
+func FindClientCertBinding(dest interface{}, conds ...interface{}) *gorm.DB {
+	return database.GDB.Model(&ClientCertBinding{}).Order("subject").Find(dest, conds...)
+}
+
+func FindClientCertBindingById(dest interface{}, idBinding interface{}) error {
+	err := database.GDB.Where("id_client_cert_binding = ?", idBinding).First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("client certificate binding is not found")
+	}
+
+	return err
+}
+
+func FindClientCertBindingBySubjects(dest interface{}, subjects []string) *gorm.DB {
+	return database.GDB.Where("subject IN ?", subjects).First(dest)
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"go-gerbang/database"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// ConfigVersion is an immutable snapshot of the service configuration. Every
+// accepted change, rollback or external edit of the file adds a new version,
+// existing rows are never updated.
+type ConfigVersion struct {
+	Version     int            `gorm:"primaryKey;autoIncrement" json:"version"`
+	Checksum    string         `gorm:"not null;size:64;index" json:"checksum"`
+	Config      datatypes.JSON `gorm:"type:jsonb;not null" json:"config,omitempty"`
+	Source      string         `gorm:"not null;size:16" json:"source"` // "api", "rollback" or "file"
+	RollbackOf  int            `gorm:"default:0" json:"rollbackOf"`
+	AuthorId    string         `gorm:"default:null;size:64" json:"authorId"`
+	Author      string         `gorm:"default:null;size:128" json:"author"`
+	Comment     string         `gorm:"default:null;size:512" json:"comment"`
+	ServiceSize int            `gorm:"default:0" json:"serviceSize"`
+	CreatedAt   int            `gorm:"autoCreateTime" json:"createdAt"`
+}
+
+const (
+	ConfigVersionSourceApi      = "api"
+	ConfigVersionSourceRollback = "rollback"
+	ConfigVersionSourceFile     = "file"
+)
+
+func (v *ConfigVersion) BeforeUpdate(tx *gorm.DB) error {
+	return fmt.Errorf("config versions are immutable")
+}
+
+func CreateConfigVersion(version *ConfigVersion) *gorm.DB {
+	return database.GDB.Create(version)
+}
+
+// CreateConfigVersionWith stores version and runs apply before the commit,
+// the version is rolled back when apply fails.
+func CreateConfigVersionWith(version *ConfigVersion, apply func() error) error {
+	return database.GDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(version).Error; err != nil {
+			return err
+		}
+		return apply()
+	})
+}
+
+// FindConfigVersion lists versions, newest first, without their config body.
+func FindConfigVersion(dest interface{}, limit int, offset int) *gorm.DB {
+	return database.GDB.Model(&ConfigVersion{}).
+		Omit("config").
+		Order("version DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(dest)
+}
+
+func FindConfigVersionByVersion(dest interface{}, version interface{}) error {
+	err := database.GDB.Where("version = ?", version).First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("config version %v is not found", version)
+	}
+
+	return err
+}
+
+func FindLatestConfigVersionByChecksum(dest interface{}, checksum string) error {
+	return database.GDB.Where("checksum = ?", checksum).Order("version DESC").First(dest).Error
+}
+
+func CountFindConfigVersion(count *int64) error {
+	return database.GDB.Model(&ConfigVersion{}).Count(count).Error
+}
+
+func HasConfigVersionTable() bool {
+	return database.GDB.Migrator().HasTable(&ConfigVersion{})
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"go-gerbang/database"
+
+	"gorm.io/gorm"
+)
+
+// EmailTemplate is one immutable version of a named email of a sender, an
+// empty sender is the default of every sender. The latest version of a
+// sender, name and locale is the one sent, a rollback adds a copy of an
+// older version.
+type EmailTemplate struct {
+	IdEmailTemplate uint64 `gorm:"primaryKey;autoIncrement;type:bigint" json:"idEmailTemplate"`
+	Sender          string `gorm:"not null;size:128;uniqueIndex:idx_email_template_version" json:"sender"`
+	Name            string `gorm:"not null;size:64;uniqueIndex:idx_email_template_version" json:"name"`
+	Locale          string `gorm:"not null;size:8;uniqueIndex:idx_email_template_version" json:"locale"`
+	Version         int    `gorm:"not null;uniqueIndex:idx_email_template_version" json:"version"`
+	Subject         string `gorm:"not null;size:255" json:"subject"`
+	Title           string `gorm:"default:null;size:255" json:"title"`
+	BodyHtml        string `gorm:"type:text;not null" json:"bodyHtml"`
+	BodyText        string `gorm:"type:text;not null" json:"bodyText"`
+	Footer          string `gorm:"default:null;size:512" json:"footer"`
+	RollbackOf      int    `gorm:"default:0" json:"rollbackOf"`
+	Author          string `gorm:"default:null;size:128" json:"author"`
+	CreatedAt       int    `gorm:"autoCreateTime" json:"createdAt"`
+}
+
+func (t *EmailTemplate) BeforeUpdate(tx *gorm.DB) error {
+	return fmt.Errorf("email template versions are immutable")
+}
+
+// CreateEmailTemplateVersion stores template as the next version of its
+// sender, name and locale.
+func CreateEmailTemplateVersion(template *EmailTemplate) error {
+	return database.GDB.Transaction(func(tx *gorm.DB) error {
+		var latest int
+		if err := tx.Model(&EmailTemplate{}).
+			Where("sender = ? AND name = ? AND locale = ?", template.Sender, template.Name, template.Locale).
+			Select("COALESCE(MAX(version), 0)").
+			Scan(&latest).Error; err != nil {
+			return err
+		}
+
+		template.IdEmailTemplate = 0
+		template.Version = latest + 1
+		return tx.Create(template).Error
+	})
+}
+
+// FindLatestEmailTemplate lists the version in use of every template, an
+// empty filter matches every value.
+func FindLatestEmailTemplate(dest *[]EmailTemplate, sender *string, name string, locale string) *gorm.DB {
+	tx := database.GDB.Where("version = (SELECT MAX(t.version) FROM email_templates t WHERE t.sender = email_templates.sender AND t.name = email_templates.name AND t.locale = email_templates.locale)")
+	if sender != nil {
+		tx = tx.Where("sender = ?", *sender)
+	}
+	if name != "" {
+		tx = tx.Where("name = ?", name)
+	}
+	if locale != "" {
+		tx = tx.Where("locale = ?", locale)
+	}
+	return tx.Order("sender ASC, name ASC, locale ASC").Find(dest)
+}
+
+// FindEmailTemplateInUse returns the latest version of a template, or a
+// gorm.ErrRecordNotFound.
+func FindEmailTemplateInUse(dest *EmailTemplate, sender string, name string, locale string) error {
+	return database.GDB.Where("sender = ? AND name = ? AND locale = ?", sender, name, locale).Order("version DESC").First(dest).Error
+}
+
+// FindEmailTemplateVersion lists the versions of a template, newest first.
+func FindEmailTemplateVersion(dest *[]EmailTemplate, sender string, name string, locale string) *gorm.DB {
+	return database.GDB.Where("sender = ? AND name = ? AND locale = ?", sender, name, locale).Order("version DESC").Find(dest)
+}
+
+func FindEmailTemplateById(dest *EmailTemplate, idEmailTemplate interface{}) error {
+	err := database.GDB.Where("id_email_template = ?", idEmailTemplate).First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("email template is not found")
+	}
+
+	return err
+}
+
+// DeleteEmailTemplate removes every version of a template, the built-in one
+// is sent again.
+func DeleteEmailTemplate(sender string, name string, locale string) *gorm.DB {
+	return database.GDB.Where("sender = ? AND name = ? AND locale = ?", sender, name, locale).Delete(&EmailTemplate{})
+}
@@ -9,21 +9,23 @@ import (
 )
 
 type Logger struct {
-	ID        uint64         `gorm:"primaryKey;autoIncrement;type:bigint" json:"id"`
-	Level     string         `gorm:"size:16;index" json:"level"`
-	Service   string         `gorm:"size:32" json:"service"`
-	Method    string         `gorm:"size:16" json:"method"`
-	Path      string         `gorm:"not null;size:512" json:"path"`
-	UserAuth  string         `gorm:"default:null;size:255" json:"user_auth"`
-	Status    uint16         `gorm:"not null" json:"status"`
-	Duration  float64        `gorm:"not null" json:"duration"`
-	Fields    datatypes.JSON `gorm:"type:jsonb" json:"fields"`
-	Timestamp time.Time      `gorm:"type:timestamptz" json:"timestamp"`
+	ID         uint64         `gorm:"primaryKey;autoIncrement;type:bigint" json:"id"`
+	Level      string         `gorm:"size:16;index" json:"level"`
+	Service    string         `gorm:"size:32" json:"service"`
+	Method     string         `gorm:"size:16" json:"method"`
+	Path       string         `gorm:"not null;size:512" json:"path"`
+	UserAuth   string         `gorm:"default:null;size:255" json:"user_auth"`
+	Status     uint16         `gorm:"not null" json:"status"`
+	Duration   float64        `gorm:"not null" json:"duration"`
+	Cache      string         `gorm:"default:null;size:8;index" json:"cache"`
+	GrpcStatus *int16         `gorm:"default:null" json:"grpc_status"`
+	Fields     datatypes.JSON `gorm:"type:jsonb" json:"fields"`
+	Timestamp  time.Time      `gorm:"type:timestamptz" json:"timestamp"`
 }
 
 func FindLogger(dest *[]Logger, service, method, path, status string, from, to time.Time) *gorm.DB {
 	query := `
-			SELECT id, level, service, method, path, user_auth, status, duration, fields, timestamp FROM loggers 
+			SELECT id, level, service, method, path, user_auth, status, duration, cache, grpc_status, fields, timestamp FROM loggers 
 			WHERE service = ?
 			AND method = ?
 			AND path = ?
@@ -41,6 +43,8 @@ type PathStats struct {
 	Status       uint16  `json:"status"`
 	AvgDuration  float64 `json:"avg_duration"`
 	RequestCount int64   `json:"request_count"`
+	CacheHits    int64   `json:"cache_hits"`
+	CacheMisses  int64   `json:"cache_misses"`
 }
 
 func FindStatsLogger(dest *[]PathStats, from, to time.Time) *gorm.DB {
@@ -50,7 +54,9 @@ func FindStatsLogger(dest *[]PathStats, from, to time.Time) *gorm.DB {
                 path,
                 status,
                 AVG(duration)   AS avg_duration,
-                COUNT(*)        AS request_count
+                COUNT(*)        AS request_count,
+                COUNT(*) FILTER (WHERE cache IN ('hit', 'stale')) AS cache_hits,
+                COUNT(*) FILTER (WHERE cache = 'miss')            AS cache_misses
 			FROM loggers
 			WHERE timestamp BETWEEN ? AND ?
 			GROUP BY service, method, path, status
@@ -58,3 +64,7 @@ func FindStatsLogger(dest *[]PathStats, from, to time.Time) *gorm.DB {
 	`
 	return database.GDB.Raw(query, from, to).Scan(dest)
 }
+
+func DeleteLoggerBefore(before time.Time) *gorm.DB {
+	return database.GDB.Where("timestamp < ?", before).Delete(&Logger{})
+}
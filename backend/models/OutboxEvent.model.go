@@ -0,0 +1,205 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"go-gerbang/database"
+	"go-gerbang/types"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// OutboxEvent is an event written in the transaction of the change it
+// describes, the relay publishes it to NATS afterwards. Notification is the
+// email queued along with the event, it is cleared once queued since it may
+// hold a set password link.
+type OutboxEvent struct {
+	ID            uint64         `gorm:"primaryKey;autoIncrement;type:bigint" json:"id"`
+	Event         string         `gorm:"not null;size:64;index" json:"event"`
+	AggregateId   string         `gorm:"not null;size:64;index" json:"aggregate_id"`
+	Payload       datatypes.JSON `gorm:"type:jsonb;not null" json:"payload"`
+	Notification  datatypes.JSON `gorm:"type:jsonb" json:"-"`
+	Attempts      int            `gorm:"default:0" json:"attempts"`
+	LastError     string         `gorm:"default:null;size:512" json:"last_error"`
+	NextAttemptAt time.Time      `gorm:"type:timestamptz;index" json:"next_attempt_at"`
+	PublishedAt   *time.Time     `gorm:"type:timestamptz;index" json:"published_at"`
+	CreatedAt     time.Time      `gorm:"type:timestamptz" json:"created_at"`
+}
+
+// NewUserEvent describes event on user, notification is optional.
+func NewUserEvent(event string, user *User, notification interface{}) (*OutboxEvent, error) {
+	return newOutboxEvent(types.UserEvent{
+		Event:         event,
+		IdAccount:     user.IdAccount.String(),
+		Username:      user.Username,
+		FullName:      user.FullName,
+		Email:         user.Email,
+		StatusAccount: user.StatusAccount,
+		OccurredAt:    time.Now(),
+	}, notification)
+}
+
+func NewRoleAssignedEvent(assignment UserAssignment) (*OutboxEvent, error) {
+	return newOutboxEvent(types.UserEvent{
+		Event:      types.EventRoleAssigned,
+		IdAccount:  assignment.AccountId,
+		AuthRoleId: assignment.AuthRoleId,
+		OccurredAt: time.Now(),
+	}, nil)
+}
+
+func newOutboxEvent(payload types.UserEvent, notification interface{}) (*OutboxEvent, error) {
+	event := &OutboxEvent{
+		Event:         payload.Event,
+		AggregateId:   payload.IdAccount,
+		NextAttemptAt: payload.OccurredAt,
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	event.Payload = raw
+
+	if notification != nil {
+		raw, err := json.Marshal(notification)
+		if err != nil {
+			return nil, err
+		}
+		event.Notification = raw
+	}
+
+	return event, nil
+}
+
+// CreateUserWithEvent creates user and its user.created event.
+func CreateUserWithEvent(user *User, notification interface{}) error {
+	return database.GDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return createUserEvent(tx, types.EventUserCreated, user, notification)
+	})
+}
+
+// UpdateUserWithEvent updates the user with user.updated, and user.blocked
+// when the update deactivates an account that was not.
+func UpdateUserWithEvent(accountId interface{}, data interface{}) error {
+	return database.GDB.Transaction(func(tx *gorm.DB) error {
+		before := new(User)
+		if err := tx.Where("id_account = ?", accountId).First(before).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&User{}).Where("id_account = ?", accountId).Updates(data).Error; err != nil {
+			return err
+		}
+
+		after := new(User)
+		if err := tx.Where("id_account = ?", accountId).First(after).Error; err != nil {
+			return err
+		}
+		if err := createUserEvent(tx, types.EventUserUpdated, after, nil); err != nil {
+			return err
+		}
+		if before.StatusAccount != UserStatusDeleted && after.StatusAccount == UserStatusDeleted {
+			return createUserEvent(tx, types.EventUserBlocked, after, nil)
+		}
+		return nil
+	})
+}
+
+// BlockUserWithEvent blocks user with user.blocked.
+func BlockUserWithEvent(user *User) error {
+	return database.GDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Select("status_account").Where("id_account = ?", user.IdAccount).Update("status_account", UserStatusDeleted).Error; err != nil {
+			return err
+		}
+		user.StatusAccount = UserStatusDeleted
+		return createUserEvent(tx, types.EventUserBlocked, user, nil)
+	})
+}
+
+// UpdateUserPasswordWithEvent stores the password of user with
+// password.changed.
+func UpdateUserPasswordWithEvent(user *User) error {
+	return database.GDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Select("password_hash", "password_reset_token").Where("id_account = ?", user.IdAccount).Updates(user).Error; err != nil {
+			return err
+		}
+		return createUserEvent(tx, types.EventPasswordChanged, user, nil)
+	})
+}
+
+// SaveUserAssignmentsWithEvent creates or saves the assignments with one
+// role.assigned each.
+func SaveUserAssignmentsWithEvent(assignments []UserAssignment, create bool) error {
+	return database.GDB.Transaction(func(tx *gorm.DB) error {
+		for i := range assignments {
+			save := tx.Save
+			if create {
+				save = tx.Create
+			}
+			if err := save(&assignments[i]).Error; err != nil {
+				return err
+			}
+
+			event, err := NewRoleAssignedEvent(assignments[i])
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(event).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func createUserEvent(tx *gorm.DB, name string, user *User, notification interface{}) error {
+	event, err := NewUserEvent(name, user, notification)
+	if err != nil {
+		return err
+	}
+	return tx.Create(event).Error
+}
+
+// FindPendingOutboxEvent lists the events due for publishing, oldest first.
+func FindPendingOutboxEvent(dest *[]OutboxEvent, limit int) *gorm.DB {
+	return database.GDB.Where("published_at IS NULL AND next_attempt_at <= ?", time.Now()).Order("id ASC").Limit(limit).Find(dest)
+}
+
+func MarkOutboxEventPublished(id uint64) *gorm.DB {
+	return database.GDB.Model(&OutboxEvent{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"published_at": time.Now(),
+		"notification": nil,
+		"last_error":   nil,
+	})
+}
+
+// MarkOutboxEventQueued clears the notification of an event once queued, so
+// a later retry of the event does not send the email twice.
+func MarkOutboxEventQueued(id uint64) *gorm.DB {
+	return database.GDB.Model(&OutboxEvent{}).Where("id = ?", id).Update("notification", nil)
+}
+
+func MarkOutboxEventFailed(id uint64, attempts int, cause error, next time.Time) *gorm.DB {
+	message := cause.Error()
+	if len(message) > 512 {
+		message = message[:512]
+	}
+	return database.GDB.Model(&OutboxEvent{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":        attempts,
+		"last_error":      message,
+		"next_attempt_at": next,
+	})
+}
+
+func CountPendingOutboxEvent(count *int64) error {
+	return database.GDB.Model(&OutboxEvent{}).Where("published_at IS NULL").Count(count).Error
+}
+
+func DeletePublishedOutboxEventBefore(before time.Time) *gorm.DB {
+	return database.GDB.Where("published_at IS NOT NULL AND published_at < ?", before).Delete(&OutboxEvent{})
+}
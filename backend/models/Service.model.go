@@ -0,0 +1,162 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"go-gerbang/database"
+	"go-gerbang/types"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Service mirrors types.Service so the proxied services can be stored in
+// Postgres and shared by every gateway instance, see SERVICE_CONFIG_SOURCE.
+type Service struct {
+	IdService         uuid.UUID                                 `gorm:"type:uuid;primaryKey" json:"id_service"`
+	Service           string                                    `gorm:"default:null;size:64" json:"service"`
+	Path              string                                    `gorm:"not null;size:255;uniqueIndex" json:"path" validate:"required"`
+	Url               string                                    `gorm:"default:null;size:512" json:"url"`
+	Type              string                                    `gorm:"default:null;size:8" json:"type"`
+	AuthProtection    bool                                      `gorm:"default:false" json:"auth_protection"`
+	AuthMode          string                                    `gorm:"default:null;size:16" json:"auth_mode"`
+	SessionProtection bool                                      `gorm:"default:false" json:"session_protection"`
+	CsrfProtection    bool                                      `gorm:"default:false" json:"csrf_protection"`
+	RbacProtection    bool                                      `gorm:"default:false" json:"rbac_protection"`
+	MtlsProtection    bool                                      `gorm:"default:false" json:"mtls_protection"`
+	Coalesce          bool                                      `gorm:"default:false" json:"coalesce"`
+	UpstreamTLS       datatypes.JSONType[*types.UpstreamTLS]    `gorm:"type:jsonb" json:"upstream_tls"`
+	RateLimits        datatypes.JSONSlice[types.RateLimitRule]  `gorm:"type:jsonb" json:"rate_limits"`
+	Concurrency       datatypes.JSONType[*types.Concurrency]    `gorm:"type:jsonb" json:"concurrency"`
+	Cache             datatypes.JSONType[*types.Cache]          `gorm:"type:jsonb" json:"cache"`
+	Headers           datatypes.JSONType[*types.HeaderPolicy]   `gorm:"type:jsonb" json:"headers"`
+	BodyTransform     datatypes.JSONType[*types.BodyTransforms] `gorm:"type:jsonb" json:"body_transform"`
+	OpenAPI           datatypes.JSONType[*types.OpenAPI]        `gorm:"type:jsonb" json:"openapi"`
+	Nats              datatypes.JSONType[*types.NatsTarget]     `gorm:"type:jsonb" json:"nats"`
+	CreatedBy         string                                    `gorm:"default:null;size:128" json:"created_by"`
+	CreatedAt         int                                       `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt         int                                       `gorm:"default:0;autoUpdateTime" json:"updated_at"`
+}
+
+func (s *Service) BeforeCreate(tx *gorm.DB) error {
+	if s.IdService == uuid.Nil {
+		s.IdService = uuid.New()
+	}
+	return nil
+}
+
+func (s *Service) ToType() types.Service {
+	return types.Service{
+		Service:           s.Service,
+		Path:              s.Path,
+		Url:               s.Url,
+		Type:              s.Type,
+		AuthProtection:    s.AuthProtection,
+		AuthMode:          s.AuthMode,
+		SessionProtection: s.SessionProtection,
+		CsrfProtection:    s.CsrfProtection,
+		RbacProtection:    s.RbacProtection,
+		MtlsProtection:    s.MtlsProtection,
+		Coalesce:          s.Coalesce,
+		UpstreamTLS:       s.UpstreamTLS.Data(),
+		RateLimits:        s.RateLimits,
+		Concurrency:       s.Concurrency.Data(),
+		Cache:             s.Cache.Data(),
+		Headers:           s.Headers.Data(),
+		BodyTransform:     s.BodyTransform.Data(),
+		OpenAPI:           s.OpenAPI.Data(),
+		Nats:              s.Nats.Data(),
+	}
+}
+
+func ServiceFromType(service types.Service) Service {
+	return Service{
+		Service:           service.Service,
+		Path:              service.Path,
+		Url:               service.Url,
+		Type:              service.Type,
+		AuthProtection:    service.AuthProtection,
+		AuthMode:          service.AuthMode,
+		SessionProtection: service.SessionProtection,
+		CsrfProtection:    service.CsrfProtection,
+		RbacProtection:    service.RbacProtection,
+		MtlsProtection:    service.MtlsProtection,
+		Coalesce:          service.Coalesce,
+		UpstreamTLS:       datatypes.NewJSONType(service.UpstreamTLS),
+		RateLimits:        service.RateLimits,
+		Concurrency:       datatypes.NewJSONType(service.Concurrency),
+		Cache:             datatypes.NewJSONType(service.Cache),
+		Headers:           datatypes.NewJSONType(service.Headers),
+		BodyTransform:     datatypes.NewJSONType(service.BodyTransform),
+		OpenAPI:           datatypes.NewJSONType(service.OpenAPI),
+		Nats:              datatypes.NewJSONType(service.Nats),
+	}
+}
+
+func CreateService(service *Service) *gorm.DB {
+	return database.GDB.Create(service)
+}
+
+// UpdateService writes every column, so flags can be switched off.
+func UpdateService(idService interface{}, service *Service) *gorm.DB {
+	return database.GDB.Model(&Service{}).
+		Where("id_service = ?", idService).
+		Select("*").
+		Omit("id_service", "created_by", "created_at").
+		Updates(service)
+}
+
+func DeleteService(idService interface{}) *gorm.DB {
+	return database.GDB.Delete(&Service{}, "id_service = ?", idService)
+}
+
+func FindService(dest interface{}, conds ...interface{}) *gorm.DB {
+	return database.GDB.Model(&Service{}).Order("path").Find(dest, conds...)
+}
+
+func FindServiceById(dest interface{}, idService interface{}) error {
+	err := database.GDB.Where("id_service = ?", idService).First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("service is not found")
+	}
+
+	return err
+}
+
+func CountFindService(count *int64, conds ...interface{}) error {
+	tx := database.GDB.Model(&Service{})
+	if len(conds) > 0 {
+		tx = tx.Where(conds[0], conds[1:]...)
+	}
+	return tx.Count(count).Error
+}
+
+// ReplaceServices swaps the whole table for services in one transaction.
+func ReplaceServices(services []Service) error {
+	return database.GDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&Service{}).Error; err != nil {
+			return err
+		}
+		if len(services) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(services, 100).Error
+	})
+}
+
+// LoadServiceConfig reads the services table in the shape of config.json.
+func LoadServiceConfig() (*types.ConfigServices, error) {
+	rows := []Service{}
+	if err := FindService(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	cfg := &types.ConfigServices{Services: make([]types.Service, 0, len(rows))}
+	for i := range rows {
+		cfg.Services = append(cfg.Services, rows[i].ToType())
+	}
+
+	return cfg, nil
+}
@@ -18,29 +18,10 @@ type UserAssignmentJoin struct {
 	AuthRule   AuthRule `gorm:"foreignKey:IdAuthRole;references:AuthRoleId" json:"auth_rule"`
 }
 
-func CreateUserAssignment(userAssignment *UserAssignment) *gorm.DB {
-	return database.GDB.Create(userAssignment)
-}
-
-func CreateUserAssignments(assignments []UserAssignment) *gorm.DB {
-	return database.GDB.Create(&assignments)
-}
-
 func UpdateUserAssignment(userAssignment *UserAssignment) *gorm.DB {
 	return database.GDB.Save(userAssignment)
 }
 
-func UpdateUserAssignments(assignments []UserAssignment) error {
-	tx := database.GDB.Begin()
-	for _, assignment := range assignments {
-		if err := tx.Save(&assignment).Error; err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
-	return tx.Commit().Error
-}
-
 func DeleteUserAssignment(accountId string, authRoleId int) *gorm.DB {
 	return database.GDB.Delete(&UserAssignment{}, "account_id = ? AND auth_role_id = ?", accountId, authRoleId)
 }
@@ -101,14 +101,6 @@ func UpdateUser(accountId interface{}, data interface{}) *gorm.DB {
 	return database.GDB.Model(&User{}).Where("id_account = ?", accountId).Updates(data)
 }
 
-func BlockUser(accountId interface{}) *gorm.DB {
-	return database.GDB.Model(&User{}).Select("status_account").Where("id_account = ?", accountId).Update("status_account", 0)
-}
-
-func UpdateUserPassword(accountId interface{}, data interface{}) *gorm.DB {
-	return database.GDB.Model(&User{}).Select("password_hash", "password_reset_token").Where("id_account = ?", accountId).Updates(data)
-}
-
 func GenerateAuthKeyUser(accountId interface{}, data interface{}) *gorm.DB {
 	return database.GDB.Model(&User{}).Select("AuthKey").Where("id_account = ?", accountId).Update("auth_key", data)
 }
@@ -126,6 +118,15 @@ func FindUserById(dest interface{}, idAccount interface{}) error {
 	return nil
 }
 
+func FindUserWithAssignmentsById(dest interface{}, idAccount interface{}) error {
+	err := database.GDB.Where("id_account = ?", idAccount).Preload("UserAssignments").First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("user is not found")
+	}
+
+	return err
+}
+
 func FindUserByIdentity(dest interface{}, username interface{}, email interface{}, phoneNumber interface{}, identityNumber interface{}) error {
 	err := database.GDB.
 		Where("LOWER(users.username) = LOWER(?) OR LOWER(users.email) = LOWER(?) OR users.phone_number = ? OR users.identity_number = ?", username, email, phoneNumber, identityNumber).
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go-gerbang/database"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Webhook posts the events matched by one of its filters to Url. Filters use
+// the NATS wildcards, "user.*" or "audit.>". The secret signs every delivery
+// and is only shown when it is set.
+type Webhook struct {
+	IdWebhook uuid.UUID                   `gorm:"type:uuid;primaryKey" json:"idWebhook"`
+	Name      string                      `gorm:"not null;size:128" json:"name"`
+	Url       string                      `gorm:"not null;size:2048" json:"url"`
+	Events    datatypes.JSONSlice[string] `gorm:"type:jsonb" json:"events"`
+	Secret    string                      `gorm:"not null;size:128" json:"-"`
+	Disabled  bool                        `gorm:"default:false" json:"disabled"`
+	CreatedBy string                      `gorm:"default:null;size:128" json:"createdBy"`
+	CreatedAt int                         `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt int                         `gorm:"default:0;autoUpdateTime" json:"updatedAt"`
+}
+
+// WebhookDelivery logs one attempt to deliver an event to a webhook.
+type WebhookDelivery struct {
+	ID         uint64         `gorm:"primaryKey;autoIncrement;type:bigint" json:"id"`
+	WebhookId  string         `gorm:"type:uuid;not null;index" json:"webhookId"`
+	Event      string         `gorm:"not null;size:128" json:"event"`
+	EventId    string         `gorm:"not null;size:128;index" json:"eventId"`
+	Payload    datatypes.JSON `gorm:"type:jsonb" json:"payload"`
+	Attempt    int            `gorm:"default:1" json:"attempt"`
+	Success    bool           `gorm:"default:false" json:"success"`
+	StatusCode int            `gorm:"default:0" json:"statusCode"`
+	Response   string         `gorm:"default:null;size:1024" json:"response"`
+	Error      string         `gorm:"default:null;size:512" json:"error"`
+	DurationMs int64          `gorm:"default:0" json:"durationMs"`
+	Test       bool           `gorm:"default:false" json:"test"`
+	CreatedAt  time.Time      `gorm:"type:timestamptz;index" json:"createdAt"`
+}
+
+func (w *Webhook) BeforeCreate(tx *gorm.DB) error {
+	if w.IdWebhook == uuid.Nil {
+		w.IdWebhook = uuid.New()
+	}
+	return nil
+}
+
+func CreateWebhook(webhook *Webhook) *gorm.DB {
+	return database.GDB.Create(webhook)
+}
+
+func UpdateWebhook(idWebhook interface{}, data interface{}) *gorm.DB {
+	return database.GDB.Model(&Webhook{}).Where("id_webhook = ?", idWebhook).Updates(data)
+}
+
+// DeleteWebhook removes the webhook with its delivery log.
+func DeleteWebhook(idWebhook interface{}) (int64, error) {
+	var deleted int64
+	err := database.GDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", idWebhook).Delete(&WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("id_webhook = ?", idWebhook).Delete(&Webhook{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	return deleted, err
+}
+
+func FindWebhook(dest interface{}, conds ...interface{}) *gorm.DB {
+	return database.GDB.Model(&Webhook{}).Order("created_at DESC").Find(dest, conds...)
+}
+
+func FindWebhookById(dest interface{}, idWebhook interface{}) error {
+	err := database.GDB.Where("id_webhook = ?", idWebhook).First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("webhook is not found")
+	}
+
+	return err
+}
+
+func CountFindWebhook(count *int64, conds ...interface{}) error {
+	tx := database.GDB.Model(&Webhook{})
+	if len(conds) > 0 {
+		tx = tx.Where(conds[0], conds[1:]...)
+	}
+	return tx.Count(count).Error
+}
+
+func CreateWebhookDelivery(delivery *WebhookDelivery) *gorm.DB {
+	return database.GDB.Create(delivery)
+}
+
+// FindWebhookDelivery lists the attempts of a webhook, newest first.
+func FindWebhookDelivery(dest *[]WebhookDelivery, webhookId string, limit int, offset int) *gorm.DB {
+	return database.GDB.Where("webhook_id = ?", webhookId).Order("id DESC").Limit(limit).Offset(offset).Find(dest)
+}
+
+func CountFindWebhookDelivery(count *int64, webhookId string) error {
+	return database.GDB.Model(&WebhookDelivery{}).Where("webhook_id = ?", webhookId).Count(count).Error
+}
+
+func DeleteWebhookDeliveryBefore(before time.Time) *gorm.DB {
+	return database.GDB.Where("created_at < ?", before).Delete(&WebhookDelivery{})
+}
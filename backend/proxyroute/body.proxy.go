@@ -0,0 +1,220 @@
+package proxyroute
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const defaultMaskReplacement = "***"
+
+var (
+	ErrRequestTransform  = errors.New("request body is not valid JSON")
+	ErrResponseTransform = errors.New("upstream response could not be transformed")
+)
+
+// bodyTransform is a types.BodyTransform with parsed paths. The config is
+// validated before it is loaded, so invalid paths are only logged here.
+type bodyTransform struct {
+	allow    []handlers.JSONPath
+	deny     []handlers.JSONPath
+	rename   []renameRule
+	mask     []fieldMask
+	envelope bool
+}
+
+type renameRule struct {
+	path handlers.JSONPath
+	to   string
+}
+
+type fieldMask struct {
+	paths       []handlers.JSONPath
+	roles       []int
+	replacement string
+}
+
+type bodyTransforms struct {
+	request  *bodyTransform
+	response *bodyTransform
+}
+
+func transformsForService(service types.Service) *bodyTransforms {
+	if service.BodyTransform == nil {
+		return nil
+	}
+
+	return &bodyTransforms{
+		request:  compileBodyTransform(service.Service, service.BodyTransform.Request),
+		response: compileBodyTransform(service.Service, service.BodyTransform.Response),
+	}
+}
+
+func compileBodyTransform(name string, cfg *types.BodyTransform) *bodyTransform {
+	if cfg == nil {
+		return nil
+	}
+
+	parse := func(paths []string) []handlers.JSONPath {
+		parsed := []handlers.JSONPath{}
+		for _, path := range paths {
+			jsonPath, err := handlers.ParseJSONPath(path)
+			if err != nil {
+				log.Printf("error: body transform of %s: %s", name, err)
+				continue
+			}
+			parsed = append(parsed, jsonPath)
+		}
+		return parsed
+	}
+
+	transform := &bodyTransform{
+		allow:    parse(cfg.Allow),
+		deny:     parse(cfg.Deny),
+		envelope: cfg.Envelope,
+	}
+	for path, to := range cfg.Rename {
+		if parsed := parse([]string{path}); len(parsed) == 1 {
+			transform.rename = append(transform.rename, renameRule{path: parsed[0], to: to})
+		}
+	}
+	for _, mask := range cfg.Mask {
+		replacement := mask.Replacement
+		if replacement == "" {
+			replacement = defaultMaskReplacement
+		}
+		transform.mask = append(transform.mask, fieldMask{
+			paths:       parse(mask.Paths),
+			roles:       mask.Roles,
+			replacement: replacement,
+		})
+	}
+
+	return transform
+}
+
+// rewritesResponse reports whether response bodies are transformed, they
+// must then reach the gateway without content encoding.
+func (t *bodyTransforms) rewritesResponse() bool {
+	return t != nil && t.response != nil
+}
+
+// applyRequest transforms a JSON request body, the envelope does not apply
+// to requests. A body that does not parse is rejected rather than sent on
+// untransformed.
+func (t *bodyTransforms) applyRequest(c fiber.Ctx, header http.Header, body string) (string, error) {
+	if t == nil || t.request == nil || body == "" || !isJSON(header.Get("Content-Type")) {
+		return body, nil
+	}
+
+	transformed, ok := t.request.apply(c, []byte(body), false)
+	if !ok {
+		return "", ErrRequestTransform
+	}
+	header.Del("Content-Length")
+	return string(transformed), nil
+}
+
+// applyResponse returns the body to send to the client, body may be shared
+// and is left untouched. A JSON body that is encoded or does not parse is
+// an error, it is never sent without the deny and mask rules.
+func (t *bodyTransforms) applyResponse(c fiber.Ctx, status int, header http.Header, body []byte) ([]byte, error) {
+	if t == nil || t.response == nil || !isJSON(header.Get("Content-Type")) {
+		return body, nil
+	}
+
+	if encoding := header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return nil, fmt.Errorf("%w: content encoding %s", ErrResponseTransform, encoding)
+	}
+
+	success := status >= 200 && status < 300
+	transformed, ok := t.response.apply(c, body, success)
+	if !ok {
+		return nil, ErrResponseTransform
+	}
+	return transformed, nil
+}
+
+func (t *bodyTransform) apply(c fiber.Ctx, body []byte, envelope bool) ([]byte, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, false
+	}
+
+	if len(t.allow) > 0 {
+		var kept interface{}
+		for _, path := range t.allow {
+			if picked, ok := path.Pick(document); ok {
+				kept = handlers.MergeJSON(kept, picked)
+			}
+		}
+		document = kept
+	}
+
+	for _, path := range t.deny {
+		path.Delete(document)
+	}
+
+	for _, rule := range t.rename {
+		rule.path.Rename(document, rule.to)
+	}
+
+	if len(t.mask) > 0 {
+		roles := userRoles(c)
+		for _, mask := range t.mask {
+			if slices.ContainsFunc(mask.roles, func(role int) bool { return slices.Contains(roles, role) }) {
+				continue
+			}
+			for _, path := range mask.paths {
+				path.Replace(document, mask.replacement)
+			}
+		}
+	}
+
+	if envelope && t.envelope {
+		document = &handlers.SuccessStruct{
+			Status:  true,
+			Message: "success",
+			Data:    document,
+		}
+	}
+
+	transformed, err := json.Marshal(document)
+	if err != nil {
+		return nil, false
+	}
+	return transformed, true
+}
+
+func userRoles(c fiber.Ctx) []int {
+	user, ok := c.Locals("user").(*models.UserData)
+	if !ok {
+		return nil
+	}
+
+	roles := make([]int, 0, len(user.UserAssignments))
+	for _, ua := range user.UserAssignments {
+		roles = append(roles, ua.AuthRoleId)
+	}
+	return roles
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
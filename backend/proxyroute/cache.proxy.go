@@ -0,0 +1,388 @@
+package proxyroute
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-gerbang/database"
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	CacheHit    = "hit"
+	CacheStale  = "stale"
+	CacheMiss   = "miss"
+	CacheBypass = "bypass"
+
+	HeaderCache    = "X-Cache"
+	HeaderCacheTag = "Cache-Tag"
+
+	cacheEntryPrefix = "cache:entry:"
+	cacheTagPrefix   = "cache:tag:"
+	cacheLockPrefix  = "cache:lock:"
+
+	defaultCacheMaxBodySize = 1 << 20
+)
+
+// headers that describe the connection or the caller, never replayed from
+// the cache
+var uncachedHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade", "Set-Cookie", "Content-Length",
+}
+
+// request headers that change the representation sent by most upstreams,
+// always part of the key
+var variantHeaders = []string{fiber.HeaderAccept, fiber.HeaderAcceptEncoding}
+
+type cachedResponse struct {
+	Status     int         `json:"status"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	StoredAt   int64       `json:"stored_at"`   // unix ms
+	FreshUntil int64       `json:"fresh_until"` // unix ms
+}
+
+type serviceCache struct {
+	service    types.Service
+	cfg        types.Cache
+	policy     *headerPolicy
+	transforms *bodyTransforms
+}
+
+// cacheRequest is the cache state of one proxied request.
+type cacheRequest struct {
+	cache  *serviceCache
+	key    string
+	status string
+}
+
+func cacheForService(service types.Service) *serviceCache {
+	if service.Cache == nil {
+		return nil
+	}
+
+	cfg := *service.Cache
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = defaultCacheMaxBodySize
+	}
+
+	return &serviceCache{service: service, cfg: cfg, policy: policyForService(service), transforms: transformsForService(service)}
+}
+
+// begin looks the request up. It returns nil when the request is not
+// cacheable, and writes the cached response to c when served is true. A
+// stale entry is served while the entry is refreshed in the background.
+func (sc *serviceCache) begin(c fiber.Ctx, upstreamURL string, header http.Header) (request *cacheRequest, served bool) {
+	if c.Method() != fiber.MethodGet || !sc.covers(c.Path()) {
+		return nil, false
+	}
+
+	directives := parseCacheControl(c.Get(fiber.HeaderCacheControl))
+	if _, ok := directives["no-store"]; ok {
+		c.Set(HeaderCache, strings.ToUpper(CacheBypass))
+		return &cacheRequest{status: CacheBypass}, false
+	}
+
+	request = &cacheRequest{cache: sc, key: cacheEntryPrefix + requestKey(c, sc.cfg.Key), status: CacheMiss}
+
+	_, noCache := directives["no-cache"]
+	if noCache || directives["max-age"] == "0" {
+		c.Set(HeaderCache, strings.ToUpper(CacheMiss))
+		return request, false
+	}
+
+	raw, err := database.RedisDb.Get(database.RedisCtx, request.key).Bytes()
+	if err != nil {
+		c.Set(HeaderCache, strings.ToUpper(CacheMiss))
+		return request, false
+	}
+
+	var cached cachedResponse
+	if err := json.Unmarshal(raw, &cached); err != nil {
+		c.Set(HeaderCache, strings.ToUpper(CacheMiss))
+		return request, false
+	}
+
+	now := time.Now().UnixMilli()
+	request.status = CacheHit
+	if now > cached.FreshUntil {
+		request.status = CacheStale
+		go sc.refresh(request.key, upstreamURL, header)
+	}
+
+	body, err := sc.transforms.applyResponse(c, cached.Status, cached.Header, cached.Body)
+	if err != nil {
+		handlers.BadGatewayErrorResponse(c, err)
+		return request, true
+	}
+
+	for key, values := range sc.policy.applyResponse(c, cached.Header) {
+		c.Set(key, strings.Join(values, ", "))
+	}
+	c.Set(fiber.HeaderAge, strconv.FormatInt((now-cached.StoredAt)/1000, 10))
+	c.Set(HeaderCache, strings.ToUpper(request.status))
+	c.Status(cached.Status)
+	c.Response().SetBody(body)
+
+	return request, true
+}
+
+// finish stores the upstream response of a miss.
+func (r *cacheRequest) finish(status int, header http.Header, body []byte) {
+	if r.cache == nil || r.status != CacheMiss {
+		return
+	}
+	r.cache.store(r.key, status, header, body)
+}
+
+func (sc *serviceCache) covers(path string) bool {
+	if len(sc.cfg.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range sc.cfg.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestKey identifies the response of a request for the cache and for
+// coalescing. The path stays readable so entries can be purged by prefix,
+// the variant parts are hashed.
+func requestKey(c fiber.Ctx, key types.CacheKey) string {
+	parts := []string{}
+
+	if !key.IgnoreQuery {
+		query := []string{}
+		for name, value := range c.Request().URI().QueryArgs().All() {
+			query = append(query, string(name)+"="+string(value))
+		}
+		sort.Strings(query)
+		parts = append(parts, "q:"+strings.Join(query, "&"))
+	}
+
+	for _, header := range slices.Concat(variantHeaders, key.Headers) {
+		parts = append(parts, "h:"+strings.ToLower(header)+"="+c.Get(header))
+	}
+
+	user, _ := c.Locals("user").(*models.UserData)
+	if key.User {
+		identity := "anonymous"
+		if user != nil {
+			identity = user.IdAccount
+		}
+		parts = append(parts, "u:"+identity)
+	}
+	if key.Role {
+		roles := []string{}
+		if user != nil {
+			for _, ua := range user.UserAssignments {
+				roles = append(roles, strconv.Itoa(int(ua.AuthRoleId)))
+			}
+		}
+		sort.Strings(roles)
+		parts = append(parts, "r:"+strings.Join(roles, ","))
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return c.Path() + "|" + hex.EncodeToString(sum[:])
+}
+
+// store saves a response unless the upstream or its size forbid it.
+func (sc *serviceCache) store(key string, status int, header http.Header, body []byte) {
+	if status != http.StatusOK || len(body) > sc.cfg.MaxBodySize || header.Get("Set-Cookie") != "" {
+		return
+	}
+	if !sc.keysVary(header) {
+		return
+	}
+
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache"} {
+		if _, ok := directives[directive]; ok {
+			return
+		}
+	}
+	if _, ok := directives["private"]; ok && !sc.cfg.Key.User {
+		return
+	}
+
+	ttl := sc.cfg.TTL
+	if value, ok := directives["s-maxage"]; ok {
+		ttl, _ = strconv.Atoi(value)
+	} else if value, ok := directives["max-age"]; ok {
+		ttl, _ = strconv.Atoi(value)
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	stale := sc.cfg.StaleWhileRevalidate
+	if value, ok := directives["stale-while-revalidate"]; ok {
+		stale, _ = strconv.Atoi(value)
+	}
+
+	stored := header.Clone()
+	for _, name := range uncachedHeaders {
+		stored.Del(name)
+	}
+
+	now := time.Now()
+	raw, err := json.Marshal(cachedResponse{
+		Status:     status,
+		Header:     stored,
+		Body:       body,
+		StoredAt:   now.UnixMilli(),
+		FreshUntil: now.Add(time.Duration(ttl) * time.Second).UnixMilli(),
+	})
+	if err != nil {
+		return
+	}
+
+	expiration := time.Duration(ttl+max(stale, 0)) * time.Second
+	tagExpiration := max(expiration, 24*time.Hour)
+
+	tags := append([]string{"service:" + sc.service.Service}, sc.cfg.Tags...)
+	for _, tag := range strings.Split(header.Get(HeaderCacheTag), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	pipe := database.RedisDb.TxPipeline()
+	pipe.Set(database.RedisCtx, key, raw, expiration)
+	for _, tag := range tags {
+		pipe.SAdd(database.RedisCtx, cacheTagPrefix+tag, key)
+		pipe.Expire(database.RedisCtx, cacheTagPrefix+tag, tagExpiration)
+	}
+	if _, err := pipe.Exec(database.RedisCtx); err != nil {
+		log.Printf("error: store cache %s: %s", key, err)
+	}
+}
+
+// keysVary reports whether every request header named by the Vary of the
+// response is part of the key, otherwise one variant would be replayed to
+// callers that asked for another.
+func (sc *serviceCache) keysVary(header http.Header) bool {
+	for _, value := range header.Values(fiber.HeaderVary) {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == "*" {
+				return false
+			}
+			keyed := slices.ContainsFunc(append(variantHeaders, sc.cfg.Key.Headers...), func(h string) bool {
+				return strings.EqualFold(h, name)
+			})
+			if !keyed {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// refresh fetches a stale entry again, one instance at a time.
+func (sc *serviceCache) refresh(key, upstreamURL string, header http.Header) {
+	locked, err := database.RedisDb.SetNX(database.RedisCtx, cacheLockPrefix+key, 1, 30*time.Second).Result()
+	if err != nil || !locked {
+		return
+	}
+	defer database.RedisDb.Del(database.RedisCtx, cacheLockPrefix+key)
+
+	header = header.Clone()
+	header.Del("Cache-Control")
+
+	resp, body, err := fetchUpstream(sc.service, http.MethodGet, upstreamURL, header, "")
+	if err != nil {
+		log.Printf("error: refresh cache %s: %s", key, err)
+		return
+	}
+
+	sc.store(key, resp.StatusCode, resp.Header, body)
+}
+
+// PurgeCachePrefix deletes the entries whose path starts with prefix.
+func PurgeCachePrefix(prefix string) (int64, error) {
+	var purged int64
+	iter := database.RedisDb.Scan(database.RedisCtx, 0, cacheEntryPrefix+escapeGlob(prefix)+"*", 500).Iterator()
+
+	keys := []string{}
+	for iter.Next(database.RedisCtx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == 500 {
+			deleted, err := database.RedisDb.Del(database.RedisCtx, keys...).Result()
+			if err != nil {
+				return purged, err
+			}
+			purged += deleted
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return purged, err
+	}
+
+	if len(keys) > 0 {
+		deleted, err := database.RedisDb.Del(database.RedisCtx, keys...).Result()
+		if err != nil {
+			return purged, err
+		}
+		purged += deleted
+	}
+
+	return purged, nil
+}
+
+// PurgeCacheTag deletes the entries stored with tag.
+func PurgeCacheTag(tag string) (int64, error) {
+	keys, err := database.RedisDb.SMembers(database.RedisCtx, cacheTagPrefix+tag).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	if len(keys) > 0 {
+		if purged, err = database.RedisDb.Del(database.RedisCtx, keys...).Result(); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := database.RedisDb.Del(database.RedisCtx, cacheTagPrefix+tag).Err(); err != nil {
+		return purged, fmt.Errorf("purge tag %s: %w", tag, err)
+	}
+	return purged, nil
+}
+
+func parseCacheControl(value string) map[string]string {
+	directives := map[string]string{}
+	for _, part := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+func escapeGlob(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+	return replacer.Replace(value)
+}
@@ -0,0 +1,124 @@
+package proxyroute
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go-gerbang/handlers"
+	"go-gerbang/types"
+)
+
+// serviceClients holds a *serviceClient per service, they are closed by
+// closeServiceClients when the routes are reloaded.
+var serviceClients sync.Map
+
+type serviceClient struct {
+	client   *http.Client
+	reloader *handlers.CertReloader
+}
+
+// storeServiceClient keeps the first client stored for key, a client built
+// by a racing request is closed. The certificates of the stored one are
+// watched from then on.
+func storeServiceClient(key string, client *http.Client, reloader *handlers.CertReloader) *http.Client {
+	entry := &serviceClient{client: client, reloader: reloader}
+	actual, loaded := serviceClients.LoadOrStore(key, entry)
+	if loaded {
+		entry.close()
+		return actual.(*serviceClient).client
+	}
+
+	if reloader != nil {
+		go reloader.Watch()
+	}
+	return client
+}
+
+func (s *serviceClient) close() {
+	s.client.CloseIdleConnections()
+	if s.reloader != nil {
+		s.reloader.Close()
+	}
+}
+
+// closeServiceClients drops every service client, their idle connections
+// and certificate watchers.
+func closeServiceClients() {
+	serviceClients.Range(func(key, value any) bool {
+		serviceClients.Delete(key)
+		value.(*serviceClient).close()
+		return true
+	})
+}
+
+// clientForService returns ProxyClient for plain upstreams and a dedicated
+// client, built once per service, when the service has upstream_tls.
+func clientForService(service types.Service) (*http.Client, error) {
+	if service.UpstreamTLS == nil {
+		return ProxyClient, nil
+	}
+
+	key := service.Service + " " + service.Path
+	if entry, ok := serviceClients.Load(key); ok {
+		return entry.(*serviceClient).client, nil
+	}
+
+	reloader, err := upstreamCertReloader(service.UpstreamTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := ProxyClient.Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig = reloader.UpstreamTLSConfig(service.UpstreamTLS.ServerName, service.UpstreamTLS.InsecureSkipVerify)
+
+	client := &http.Client{
+		Timeout:   ProxyClient.Timeout,
+		Transport: transport,
+	}
+
+	return storeServiceClient(key, client, reloader), nil
+}
+
+// upstreamCertReloader loads the client certificate and CA bundle of
+// upstream, they are reloaded when the files change.
+func upstreamCertReloader(upstream *types.UpstreamTLS) (*handlers.CertReloader, error) {
+	return handlers.NewCertReloader(upstream.CertFile, upstream.KeyFile, upstream.CAFile)
+}
+
+// grpcClientForService returns the HTTP/2 client of a grpc service, with
+// prior knowledge h2c for http:// upstreams and TLS for https://. It has no
+// timeout: streams may be long lived and callers send grpc-timeout.
+func grpcClientForService(service types.Service) (*http.Client, error) {
+	key := "grpc " + service.Service + " " + service.Path
+	if entry, ok := serviceClients.Load(key); ok {
+		return entry.(*serviceClient).client, nil
+	}
+
+	protocols := new(http.Protocols)
+	transport := &http.Transport{
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+		Protocols:           protocols,
+	}
+
+	var reloader *handlers.CertReloader
+	if strings.HasPrefix(service.Url, "https://") {
+		protocols.SetHTTP2(true)
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if service.UpstreamTLS != nil {
+			var err error
+			reloader, err = upstreamCertReloader(service.UpstreamTLS)
+			if err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig = reloader.UpstreamTLSConfig(service.UpstreamTLS.ServerName, service.UpstreamTLS.InsecureSkipVerify)
+		}
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return storeServiceClient(key, &http.Client{Transport: transport}, reloader), nil
+}
@@ -0,0 +1,59 @@
+package proxyroute
+
+import (
+	"net/http"
+
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"golang.org/x/sync/singleflight"
+)
+
+// upstreamGroup collapses identical concurrent upstream calls of this
+// instance into one.
+var upstreamGroup singleflight.Group
+
+// upstreamResult is an upstream response shared by coalesced requests, it
+// must not be modified.
+type upstreamResult struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// coalesceKeyForService returns the key function of a service with
+// coalesce, nil otherwise. The key is the response cache key, a service
+// without cache config varies by user when it needs auth, so a caller only
+// ever gets a response made for the same access.
+func coalesceKeyForService(service types.Service) func(c fiber.Ctx) string {
+	if !service.Coalesce {
+		return nil
+	}
+
+	key := types.CacheKey{User: service.AuthProtection || service.MtlsProtection}
+	if service.Cache != nil {
+		key = service.Cache.Key
+	}
+
+	return func(c fiber.Ctx) string {
+		return requestKey(c, key)
+	}
+}
+
+// coalesce runs call once for every caller with the same key in flight,
+// shared reports whether the result was made for another caller.
+func coalesce(key string, call func() (*upstreamResult, error)) (*upstreamResult, error, bool) {
+	value, err, shared := upstreamGroup.Do(key, func() (interface{}, error) {
+		return call()
+	})
+	if err != nil {
+		return nil, err, shared
+	}
+	return value.(*upstreamResult), nil, shared
+}
+
+// noStore reports whether the caller asked not to share its response.
+func noStore(c fiber.Ctx) bool {
+	_, ok := parseCacheControl(c.Get(fiber.HeaderCacheControl))["no-store"]
+	return ok
+}
@@ -0,0 +1,236 @@
+package proxyroute
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go-gerbang/types"
+)
+
+var (
+	ErrConcurrencyQueueFull    = errors.New("upstream is busy, queue is full")
+	ErrConcurrencyQueueTimeout = errors.New("upstream is busy, timed out in queue")
+)
+
+// serviceLimiters keeps one limiter per service path so a route reload keeps
+// the in-flight count and the learned limit as long as the config is equal.
+var serviceLimiters sync.Map
+
+// ConcurrencyStats is the state of a limiter reported by the admin API.
+type ConcurrencyStats struct {
+	Service  string  `json:"service"`
+	Path     string  `json:"path"`
+	Limit    int     `json:"limit"`
+	InFlight int     `json:"in_flight"`
+	Queued   int     `json:"queued"`
+	Rejected int64   `json:"rejected"`
+	Latency  float64 `json:"latency_ms"`
+	MinRTT   float64 `json:"min_rtt_ms"`
+}
+
+type concurrencyLimiter struct {
+	mu      sync.Mutex
+	service types.Service
+	raw     types.Concurrency // as configured, to detect changes
+	cfg     types.Concurrency // with defaults
+
+	limit    float64
+	inFlight int
+	queue    []chan struct{}
+	rejected int64
+
+	latency   float64 // smoothed latency in ms
+	minRTT    float64 // lowest latency seen since minRTTAt, in ms
+	minRTTAt  time.Time
+	decreased time.Time
+}
+
+// limiterForService returns the limiter of service, nil when the service has
+// no concurrency config.
+func limiterForService(service types.Service) *concurrencyLimiter {
+	if service.Concurrency == nil {
+		serviceLimiters.Delete(service.Path)
+		return nil
+	}
+
+	if current, ok := serviceLimiters.Load(service.Path); ok {
+		limiter := current.(*concurrencyLimiter)
+		if limiter.raw == *service.Concurrency {
+			return limiter
+		}
+	}
+
+	cfg := *service.Concurrency
+	if cfg.MinInFlight <= 0 {
+		cfg.MinInFlight = 1
+	}
+	if cfg.QueueTimeout <= 0 {
+		cfg.QueueTimeout = 1000
+	}
+
+	limiter := &concurrencyLimiter{
+		service:  service,
+		raw:      *service.Concurrency,
+		cfg:      cfg,
+		limit:    float64(cfg.MaxInFlight),
+		minRTTAt: time.Now(),
+	}
+
+	serviceLimiters.Store(service.Path, limiter)
+	return limiter
+}
+
+// pruneLimiters drops the limiters of paths that are no longer served.
+func pruneLimiters(paths []string) {
+	active := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		active[path] = true
+	}
+
+	serviceLimiters.Range(func(key, _ any) bool {
+		if !active[key.(string)] {
+			serviceLimiters.Delete(key)
+		}
+		return true
+	})
+}
+
+// acquire takes a slot, waiting in the queue up to QueueTimeout. The
+// returned release must be called with the upstream latency once the
+// request is done.
+func (l *concurrencyLimiter) acquire() (func(latency time.Duration, failed bool), error) {
+	l.mu.Lock()
+	if len(l.queue) == 0 && l.inFlight < l.currentLimit() {
+		l.inFlight++
+		l.mu.Unlock()
+		return l.release, nil
+	}
+
+	if len(l.queue) >= l.cfg.QueueSize {
+		l.rejected++
+		l.mu.Unlock()
+		return nil, ErrConcurrencyQueueFull
+	}
+
+	ready := make(chan struct{})
+	l.queue = append(l.queue, ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Duration(l.cfg.QueueTimeout) * time.Millisecond)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return l.release, nil
+	case <-timer.C:
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, waiting := range l.queue {
+		if waiting == ready {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			l.rejected++
+			return nil, ErrConcurrencyQueueTimeout
+		}
+	}
+
+	// granted between the timeout and the lock
+	return l.release, nil
+}
+
+func (l *concurrencyLimiter) release(latency time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	l.adapt(float64(latency.Microseconds())/1000, failed)
+
+	for len(l.queue) > 0 && l.inFlight < l.currentLimit() {
+		ready := l.queue[0]
+		l.queue = l.queue[1:]
+		l.inFlight++
+		close(ready)
+	}
+}
+
+func (l *concurrencyLimiter) currentLimit() int {
+	return int(l.limit)
+}
+
+// adapt moves the limit after each response. aimd adds one slot per limit
+// responses under LatencyTarget and cuts the limit by 10% above it or on
+// failure, at most once per smoothed latency. gradient scales the limit by
+// minRTT / latency and leaves sqrt(limit) of headroom for the queue.
+func (l *concurrencyLimiter) adapt(latency float64, failed bool) {
+	if l.latency == 0 {
+		l.latency = latency
+	} else {
+		l.latency = l.latency*0.9 + latency*0.1
+	}
+
+	// forget the lowest latency now and then so a faster upstream in the
+	// past does not keep the limit down forever
+	if l.minRTT == 0 || latency < l.minRTT || time.Since(l.minRTTAt) > time.Minute {
+		l.minRTT = latency
+		l.minRTTAt = time.Now()
+	}
+
+	low, high := float64(l.cfg.MinInFlight), float64(l.cfg.MaxInFlight)
+
+	switch l.cfg.Adaptive {
+	case types.ConcurrencyAIMD:
+		if failed || latency > float64(l.cfg.LatencyTarget) {
+			if time.Since(l.decreased) > time.Duration(l.latency*float64(time.Millisecond)) {
+				l.limit = math.Max(low, l.limit*0.9)
+				l.decreased = time.Now()
+			}
+			return
+		}
+		l.limit = math.Min(high, l.limit+1/l.limit)
+
+	case types.ConcurrencyGradient:
+		if l.latency <= 0 {
+			return
+		}
+		gradient := math.Max(0.5, math.Min(1, l.minRTT/l.latency))
+		if failed {
+			gradient = 0.5
+		}
+		next := l.limit*gradient + math.Sqrt(l.limit)
+		l.limit = math.Max(low, math.Min(high, l.limit*0.8+next*0.2))
+	}
+}
+
+func (l *concurrencyLimiter) stats() ConcurrencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return ConcurrencyStats{
+		Service:  l.service.Service,
+		Path:     l.service.Path,
+		Limit:    l.currentLimit(),
+		InFlight: l.inFlight,
+		Queued:   len(l.queue),
+		Rejected: l.rejected,
+		Latency:  l.latency,
+		MinRTT:   l.minRTT,
+	}
+}
+
+// GetConcurrencyStats reports the limiters of this instance.
+func GetConcurrencyStats() []ConcurrencyStats {
+	stats := []ConcurrencyStats{}
+	serviceLimiters.Range(func(_, value any) bool {
+		stats = append(stats, value.(*concurrencyLimiter).stats())
+		return true
+	})
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Path < stats[j].Path
+	})
+	return stats
+}
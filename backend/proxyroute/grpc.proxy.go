@@ -0,0 +1,562 @@
+package proxyroute
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+const (
+	MIMEGrpc        = "application/grpc"
+	MIMEGrpcWeb     = "application/grpc-web"
+	MIMEGrpcWebText = "application/grpc-web-text"
+
+	HeaderGrpcStatus  = "Grpc-Status"
+	HeaderGrpcMessage = "Grpc-Message"
+
+	// set by the last handler of the grpc routes once a call is authorized
+	grpcHeaderLocals = "grpcHeader"
+
+	grpcTrailerFlag = 0x80
+)
+
+// gRPC status codes used by the gateway
+const (
+	GrpcOK                = 0
+	GrpcCanceled          = 1
+	GrpcUnknown           = 2
+	GrpcInvalidArgument   = 3
+	GrpcDeadlineExceeded  = 4
+	GrpcPermissionDenied  = 7
+	GrpcResourceExhausted = 8
+	GrpcUnimplemented     = 12
+	GrpcInternal          = 13
+	GrpcUnavailable       = 14
+	GrpcUnauthenticated   = 16
+)
+
+// GrpcStatusFromHTTP maps the status of a gateway rejection to a gRPC
+// status.
+func GrpcStatusFromHTTP(status int) int {
+	switch status {
+	case fiber.StatusOK, fiber.StatusNoContent:
+		return GrpcOK
+	case fiber.StatusBadRequest:
+		return GrpcInvalidArgument
+	case fiber.StatusUnauthorized:
+		return GrpcUnauthenticated
+	case fiber.StatusForbidden:
+		return GrpcPermissionDenied
+	case fiber.StatusNotFound:
+		return GrpcUnimplemented
+	case fiber.StatusTooManyRequests:
+		return GrpcResourceExhausted
+	case fiber.StatusBadGateway, fiber.StatusServiceUnavailable:
+		return GrpcUnavailable
+	case fiber.StatusGatewayTimeout:
+		return GrpcDeadlineExceeded
+	}
+	return GrpcInternal
+}
+
+// ListenGrpc serves native gRPC calls to the grpc services on addr, over
+// TLS when tlsConfig is set and cleartext HTTP/2 (h2c) otherwise. The
+// calls go through the same protections as the HTTP routes, run on their
+// metadata, csrf aside.
+func ListenGrpc(addr string, tlsConfig *tls.Config) error {
+	protocols := new(http.Protocols)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           http.HandlerFunc(serveGrpc),
+		Protocols:         protocols,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if tlsConfig != nil {
+		protocols.SetHTTP2(true)
+		tlsConfig.NextProtos = []string{"h2"}
+		server.TLSConfig = tlsConfig
+		return server.ListenAndServeTLS("", "")
+	}
+
+	protocols.SetUnencryptedHTTP2(true)
+	return server.ListenAndServe()
+}
+
+// RegisterGrpcRoutes registers on app the protections of the grpc
+// services, ending with a handler that keeps the headers to send upstream.
+func RegisterGrpcRoutes(app *fiber.App) {
+	handlers.MapMicroServiceMutex.RLock()
+	defer handlers.MapMicroServiceMutex.RUnlock()
+
+	for _, service := range handlers.MapMicroService.Services {
+		if service.Type != types.ServiceTypeGrpc {
+			continue
+		}
+
+		if middlewares := serviceMiddlewares(service, true); len(middlewares) > 0 {
+			args := []interface{}{service.Path + "*"}
+			for _, m := range middlewares {
+				args = append(args, m)
+			}
+			app.Use(args...)
+		}
+
+		policy := policyForService(service)
+		app.All(service.Path+"*", func(c fiber.Ctx) error {
+			header := http.Header{}
+			for key, values := range c.GetReqHeaders() {
+				for _, value := range values {
+					header.Add(key, value)
+				}
+			}
+			policy.applyRequest(c, header)
+			header.Del(fiber.HeaderContentLength)
+			header.Set("Te", "trailers")
+
+			c.Locals(grpcHeaderLocals, header)
+			return c.SendStatus(fiber.StatusNoContent)
+		})
+	}
+}
+
+func serveGrpc(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), MIMEGrpc) {
+		http.Error(w, "only gRPC calls are served on this port", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	routes := currentRoutes.Load()
+	service, ok := handlers.FindServiceByPath(r.URL.Path)
+	if routes == nil || routes.grpcApp == nil || !ok || service.Type != types.ServiceTypeGrpc {
+		writeGrpcStatus(w, GrpcUnimplemented, "unknown service "+r.URL.Path)
+		logGrpcCall(types.Service{Service: "grpc"}, r.URL.Path, GrpcUnimplemented, time.Since(start), nil)
+		return
+	}
+
+	// authorize the call on its metadata
+	fctx := grpcRequestCtx(r)
+	routes.grpcHandler(fctx)
+	user, _ := fctx.UserValue("user").(*models.UserData)
+
+	header, ok := fctx.UserValue(grpcHeaderLocals).(http.Header)
+	if !ok {
+		code := GrpcStatusFromHTTP(fctx.Response.StatusCode())
+		writeGrpcStatus(w, code, gatewayErrorMessage(fctx.Response.Body()))
+		logGrpcCall(service, r.URL.Path, code, time.Since(start), user)
+		return
+	}
+
+	client, err := grpcClientForService(service)
+	if err != nil {
+		writeGrpcStatus(w, GrpcInternal, err.Error())
+		logGrpcCall(service, r.URL.Path, GrpcInternal, time.Since(start), user, zap.Error(err))
+		return
+	}
+	target, err := url.Parse(service.Url)
+	if err != nil {
+		writeGrpcStatus(w, GrpcInternal, err.Error())
+		logGrpcCall(service, r.URL.Path, GrpcInternal, time.Since(start), user, zap.Error(err))
+		return
+	}
+
+	policy := policyForService(service)
+	code := GrpcUnknown
+	var upstream *http.Response
+	var upstreamErr error
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.Header = header
+		},
+		Transport:     client.Transport,
+		FlushInterval: -1,
+		ModifyResponse: func(resp *http.Response) error {
+			upstream = resp
+
+			c := routes.grpcApp.AcquireCtx(fctx)
+			resp.Header = policy.applyResponse(c, resp.Header)
+			routes.grpcApp.ReleaseCtx(c)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			upstreamErr = err
+			code = grpcStatusFromError(err)
+			writeGrpcStatus(w, code, err.Error())
+		},
+	}
+	proxy.ServeHTTP(w, r)
+
+	if upstream != nil && upstreamErr == nil {
+		code = grpcResponseStatus(upstream)
+	}
+	if upstreamErr != nil {
+		logGrpcCall(service, r.URL.Path, code, time.Since(start), user, zap.Error(upstreamErr))
+		return
+	}
+	logGrpcCall(service, r.URL.Path, code, time.Since(start), user)
+}
+
+// grpcWebHandler translates gRPC-Web calls from browsers to gRPC. Messages
+// are framed the same way on both sides, the trailers go back as a last
+// frame flagged 0x80, and everything is base64 for grpc-web-text.
+func grpcWebHandler(service types.Service) fiber.Handler {
+	policy := policyForService(service)
+
+	return func(c fiber.Ctx) error {
+		start := time.Now()
+		path := c.Path()
+
+		contentType := c.Get(fiber.HeaderContentType)
+		if c.Method() != fiber.MethodPost || !strings.HasPrefix(contentType, MIMEGrpcWeb) {
+			return handlers.BadRequestErrorResponse(c, fmt.Errorf("service %s only accepts grpc-web calls", service.Service))
+		}
+		text := strings.HasPrefix(contentType, MIMEGrpcWebText)
+
+		body := c.Body()
+		if text {
+			decoded, err := decodeGrpcWebText(body)
+			if err != nil {
+				return handlers.BadRequestErrorResponse(c, fmt.Errorf("invalid grpc-web-text body: %w", err))
+			}
+			body = decoded
+		}
+
+		header := http.Header{}
+		for key, values := range c.GetReqHeaders() {
+			for _, value := range values {
+				header.Add(key, value)
+			}
+		}
+		policy.applyRequest(c, header)
+		header.Del(fiber.HeaderContentLength)
+		header.Del("X-Grpc-Web")
+		header.Set(fiber.HeaderContentType, grpcContentType(contentType))
+		header.Set("Te", "trailers")
+
+		user, _ := c.Locals("user").(*models.UserData)
+
+		client, err := grpcClientForService(service)
+		if err != nil {
+			return handlers.InternalServerErrorResponse(c, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(service.Url, "/")+path, strings.NewReader(string(body)))
+		if err != nil {
+			cancel()
+			return handlers.InternalServerErrorResponse(c, err)
+		}
+		req.Header = header
+
+		resp, err := client.Do(req)
+		if err != nil {
+			cancel()
+			code := grpcStatusFromError(err)
+			c.Set(fiber.HeaderContentType, contentType)
+			c.Set(HeaderGrpcStatus, strconv.Itoa(code))
+			c.Set(HeaderGrpcMessage, encodeGrpcMessage(err.Error()))
+			logGrpcCall(service, path, code, time.Since(start), user, zap.Error(err), zap.Bool("grpc_web", true))
+			c.Status(fiber.StatusOK)
+			return nil
+		}
+
+		for key, values := range policy.applyResponse(c, resp.Header) {
+			if key == HeaderGrpcStatus || key == HeaderGrpcMessage || key == fiber.HeaderContentType {
+				continue
+			}
+			for _, value := range values {
+				c.Append(key, value)
+			}
+		}
+		c.Set(fiber.HeaderContentType, webContentType(resp.Header.Get(fiber.HeaderContentType), text))
+		c.Status(fiber.StatusOK)
+
+		return c.SendStreamWriter(func(w *bufio.Writer) {
+			defer cancel()
+			defer resp.Body.Close()
+
+			frames := &grpcWebWriter{w: w, text: text}
+			code, message := GrpcUnknown, ""
+
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := resp.Body.Read(buf)
+				if n > 0 {
+					if err := frames.write(buf[:n]); err != nil {
+						// the browser went away
+						logGrpcCall(service, path, GrpcCanceled, time.Since(start), user, zap.Bool("grpc_web", true))
+						return
+					}
+				}
+				if err == io.EOF {
+					code, message = grpcResponseStatus(resp), resp.Trailer.Get(HeaderGrpcMessage)
+					if message == "" {
+						message = resp.Header.Get(HeaderGrpcMessage)
+					}
+					break
+				}
+				if err != nil {
+					code, message = grpcStatusFromError(err), encodeGrpcMessage(err.Error())
+					break
+				}
+			}
+
+			trailer := "grpc-status:" + strconv.Itoa(code) + "\r\n"
+			if message != "" {
+				trailer += "grpc-message:" + message + "\r\n"
+			}
+			for key, values := range resp.Trailer {
+				if key == HeaderGrpcStatus || key == HeaderGrpcMessage {
+					continue
+				}
+				for _, value := range values {
+					trailer += strings.ToLower(key) + ":" + value + "\r\n"
+				}
+			}
+			if err := frames.close([]byte(trailer)); err != nil {
+				log.Printf("error: grpc-web trailers of %s: %s", path, err)
+			}
+
+			logGrpcCall(service, path, code, time.Since(start), user, zap.Bool("grpc_web", true))
+		})
+	}
+}
+
+// grpcWebWriter writes the upstream frames to the browser. In text mode
+// bytes are held back to a multiple of 3 so the base64 chunks carry no
+// padding until the end.
+type grpcWebWriter struct {
+	w       *bufio.Writer
+	text    bool
+	pending []byte
+}
+
+func (g *grpcWebWriter) write(data []byte) error {
+	if g.text {
+		data = append(g.pending, data...)
+		keep := len(data) % 3
+		g.pending = append([]byte{}, data[len(data)-keep:]...)
+		data = []byte(base64.StdEncoding.EncodeToString(data[:len(data)-keep]))
+	}
+	if _, err := g.w.Write(data); err != nil {
+		return err
+	}
+	return g.w.Flush()
+}
+
+// close writes the trailer frame.
+func (g *grpcWebWriter) close(trailer []byte) error {
+	frame := make([]byte, 5, 5+len(trailer))
+	frame[0] = grpcTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(trailer)))
+	frame = append(frame, trailer...)
+
+	if g.text {
+		frame = []byte(base64.StdEncoding.EncodeToString(append(g.pending, frame...)))
+		g.pending = nil
+	}
+	if _, err := g.w.Write(frame); err != nil {
+		return err
+	}
+	return g.w.Flush()
+}
+
+// decodeGrpcWebText decodes a grpc-web-text body, which may be several
+// padded base64 chunks put together.
+func decodeGrpcWebText(body []byte) ([]byte, error) {
+	decoded := []byte{}
+	for rest := strings.TrimSpace(string(body)); rest != ""; {
+		end := strings.Index(rest, "=")
+		if end < 0 {
+			end = len(rest)
+		} else {
+			for end < len(rest) && rest[end] == '=' {
+				end++
+			}
+		}
+		chunk, err := base64.StdEncoding.DecodeString(rest[:end])
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, chunk...)
+		rest = rest[end:]
+	}
+	return decoded, nil
+}
+
+// grpcContentType is the gRPC content type of a gRPC-Web one, keeping the
+// message format: application/grpc-web-text+proto gives
+// application/grpc+proto.
+func grpcContentType(contentType string) string {
+	contentType = strings.TrimPrefix(contentType, MIMEGrpcWebText)
+	contentType = strings.TrimPrefix(contentType, MIMEGrpcWeb)
+	return MIMEGrpc + contentType
+}
+
+func webContentType(contentType string, text bool) string {
+	suffix := strings.TrimPrefix(contentType, MIMEGrpc)
+	if text {
+		return MIMEGrpcWebText + suffix
+	}
+	return MIMEGrpcWeb + suffix
+}
+
+// grpcResponseStatus reads the status from the trailers of a fully read
+// response, or from its headers for a trailers-only response.
+func grpcResponseStatus(resp *http.Response) int {
+	value := resp.Trailer.Get(HeaderGrpcStatus)
+	if value == "" {
+		value = resp.Header.Get(HeaderGrpcStatus)
+	}
+	if value == "" {
+		if resp.StatusCode != http.StatusOK {
+			return GrpcStatusFromHTTP(resp.StatusCode)
+		}
+		return GrpcUnknown
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return GrpcUnknown
+	}
+	return code
+}
+
+func grpcStatusFromError(err error) int {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return GrpcCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return GrpcDeadlineExceeded
+	}
+	return GrpcUnavailable
+}
+
+// writeGrpcStatus answers a native call with a trailers-only response.
+func writeGrpcStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", MIMEGrpc)
+	w.Header().Set(HeaderGrpcStatus, strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(HeaderGrpcMessage, encodeGrpcMessage(message))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeGrpcMessage percent-encodes what grpc-message does not allow.
+func encodeGrpcMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		ch := message[i]
+		if ch >= 0x20 && ch <= 0x7e && ch != '%' {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+// gatewayErrorMessage reads the message of a handlers.ErrorStruct body.
+func gatewayErrorMessage(body []byte) string {
+	var response struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil || response.Message == "" {
+		return strings.TrimSpace(string(body))
+	}
+	return response.Message
+}
+
+// grpcRequestCtx holds the metadata of r in a fasthttp request so the fiber
+// protections of a service can check it. The body is not copied, it is
+// streamed to the upstream.
+func grpcRequestCtx(r *http.Request) *fasthttp.RequestCtx {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.Header.SetMethod(r.Method)
+	req.SetRequestURI(r.URL.RequestURI())
+	req.Header.SetHost(r.Host)
+	for key, values := range r.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	remote, _ := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if remote == nil {
+		remote = &net.TCPAddr{}
+	}
+	var conn net.Conn = &metadataConn{remote: remote}
+	if r.TLS != nil {
+		conn = &metadataTLSConn{metadataConn: metadataConn{remote: remote}, state: *r.TLS}
+	}
+
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Init2(conn, log.Default(), true)
+	req.CopyTo(&fctx.Request)
+	return fctx
+}
+
+// metadataConn stands for the connection of a gRPC call, only its
+// addresses are used.
+type metadataConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *metadataConn) RemoteAddr() net.Addr { return c.remote }
+func (c *metadataConn) LocalAddr() net.Addr  { return &net.TCPAddr{} }
+
+// metadataTLSConn exposes the TLS state of the call, for mtls_protection.
+type metadataTLSConn struct {
+	metadataConn
+	state tls.ConnectionState
+}
+
+func (c *metadataTLSConn) Handshake() error                     { return nil }
+func (c *metadataTLSConn) ConnectionState() tls.ConnectionState { return c.state }
+
+func logGrpcCall(service types.Service, path string, code int, duration time.Duration, user *models.UserData, extra ...zap.Field) {
+	userField := zap.Skip()
+	if user != nil {
+		userField = zap.String("user", user.Username)
+	}
+
+	fields := []zap.Field{
+		zap.String("method", fiber.MethodPost),
+		zap.String("path", path),
+		zap.Int("status", fiber.StatusOK),
+		zap.Int("grpc_status", code),
+		zap.Duration("duration", duration),
+		zap.String("content_type", MIMEGrpc),
+		userField,
+	}
+
+	handlers.ZapLogger.Info(service.Service, append(fields, extra...)...)
+}
@@ -0,0 +1,162 @@
+package proxyroute
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"go-gerbang/models"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/requestid"
+)
+
+// GatewayCookiePrefix prefixes the cookies set by the gateway itself,
+// session, jwt, csrf and captcha.
+const GatewayCookiePrefix = "__SG"
+
+// hopByHopHeaders only apply to one connection, RFC 7230 section 6.1.
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// upstreamOnlyHeaders are dropped from responses unless a rule sets them.
+var upstreamOnlyHeaders = []string{"Server", "X-Powered-By"}
+
+var headerTemplate = regexp.MustCompile(`\$\{([a-z_.]+)\}`)
+
+type headerPolicy struct {
+	service types.Service
+	cfg     types.HeaderPolicy
+}
+
+func policyForService(service types.Service) *headerPolicy {
+	policy := &headerPolicy{service: service}
+	if service.Headers != nil {
+		policy.cfg = *service.Headers
+	}
+	return policy
+}
+
+// applyRequest rewrites the headers sent to the upstream in place.
+func (p *headerPolicy) applyRequest(c fiber.Ctx, header http.Header) {
+	stripHopByHop(header)
+
+	if !p.cfg.KeepGatewayCookies {
+		stripGatewayCookies(header)
+	}
+
+	p.apply(c, header, p.cfg.Request)
+}
+
+// applyResponse returns the headers to send to the client, header is shared
+// with other requests and is left untouched.
+func (p *headerPolicy) applyResponse(c fiber.Ctx, header http.Header) http.Header {
+	result := header.Clone()
+	stripHopByHop(result)
+	for _, name := range upstreamOnlyHeaders {
+		result.Del(name)
+	}
+
+	p.apply(c, result, p.cfg.Response)
+	return result
+}
+
+func (p *headerPolicy) apply(c fiber.Ctx, header http.Header, rules *types.HeaderRules) {
+	if rules == nil {
+		return
+	}
+
+	for _, name := range rules.Remove {
+		header.Del(name)
+	}
+	for from, to := range rules.Rename {
+		if values := header.Values(from); len(values) > 0 {
+			header.Del(from)
+			header[http.CanonicalHeaderKey(to)] = values
+		}
+	}
+	for name, value := range rules.Set {
+		header.Set(name, p.expand(c, value))
+	}
+	for name, value := range rules.Add {
+		header.Add(name, p.expand(c, value))
+	}
+}
+
+// expand fills ${name} from the request, unknown names and missing users
+// give an empty string.
+func (p *headerPolicy) expand(c fiber.Ctx, value string) string {
+	if !strings.Contains(value, "${") {
+		return value
+	}
+
+	user, _ := c.Locals("user").(*models.UserData)
+
+	return headerTemplate.ReplaceAllStringFunc(value, func(match string) string {
+		switch match[2 : len(match)-1] {
+		case "user.id":
+			if user != nil {
+				return user.IdAccount
+			}
+		case "user.username":
+			if user != nil {
+				return user.Username
+			}
+		case "user.email":
+			if user != nil {
+				return user.Email
+			}
+		case "request.id":
+			return requestid.FromContext(c)
+		case "request.ip":
+			return c.IP()
+		case "request.method":
+			return c.Method()
+		case "request.path":
+			return c.Path()
+		case "service":
+			return p.service.Service
+		}
+		return ""
+	})
+}
+
+// stripHopByHop drops the hop-by-hop headers and the headers named in
+// Connection.
+func stripHopByHop(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+func stripGatewayCookies(header http.Header) {
+	values := header.Values("Cookie")
+	if len(values) == 0 {
+		return
+	}
+
+	kept := []string{}
+	for _, value := range values {
+		for _, cookie := range strings.Split(value, ";") {
+			cookie = strings.TrimSpace(cookie)
+			if cookie != "" && !strings.HasPrefix(cookie, GatewayCookiePrefix) {
+				kept = append(kept, cookie)
+			}
+		}
+	}
+
+	header.Del("Cookie")
+	if len(kept) > 0 {
+		header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
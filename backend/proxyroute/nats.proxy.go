@@ -0,0 +1,160 @@
+package proxyroute
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-gerbang/broker"
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/requestid"
+	"github.com/nats-io/nats.go"
+)
+
+// Headers of the messages exchanged with the workers of a nats service.
+// The request message carries the body as data, the request headers and
+// the Gateway-* headers below. The reply carries the response body as
+// data, its headers become response headers and Gateway-Status the status
+// (200 when missing).
+const (
+	NatsHeaderPrefix    = "Gateway-"
+	NatsHeaderMethod    = "Gateway-Method"
+	NatsHeaderPath      = "Gateway-Path" // below the service path
+	NatsHeaderQuery     = "Gateway-Query"
+	NatsHeaderRequestId = "Gateway-Request-Id"
+	NatsHeaderUserId    = "Gateway-User-Id"
+	NatsHeaderUsername  = "Gateway-User-Name"
+	NatsHeaderRoles     = "Gateway-User-Roles" // auth role ids, comma separated
+	NatsHeaderStatus    = "Gateway-Status"
+
+	defaultNatsTimeout = 5000 // milliseconds
+)
+
+// setNatsIdentity replaces the Gateway-* headers a client may have sent
+// with the identity of the caller.
+func setNatsIdentity(c fiber.Ctx, header http.Header) {
+	for key := range header {
+		if strings.HasPrefix(key, NatsHeaderPrefix) {
+			header.Del(key)
+		}
+	}
+
+	if id := requestid.FromContext(c); id != "" {
+		header.Set(NatsHeaderRequestId, id)
+	}
+
+	user, ok := c.Locals("user").(*models.UserData)
+	if !ok {
+		return
+	}
+	header.Set(NatsHeaderUserId, user.IdAccount)
+	header.Set(NatsHeaderUsername, user.Username)
+
+	roles := []string{}
+	for _, ua := range user.UserAssignments {
+		roles = append(roles, strconv.Itoa(int(ua.AuthRoleId)))
+	}
+	header.Set(NatsHeaderRoles, strings.Join(roles, ","))
+}
+
+// requestNats sends a request to the subject of a nats service and reads
+// the reply as an http.Response. No responder gives 503 and a timeout 504,
+// as if an HTTP upstream had answered them.
+func requestNats(service types.Service, method, target string, header http.Header, body string) (*http.Response, []byte, error) {
+	if broker.NatsClient == nil {
+		return nil, nil, errors.New("nats client is not connected")
+	}
+
+	location, err := url.Parse(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	path := location.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	if limit := broker.NatsClient.MaxPayload(); int64(len(body)) > limit {
+		return natsErrorResponse(fiber.StatusRequestEntityTooLarge, fmt.Errorf("request body is over the nats max payload of %d bytes", limit))
+	}
+
+	msg := nats.NewMsg(service.Nats.Subject)
+	for key, values := range header {
+		msg.Header[key] = values
+	}
+	msg.Header.Set(NatsHeaderMethod, method)
+	msg.Header.Set(NatsHeaderPath, path)
+	if location.RawQuery != "" {
+		msg.Header.Set(NatsHeaderQuery, location.RawQuery)
+	}
+	msg.Data = []byte(body)
+
+	timeout := service.Nats.Timeout
+	if timeout <= 0 {
+		timeout = defaultNatsTimeout
+	}
+
+	reply, err := broker.NatsClient.RequestMsg(msg, time.Duration(timeout)*time.Millisecond)
+	switch {
+	case errors.Is(err, nats.ErrNoResponders):
+		return natsErrorResponse(fiber.StatusServiceUnavailable, fmt.Errorf("no worker is listening on %s", service.Nats.Subject))
+	case errors.Is(err, nats.ErrTimeout):
+		return natsErrorResponse(fiber.StatusGatewayTimeout, fmt.Errorf("no reply on %s within %dms", service.Nats.Subject, timeout))
+	case err != nil:
+		return nil, nil, err
+	}
+
+	status := fiber.StatusOK
+	responseHeader := http.Header{}
+	for key, values := range reply.Header {
+		if strings.EqualFold(key, NatsHeaderStatus) {
+			if code, err := strconv.Atoi(reply.Header.Get(key)); err == nil && code >= 100 && code <= 599 {
+				status = code
+			}
+			continue
+		}
+		for _, value := range values {
+			responseHeader.Add(key, value)
+		}
+	}
+	if responseHeader.Get(fiber.HeaderContentType) == "" && json.Valid(reply.Data) {
+		responseHeader.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+
+	return natsResponse(status, responseHeader, reply.Data), reply.Data, nil
+}
+
+func natsErrorResponse(status int, err error) (*http.Response, []byte, error) {
+	body, _ := json.Marshal(&handlers.ErrorStruct{
+		Message: err.Error(),
+		Status:  false,
+		Code:    status,
+	})
+	header := http.Header{}
+	header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return natsResponse(status, header, body), body, nil
+}
+
+func natsResponse(status int, header http.Header, body []byte) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
@@ -0,0 +1,184 @@
+package proxyroute
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go-gerbang/config"
+	"go-gerbang/handlers"
+	"go-gerbang/types"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gofiber/fiber/v3"
+)
+
+// openAPILoadTimeout bounds the fetch of each remote document or ref.
+const openAPILoadTimeout = 10 * time.Second
+
+var openAPIHTTPClient = &http.Client{Timeout: openAPILoadTimeout}
+
+// LoadOpenAPISpec loads and validates the OpenAPI document at spec, a file
+// under BASE_PATH or an http(s) url.
+func LoadOpenAPISpec(spec string) (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+	loader.IsExternalRefsAllowed = true
+	loader.ReadFromURIFunc = openapi3.ReadFromURIs(openapi3.ReadFromHTTP(openAPIHTTPClient), openapi3.ReadFromFile)
+
+	var doc *openapi3.T
+	var err error
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		location, parseErr := url.Parse(spec)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		doc, err = loader.LoadFromURI(location)
+	} else {
+		if !filepath.IsAbs(spec) {
+			spec = config.BasePath + spec
+		}
+		doc, err = loader.LoadFromFile(spec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load openapi %s: %w", spec, err)
+	}
+
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("invalid openapi %s: %w", spec, err)
+	}
+
+	return doc, nil
+}
+
+// OpenAPIValidation rejects requests to service that do not match its
+// OpenAPI document, with the field errors of handlers.ValidateStruct.
+func OpenAPIValidation(service types.Service) (fiber.Handler, error) {
+	doc, err := LoadOpenAPISpec(service.OpenAPI.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// match the upstream path whatever servers the document lists
+	doc.Servers = openapi3.Servers{{URL: "/"}}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &openapi3filter.Options{
+		MultiError:         true,
+		ExcludeRequestBody: service.OpenAPI.SkipBody,
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc, // the gateway authenticates
+	}
+
+	return func(c fiber.Ctx) error {
+		target := c.OriginalURL()[len(service.Path):]
+		if !strings.HasPrefix(target, "/") {
+			target = "/" + target
+		}
+
+		req, err := http.NewRequest(c.Method(), target, bytes.NewReader(c.Body()))
+		if err != nil {
+			return handlers.BadRequestErrorResponse(c, err)
+		}
+		for key, values := range c.GetReqHeaders() {
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+
+		route, pathParams, err := router.FindRoute(req)
+		if err != nil {
+			if service.OpenAPI.AllowUnknownPaths {
+				return c.Next()
+			}
+			field, tag := "path", "not_found"
+			if errors.Is(err, routers.ErrMethodNotAllowed) {
+				field, tag = "method", "method_not_allowed"
+			}
+			return handlers.ValidationErrorResponse(c, "error validation request", map[string]map[string]interface{}{
+				field: handlers.FieldError(tag, err.Error()),
+			})
+		}
+
+		err = openapi3filter.ValidateRequest(context.Background(), &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    options,
+		})
+		if err != nil {
+			return handlers.ValidationErrorResponse(c, "error validation request", openAPIErrors(err))
+		}
+
+		return c.Next()
+	}, nil
+}
+
+// openAPIUnavailable stands in for the validation of a service whose
+// document failed to load, its requests are refused instead of let through
+// unchecked.
+func openAPIUnavailable(service types.Service) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		return handlers.ServiceUnavailableErrorResponse(c, fmt.Errorf("openapi document of %s is not available", service.Service))
+	}
+}
+
+// openAPIErrors maps validation errors to fields such as query.page,
+// header.x-tenant or body.items.0.name.
+func openAPIErrors(err error) map[string]map[string]interface{} {
+	result := map[string]map[string]interface{}{}
+
+	var collect func(err error, field string)
+	collect = func(err error, field string) {
+		// not errors.As, both unwrap to the errors they hold
+		if multi, ok := err.(openapi3.MultiError); ok {
+			for _, item := range multi {
+				collect(item, field)
+			}
+			return
+		}
+
+		if requestErr, ok := err.(*openapi3filter.RequestError); ok {
+			switch {
+			case requestErr.Parameter != nil:
+				field = requestErr.Parameter.In + "." + requestErr.Parameter.Name
+			case requestErr.RequestBody != nil:
+				field = "body"
+			}
+			if requestErr.Err != nil {
+				collect(requestErr.Err, field)
+			} else {
+				result[field] = handlers.FieldError("invalid", requestErr.Reason)
+			}
+			return
+		}
+
+		var schemaErr *openapi3.SchemaError
+		if errors.As(err, &schemaErr) {
+			name := field
+			if pointer := schemaErr.JSONPointer(); len(pointer) > 0 {
+				name += "." + strings.Join(pointer, ".")
+			}
+			result[name] = handlers.FieldError(schemaErr.SchemaField, schemaErr.Reason)
+			return
+		}
+
+		if field == "" {
+			field = "request"
+		}
+		result[field] = handlers.FieldError("invalid", err.Error())
+	}
+	collect(err, "")
+
+	return result
+}
@@ -0,0 +1,224 @@
+package proxyroute
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go-gerbang/handlers"
+	"go-gerbang/types"
+)
+
+// Security schemes of the gateway, referenced by the operations of the
+// combined OpenAPI document.
+const (
+	SecurityBearer  = "bearerAuth"
+	SecurityCookie  = "cookieAuth"
+	SecurityApiKey  = "apiKeyAuth"
+	SecurityCsrf    = "csrfToken"
+	SecuritySession = "sessionCookie"
+)
+
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+var componentNameCleaner = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// ServiceOpenAPI is the OpenAPI document of one service rewritten for the
+// gateway: paths carry the service prefix, component names the service
+// name and operations the protection of the service.
+type ServiceOpenAPI struct {
+	Service    string
+	Paths      map[string]interface{}
+	Components map[string]map[string]interface{}
+}
+
+// AggregateOpenAPI loads the document of every service with an openapi
+// spec. A service whose document fails to load is reported in errs and
+// left out.
+func AggregateOpenAPI() (specs []ServiceOpenAPI, errs map[string]string) {
+	errs = map[string]string{}
+
+	for _, service := range openAPIServices() {
+		spec, err := serviceOpenAPI(service)
+		if err != nil {
+			errs[service.Service] = err.Error()
+			continue
+		}
+		specs = append(specs, *spec)
+	}
+
+	return specs, errs
+}
+
+// OperationSecurity is the security requirement of an operation behind the
+// protections of service, nil when it is public.
+func OperationSecurity(service types.Service) []interface{} {
+	schemes := []string{}
+	if service.AuthProtection {
+		switch service.AuthMode {
+		case types.AuthModeApiKey:
+			schemes = append(schemes, SecurityApiKey)
+		case types.AuthModeJWTOrApiKey:
+			schemes = append(schemes, SecurityBearer, SecurityCookie, SecurityApiKey)
+		default:
+			schemes = append(schemes, SecurityBearer, SecurityCookie)
+		}
+	}
+	if service.SessionProtection && len(schemes) == 0 {
+		schemes = append(schemes, SecuritySession)
+	}
+
+	requirements := []interface{}{}
+	for _, scheme := range schemes {
+		requirement := map[string]interface{}{scheme: []string{}}
+		if service.CsrfProtection {
+			requirement[SecurityCsrf] = []string{}
+		}
+		requirements = append(requirements, requirement)
+	}
+	if len(requirements) == 0 && service.CsrfProtection {
+		requirements = append(requirements, map[string]interface{}{SecurityCsrf: []string{}})
+	}
+
+	return requirements
+}
+
+// GatewayExtension is the x-gateway extension of an operation.
+func GatewayExtension(service types.Service) map[string]interface{} {
+	extension := map[string]interface{}{
+		"service": service.Service,
+		"auth":    service.AuthProtection,
+		"session": service.SessionProtection,
+		"csrf":    service.CsrfProtection,
+		"rbac":    service.RbacProtection,
+		"mtls":    service.MtlsProtection,
+	}
+	if service.AuthProtection {
+		mode := service.AuthMode
+		if mode == "" {
+			mode = types.AuthModeJWT
+		}
+		extension["auth_mode"] = mode
+	}
+	if len(service.RateLimits) > 0 {
+		extension["rate_limited"] = true
+	}
+	if service.Cache != nil {
+		extension["cache_ttl"] = service.Cache.TTL
+	}
+	return extension
+}
+
+func serviceOpenAPI(service types.Service) (*ServiceOpenAPI, error) {
+	doc, err := LoadOpenAPISpec(service.OpenAPI.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+
+	name := service.Service
+	if name == "" {
+		name = service.Path
+	}
+	prefix := strings.Trim(componentNameCleaner.ReplaceAllString(name, "_"), "_")
+
+	// prefix the component names so services can not collide
+	components := map[string]map[string]interface{}{}
+	refs := map[string]string{}
+	if sections, ok := document["components"].(map[string]interface{}); ok {
+		for section, entries := range sections {
+			entries, ok := entries.(map[string]interface{})
+			if !ok || section == "securitySchemes" {
+				continue
+			}
+			components[section] = map[string]interface{}{}
+			for entry, value := range entries {
+				renamed := prefix + "_" + entry
+				refs["#/components/"+section+"/"+entry] = "#/components/" + section + "/" + renamed
+				components[section][renamed] = value
+			}
+		}
+	}
+	rewriteRefs(components, refs)
+
+	basePath := strings.TrimSuffix(service.Path, "/")
+	security := OperationSecurity(service)
+	extension := GatewayExtension(service)
+
+	paths := map[string]interface{}{}
+	if items, ok := document["paths"].(map[string]interface{}); ok {
+		for path, item := range items {
+			item, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			delete(item, "servers")
+			rewriteRefs(item, refs)
+
+			for _, method := range openAPIMethods {
+				operation, ok := item[method].(map[string]interface{})
+				if !ok {
+					continue
+				}
+				delete(operation, "servers")
+				operation["tags"] = []string{name}
+				operation["security"] = security
+				operation["x-gateway"] = extension
+			}
+
+			paths[basePath+path] = item
+		}
+	}
+
+	return &ServiceOpenAPI{Service: name, Paths: paths, Components: components}, nil
+}
+
+// rewriteRefs replaces the $ref values found in refs, in place.
+func rewriteRefs(node interface{}, refs map[string]string) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if ref, ok := item.(string); ok && key == "$ref" {
+				if renamed, ok := refs[ref]; ok {
+					value[key] = renamed
+				}
+				continue
+			}
+			rewriteRefs(item, refs)
+		}
+	case map[string]map[string]interface{}:
+		for _, item := range value {
+			rewriteRefs(item, refs)
+		}
+	case []interface{}:
+		for _, item := range value {
+			rewriteRefs(item, refs)
+		}
+	}
+}
+
+// openAPIServices lists the services with an openapi spec, sorted by path.
+func openAPIServices() []types.Service {
+	handlers.MapMicroServiceMutex.RLock()
+	defer handlers.MapMicroServiceMutex.RUnlock()
+
+	services := []types.Service{}
+	for _, service := range handlers.MapMicroService.Services {
+		if service.OpenAPI != nil && service.OpenAPI.Spec != "" {
+			services = append(services, service)
+		}
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Path < services[j].Path
+	})
+	return services
+}
@@ -0,0 +1,51 @@
+package proxyroute
+
+import (
+	"net/http"
+	"time"
+
+	"go-gerbang/types"
+)
+
+type ProbeResult struct {
+	Path     string  `json:"path"`
+	Url      string  `json:"url"`
+	Ok       bool    `json:"ok"`
+	Status   int     `json:"status"`
+	Duration float64 `json:"duration"` // milliseconds
+	Error    string  `json:"error,omitempty"`
+}
+
+// ProbeService sends a GET to the upstream url of service with the client
+// the proxy would use. Any response below 500 counts as reachable.
+func ProbeService(service types.Service, timeout time.Duration) ProbeResult {
+	result := ProbeResult{Path: service.Path, Url: service.Url}
+
+	client, err := clientForService(service)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	req, err := http.NewRequest(http.MethodGet, service.Url, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	probeClient := *client
+	probeClient.Timeout = timeout
+
+	start := time.Now()
+	resp, err := probeClient.Do(req)
+	result.Duration = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.Status = resp.StatusCode
+	result.Ok = resp.StatusCode < http.StatusInternalServerError
+	return result
+}
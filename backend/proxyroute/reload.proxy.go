@@ -0,0 +1,133 @@
+package proxyroute
+
+import (
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"go-gerbang/cluster"
+	"go-gerbang/config"
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/valyala/fasthttp"
+)
+
+type ServiceReloadEvent struct {
+	Version int64 `json:"version"`
+}
+
+// proxyRoutes is one generation of proxy routes. The routes live on their own
+// fiber app, so a reload swaps the whole app instead of editing the router of
+// the main app, which can not drop routes while serving.
+type proxyRoutes struct {
+	paths   []string
+	handler fasthttp.RequestHandler
+
+	// protections of the grpc services for native calls, see ListenGrpc
+	grpcApp     *fiber.App
+	grpcHandler fasthttp.RequestHandler
+}
+
+var (
+	proxyAppConfig fiber.Config
+	currentRoutes  atomic.Pointer[proxyRoutes]
+)
+
+// LoadServices reads the services from the configured source.
+func LoadServices() (*types.ConfigServices, error) {
+	if config.ServicesFromDatabase() {
+		return models.LoadServiceConfig()
+	}
+	return handlers.LoadConfig(config.BasePath + config.ConfigPath)
+}
+
+// ReloadRoutes builds the routes of MapMicroService on a new app and makes it
+// the active generation, requests in flight finish on the old one.
+func ReloadRoutes() error {
+	app := fiber.New(proxyAppConfig)
+	RegisterRoutes(app)
+
+	grpcApp := fiber.New(proxyAppConfig)
+	RegisterGrpcRoutes(grpcApp)
+
+	handlers.MapMicroServiceMutex.RLock()
+	paths := make([]string, 0, len(handlers.MapMicroService.Services))
+	for _, service := range handlers.MapMicroService.Services {
+		paths = append(paths, service.Path)
+	}
+	handlers.MapMicroServiceMutex.RUnlock()
+
+	closeServiceClients()
+	pruneLimiters(paths)
+	currentRoutes.Store(&proxyRoutes{
+		paths:       paths,
+		handler:     app.Handler(),
+		grpcApp:     grpcApp,
+		grpcHandler: grpcApp.Handler(),
+	})
+
+	return nil
+}
+
+// ReloadServices loads the services again, validates them and reloads the
+// routes. An invalid set keeps the active routes.
+func ReloadServices() error {
+	cfg, err := LoadServices()
+	if err != nil {
+		return err
+	}
+
+	if issues := handlers.ValidateConfig(cfg); len(handlers.ConfigIssueErrors(issues)) > 0 {
+		return &handlers.ConfigValidationError{Issues: issues}
+	}
+
+	handlers.MapMicroServiceMutex.Lock()
+	handlers.MapMicroService = cfg
+	handlers.MapMicroServiceMutex.Unlock()
+
+	return ReloadRoutes()
+}
+
+// PublishServiceReload reloads this instance and tells the others to do the
+// same.
+func PublishServiceReload() error {
+	if err := ReloadServices(); err != nil {
+		return err
+	}
+
+	if err := cluster.Publish(cluster.EventServicesReload, ServiceReloadEvent{Version: handlers.ActiveConfigVersion.Load()}); err != nil {
+		log.Printf("error: publish %s: %s", cluster.EventServicesReload, err)
+	}
+
+	return nil
+}
+
+func SubscribeServiceReload() {
+	cluster.On(cluster.EventServicesReload, func(event cluster.Event) {
+		if err := ReloadServices(); err != nil {
+			log.Printf("error: reload services from %s: %s", event.Origin, err)
+		}
+	})
+}
+
+// dispatchProxy hands requests under a service path to the active proxy
+// routes, everything else continues on the main app.
+func dispatchProxy(c fiber.Ctx) error {
+	routes := currentRoutes.Load()
+	if routes == nil {
+		return c.Next()
+	}
+
+	path := c.Path()
+	for _, prefix := range routes.paths {
+		if strings.HasPrefix(path, prefix) {
+			routes.handler(c.RequestCtx())
+			return nil
+		}
+	}
+
+	return c.Next()
+}
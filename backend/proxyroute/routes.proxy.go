@@ -1,6 +1,7 @@
 package proxyroute
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -23,9 +24,7 @@ import (
 )
 
 func MainProxyRoutes(app *fiber.App) {
-	var err error
-
-	cfg, err := handlers.LoadConfig(config.BasePath + config.ConfigPath)
+	cfg, err := LoadServices()
 	if err != nil {
 		log.Fatalf("Error loading config: %v", err)
 	}
@@ -34,9 +33,21 @@ func MainProxyRoutes(app *fiber.App) {
 	handlers.MapMicroService = cfg
 	handlers.MapMicroServiceMutex.Unlock()
 
-	go handlers.WatchConfigFile(config.BasePath + config.ConfigPath)
+	go handlers.SyncConfigVersion(cfg)
+
+	proxyAppConfig = app.Config()
+	if err := ReloadRoutes(); err != nil {
+		log.Fatalf("Error registering proxy routes: %v", err)
+	}
+	app.Use(dispatchProxy)
 
-	RegisterRoutes(app)
+	if !config.ServicesFromDatabase() {
+		go handlers.WatchConfigFile(config.BasePath+config.ConfigPath, func(cfg *types.ConfigServices) {
+			if err := ReloadRoutes(); err != nil {
+				log.Printf("error: reload proxy routes: %s", err)
+			}
+		})
+	}
 }
 
 // var ProxyClient = &fasthttp.Client{
@@ -72,9 +83,12 @@ type CasbinRule struct {
 	V5    string `gorm:"default:null;size:128;uniqueIndex:unique_index"`
 }
 
+// RegisterRoutes registers the services of MapMicroService on app. They are
+// copied first, loading OpenAPI documents must not hold the lock.
 func RegisterRoutes(app *fiber.App) {
 	handlers.MapMicroServiceMutex.RLock()
-	defer handlers.MapMicroServiceMutex.RUnlock()
+	services := append([]types.Service{}, handlers.MapMicroService.Services...)
+	handlers.MapMicroServiceMutex.RUnlock()
 
 	// proxy.WithClient(ProxyClient)
 
@@ -108,26 +122,12 @@ func RegisterRoutes(app *fiber.App) {
 	// 	},
 	// })
 
-	sort.Slice(handlers.MapMicroService.Services, func(i, j int) bool {
-		return len(handlers.MapMicroService.Services[i].Path) > len(handlers.MapMicroService.Services[j].Path)
+	sort.Slice(services, func(i, j int) bool {
+		return len(services[i].Path) > len(services[j].Path)
 	})
 
-	for _, service := range handlers.MapMicroService.Services {
-		middlewares := []fiber.Handler{}
-
-		if service.CsrfProtection {
-			middlewares = append(middlewares, middleware.CsrfProtection)
-		}
-		if service.AuthProtection {
-			middlewares = append(middlewares, middleware.Auth)
-		}
-		if service.SessionProtection {
-			middlewares = append(middlewares, middleware.ValidateSession)
-		}
-		if service.RbacProtection {
-			// middlewares = append(middlewares, middleware.Auth)
-			middlewares = append(middlewares, middleware.AuthRBAC)
-		}
+	for _, service := range services {
+		middlewares := serviceMiddlewares(service, false)
 
 		// Build args properly
 		if len(middlewares) > 0 {
@@ -139,10 +139,52 @@ func RegisterRoutes(app *fiber.App) {
 		}
 
 		// Always add proxy handler
-		app.All(service.Path+"*", proxyHandler(service))
+		if service.Type == types.ServiceTypeGrpc {
+			app.All(service.Path+"*", grpcWebHandler(service))
+		} else {
+			app.All(service.Path+"*", proxyHandler(service))
+		}
 	}
 }
 
+// serviceMiddlewares is the protection chain run before the proxy handler
+// of service. Native gRPC calls skip csrf, which only makes sense for
+// browsers.
+func serviceMiddlewares(service types.Service, nativeGrpc bool) []fiber.Handler {
+	middlewares := []fiber.Handler{}
+
+	if service.CsrfProtection && !nativeGrpc {
+		middlewares = append(middlewares, middleware.CsrfProtection)
+	}
+	if service.MtlsProtection {
+		middlewares = append(middlewares, middleware.ClientCertAuth(service.Service))
+	}
+	if service.AuthProtection {
+		middlewares = append(middlewares, middleware.ServiceAuth(service))
+	}
+	if service.SessionProtection {
+		middlewares = append(middlewares, middleware.ValidateSession)
+	}
+	if service.RbacProtection {
+		// middlewares = append(middlewares, middleware.Auth)
+		middlewares = append(middlewares, middleware.AuthRBAC)
+	}
+	if len(service.RateLimits) > 0 {
+		middlewares = append(middlewares, middleware.RateLimit(service.Service, service.RateLimits, nil))
+	}
+	if service.OpenAPI != nil && service.OpenAPI.Validate && service.Type != types.ServiceTypeGrpc {
+		validator, err := OpenAPIValidation(service)
+		if err != nil {
+			log.Printf("error: openapi validation of %s: %s, its requests are refused", service.Service, err)
+			middlewares = append(middlewares, openAPIUnavailable(service))
+		} else {
+			middlewares = append(middlewares, validator)
+		}
+	}
+
+	return middlewares
+}
+
 // USING FASTHTTP
 // func proxyHandler(service types.Service) fiber.Handler {
 // 	return func(c fiber.Ctx) error {
@@ -213,6 +255,12 @@ func RegisterRoutes(app *fiber.App) {
 
 // USING NET HTTP
 func proxyHandler(service types.Service) fiber.Handler {
+	limiter := limiterForService(service)
+	cache := cacheForService(service)
+	coalesceKey := coalesceKeyForService(service)
+	policy := policyForService(service)
+	transforms := transformsForService(service)
+
 	return func(c fiber.Ctx) error {
 		start := time.Now()
 
@@ -223,50 +271,145 @@ func proxyHandler(service types.Service) fiber.Handler {
 		prefixLen := len(service.Path)
 		upstreamURL := service.Url + c.OriginalURL()[prefixLen:]
 
-		// Create HTTP request
-		req, err := http.NewRequest(method, upstreamURL, strings.NewReader(requestBody))
+		// Copy headers
+		header := http.Header{}
+		for key, values := range c.GetReqHeaders() {
+			for _, value := range values {
+				header.Add(key, value)
+			}
+		}
+		policy.applyRequest(c, header)
+		if service.Type == types.ServiceTypeNats {
+			setNatsIdentity(c, header)
+		}
+		// Without Accept-Encoding the transport asks for gzip itself and
+		// decodes it, so the transform gets plain JSON
+		if transforms.rewritesResponse() {
+			header.Del(fiber.HeaderAcceptEncoding)
+		}
+		upstreamBody, err := transforms.applyRequest(c, header, requestBody)
 		if err != nil {
-			return handleProxyError(c, service, method, path, err)
+			return handlers.BadRequestErrorResponse(c, err)
 		}
 
-		// Copy headers
-		for header, values := range c.GetReqHeaders() {
-			for _, value := range values {
-				req.Header.Add(header, value)
+		logFields := []zap.Field{}
+
+		var cached *cacheRequest
+		if cache != nil {
+			var served bool
+			cached, served = cache.begin(c, upstreamURL, header)
+			if cached != nil {
+				logFields = append(logFields, zap.String("cache", cached.status))
+			}
+			if served {
+				logProxyRequest(service, method, path, c.Response().StatusCode(), time.Since(start), requestBody, c.Response().Body(), c, logFields...)
+				return nil
 			}
 		}
 
-		// Execute request (automatically follows redirects)
-		resp, err := ProxyClient.Do(req)
+		call := func() (*upstreamResult, error) {
+			var release func(time.Duration, bool)
+			if limiter != nil {
+				var err error
+				if release, err = limiter.acquire(); err != nil {
+					return nil, err
+				}
+			}
+
+			// Execute request (automatically follows redirects)
+			acquired := time.Now()
+			resp, responseBody, err := fetchUpstream(service, method, upstreamURL, header, upstreamBody)
+			if release != nil {
+				release(time.Since(acquired), err != nil || resp.StatusCode >= fiber.StatusInternalServerError)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if cached != nil {
+				cached.finish(resp.StatusCode, resp.Header, responseBody)
+			}
+
+			return &upstreamResult{status: resp.StatusCode, header: resp.Header, body: responseBody}, nil
+		}
+
+		var result *upstreamResult
+		if coalesceKey != nil && method == fiber.MethodGet && !noStore(c) {
+			var shared bool
+			result, err, shared = coalesce(coalesceKey(c), call)
+			if shared {
+				logFields = append(logFields, zap.Bool("coalesced", true))
+			}
+		} else {
+			result, err = call()
+		}
+
+		if errors.Is(err, ErrConcurrencyQueueFull) || errors.Is(err, ErrConcurrencyQueueTimeout) {
+			c.Set(fiber.HeaderRetryAfter, "1")
+			return handlers.ServiceUnavailableErrorResponse(c, err)
+		}
 		if err != nil {
 			return handleProxyError(c, service, method, path, err)
 		}
-		defer resp.Body.Close()
 
-		// Read response body
-		responseBody, err := io.ReadAll(resp.Body)
+		responseBody, err := transforms.applyResponse(c, result.status, result.header, result.body)
 		if err != nil {
-			return handleProxyError(c, service, method, path, err)
+			logProxyRequest(service, method, path, fiber.StatusBadGateway, time.Since(start), requestBody, nil, c, logFields...)
+			return handlers.BadGatewayErrorResponse(c, err)
 		}
 
 		// Copy response headers
-		for key, values := range resp.Header {
+		for key, values := range policy.applyResponse(c, result.header) {
 			for _, value := range values {
 				c.Append(key, value)
 			}
 		}
 
 		// Set status and response
-		c.Status(resp.StatusCode)
+		c.Status(result.status)
 		duration := time.Since(start)
 
 		// Logging
-		logProxyRequest(service, method, path, resp.StatusCode, duration, requestBody, responseBody, c)
+		logProxyRequest(service, method, path, result.status, duration, requestBody, responseBody, c, logFields...)
 
 		return c.Send(responseBody)
 	}
 }
 
+// fetchUpstream sends one request to the upstream of service and reads the
+// whole response. Nats services are asked over request/reply instead.
+func fetchUpstream(service types.Service, method, upstreamURL string, header http.Header, body string) (*http.Response, []byte, error) {
+	if service.Type == types.ServiceTypeNats {
+		return requestNats(service, method, upstreamURL, header, body)
+	}
+
+	// Create HTTP request
+	req, err := http.NewRequest(method, upstreamURL, strings.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header = header
+
+	client, err := clientForService(service)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	// Read response body
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp, responseBody, nil
+}
+
 func handleProxyError(c fiber.Ctx, service types.Service, method, path string, err error) error {
 	duration := time.Since(time.Time{}) // You might want to track this properly
 
@@ -287,7 +430,7 @@ func handleProxyError(c fiber.Ctx, service types.Service, method, path string, e
 	return handlers.InternalServerErrorResponse(c, fmt.Errorf("upstream unavailable: %v", err))
 }
 
-func logProxyRequest(service types.Service, method, path string, status int, duration time.Duration, reqBody string, respBody []byte, c fiber.Ctx) {
+func logProxyRequest(service types.Service, method, path string, status int, duration time.Duration, reqBody string, respBody []byte, c fiber.Ctx, extra ...zap.Field) {
 	userField := zap.Skip()
 	if user, ok := c.Locals("user").(*models.UserData); ok {
 		userField = zap.String("user", user.Username)
@@ -307,7 +450,7 @@ func logProxyRequest(service types.Service, method, path string, status int, dur
 		respLog = zap.String("response_skipped", "binary or large response")
 	}
 
-	handlers.ZapLogger.Info(service.Service,
+	fields := []zap.Field{
 		zap.String("method", method),
 		zap.String("path", path),
 		zap.Int("status", status),
@@ -317,5 +460,7 @@ func logProxyRequest(service types.Service, method, path string, status int, dur
 		zap.Int("response_size", len(respBody)),
 		zap.String("content_type", contentType),
 		userField,
-	)
+	}
+
+	handlers.ZapLogger.Info(service.Service, append(fields, extra...)...)
 }
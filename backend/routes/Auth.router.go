@@ -38,13 +38,15 @@ func AuthRoutes(app *fiber.App) {
 	// authSession := auth.Group("/v1/auth") //.Use(middleware.Auth)
 	// authSession.Get("/auth-key/:token", services.AuthByJWT)
 
-	roleApi := app.Group("/auth/role", middleware.CsrfProtection, middleware.Auth)
+	adminUsers := middleware.AdminGuard(middleware.AdminPermissionUsers)
+
+	roleApi := app.Group("/auth/role", middleware.CsrfProtection, middleware.Auth, adminUsers)
 	roleApi.Get("/all", services.GetAllAuthRole)
 	roleApi.Post("/", services.CreateAuthRole)
 	roleApi.Put("/:id", services.UpdateAuthRole)
 	roleApi.Delete("/:id", services.DeleteAuthRule)
 
-	userAssignment := app.Group("/auth/user-assignment", middleware.CsrfProtection, middleware.Auth)
+	userAssignment := app.Group("/auth/user-assignment", middleware.CsrfProtection, middleware.Auth, adminUsers)
 	userAssignment.Get("/all", services.GetAllUserAssignments)
 	userAssignment.Post("/", services.CreateUserAssignment)
 	userAssignment.Post("/bulk", services.CreateUserAssignmentsBulk)
@@ -52,7 +54,20 @@ func AuthRoutes(app *fiber.App) {
 	userAssignment.Put("/bulk", services.UpdateUserAssignmentsBulk)
 	userAssignment.Delete("/:account_id/:auth_role_id", services.DeleteUserAssignment)
 
-	usersApi := app.Group("/users", middleware.CsrfProtection, middleware.Auth)
+	apiKeyApi := app.Group("/auth/api-key", middleware.CsrfProtection, middleware.Auth, adminUsers)
+	apiKeyApi.Get("/all", services.GetAllApiKey)
+	apiKeyApi.Post("/", services.CreateApiKey)
+	apiKeyApi.Put("/:id", services.UpdateApiKey)
+	apiKeyApi.Post("/:id/rotate", services.RotateApiKey)
+	apiKeyApi.Delete("/:id", services.RevokeApiKey)
+
+	clientCertApi := app.Group("/auth/client-cert", middleware.CsrfProtection, middleware.Auth, adminUsers)
+	clientCertApi.Get("/all", services.GetAllClientCertBinding)
+	clientCertApi.Post("/", services.CreateClientCertBinding)
+	clientCertApi.Put("/:id", services.UpdateClientCertBinding)
+	clientCertApi.Delete("/:id", services.DeleteClientCertBinding)
+
+	usersApi := app.Group("/users", middleware.CsrfProtection, middleware.Auth, adminUsers)
 	usersApi.Get("/all", services.GetAllUser)
 	usersApi.Get("/by-identity", services.FindUserByIdentity)
 	usersApi.Get("/by-id/:userId", services.FindUserById)
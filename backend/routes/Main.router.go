@@ -1,20 +1,14 @@
 package routes
 
 import (
+	"go-gerbang/config"
 	"go-gerbang/middleware"
 	"go-gerbang/services"
 
 	"github.com/gofiber/contrib/v3/monitor"
 	"github.com/gofiber/fiber/v3"
-	"github.com/gofiber/fiber/v3/middleware/basicauth"
 )
 
-var baseConfig = basicauth.Config{
-	Users: map[string]string{
-		"admin": "{SHA256}ITby5cH1QqwpO5S2UvXJLErtCVlFyVewpW97RXHUNKI=", //@dmin9192
-	},
-}
-
 func MainRoutes(app *fiber.App) {
 	// GET CSRF TOKEN
 	app.Get("/secure-gateway-c", middleware.CsrfProtection, services.IndexService)
@@ -22,35 +16,120 @@ func MainRoutes(app *fiber.App) {
 	// PROTECT
 	app.Get("/test-protect", middleware.Auth, services.ProtectService)
 
+	// FORWARD AUTH FOR EXTERNAL REVERSE PROXY
+	app.All("/forward-auth", services.ForwardAuth)
+
 	app.Get("/check-migration", services.CheckMigrationStatus)
-	app.Get("/migration", basicauth.New(baseConfig), services.MigrationService)
-	app.Post("/migration-admin", basicauth.New(baseConfig), services.MigrateAdminUser)
+	app.Get("/migration", middleware.MigrationBasicAuth, services.MigrationService)
+	app.Post("/migration-admin", middleware.MigrationBasicAuth, services.MigrateAdminUser)
+
+	// ADMIN GUARDS
+	adminConfig := middleware.AdminGuard(middleware.AdminPermissionConfig)
+	adminOps := middleware.AdminGuard(middleware.AdminPermissionOps)
+	adminOnly := middleware.AdminGuard(middleware.AdminPermission)
 
-	app.Get("/info", services.InfoService)
+	app.Get("/info", adminOps, services.InfoService)
 
-	app.Get("/check-local-service", services.CheckLocalService)
-	app.Get("/proxy-local-service", services.ProxyLocalService)
+	app.Get("/check-local-service", adminOps, services.CheckLocalService)
+	app.Get("/proxy-local-service", adminOps, services.ProxyLocalService)
 
-	app.Get("/Configuration/execute", services.ConfigExecuteScript)
-	app.Get("/Configuration/:group", services.GetConfigurationByGroup)
-	app.Post("/Configuration", services.UpsertConfiguration)
-	app.Delete("/Configuration/:group", services.DeleteConfiguration)
+	app.Get("/Configuration/execute", adminConfig, services.ConfigExecuteScript)
+	app.Get("/Configuration/:group", adminConfig, services.GetConfigurationByGroup)
+	app.Post("/Configuration", middleware.CsrfProtection, adminConfig, services.UpsertConfiguration)
+	app.Delete("/Configuration/:group", middleware.CsrfProtection, adminConfig, services.DeleteConfiguration)
 
-	// PUB / SUB
-	app.Post("/publish", services.PublishService)
-	app.Get("/subscribe", services.SubscribeService)
+	// PUB / SUB, subjects are authorized by the nats:<subject> policies
+	eventsAuth := middleware.AuthOrApiKey(middleware.BrokerApiKeyScope)
+	app.Post("/publish", eventsAuth, middleware.CsrfProtection, services.PublishService)
+	app.Get("/subscribe", eventsAuth, services.SubscribeService)
+	app.Get("/subscribe/ws", eventsAuth, services.SubscribeWebSocket)
 
 	// MAIL
-	app.Get("/check-mail", services.MailTesting)
+	app.Get("/check-mail", adminOps, services.MailTesting)
+
+	// EMAIL TEMPLATES
+	emailTemplateApi := app.Group("/email-template", middleware.CsrfProtection, adminConfig)
+	emailTemplateApi.Get("/all", services.GetAllEmailTemplate)
+	emailTemplateApi.Get("/builtin", services.GetBuiltinEmailTemplate)
+	emailTemplateApi.Get("/versions", services.GetEmailTemplateVersion)
+	emailTemplateApi.Post("/preview", services.PreviewEmailTemplate)
+	emailTemplateApi.Get("/:id", services.GetEmailTemplateById)
+	emailTemplateApi.Post("/", services.SaveEmailTemplate)
+	emailTemplateApi.Post("/:id/rollback", services.RollbackEmailTemplate)
+	emailTemplateApi.Delete("/:id", services.DeleteEmailTemplate)
+
+	// NOTIFICATION QUEUE, resending or dropping emails is for gateway admins
+	notificationApi := app.Group("/notification")
+	notificationApi.Get("/queue", adminOps, services.GetNotificationQueue)
+	notificationApi.Get("/dead-letter", adminOps, services.GetAllDeadLetter)
+	notificationApi.Post("/dead-letter/retry", middleware.CsrfProtection, adminOnly, services.RetryAllDeadLetter)
+	notificationApi.Post("/dead-letter/:seq/retry", middleware.CsrfProtection, adminOnly, services.RetryDeadLetter)
+	notificationApi.Delete("/dead-letter/:seq", middleware.CsrfProtection, adminOnly, services.DeleteDeadLetter)
+	notificationApi.Delete("/dead-letter", middleware.CsrfProtection, adminOnly, services.PurgeDeadLetter)
+
+	// OUTBOUND WEBHOOKS
+	webhookApi := app.Group("/webhook", middleware.CsrfProtection, adminConfig)
+	webhookApi.Get("/all", services.GetAllWebhook)
+	webhookApi.Get("/:id", services.GetWebhookById)
+	webhookApi.Get("/:id/deliveries", services.GetAllWebhookDelivery)
+	webhookApi.Post("/", services.CreateWebhook)
+	webhookApi.Post("/:id/test", services.TestWebhook)
+	webhookApi.Put("/:id", services.UpdateWebhook)
+	webhookApi.Delete("/:id", services.DeleteWebhook)
 
 	// SERVICE
-	app.Post("/restart", services.RestartHandler)
-	app.Post("/config-file", services.HandleConfigFile)
-	app.Post("/upload-file", services.HandleFileUpload)
+	app.Post("/restart", middleware.CsrfProtection, adminConfig, services.RestartHandler)
+	app.Post("/config-file", middleware.CsrfProtection, adminConfig, services.HandleConfigFile)
+	app.Post("/config-file/dry-run", middleware.CsrfProtection, adminConfig, services.DryRunConfig)
+	app.Get("/config-file/versions", adminConfig, services.GetAllConfigVersion)
+	app.Get("/config-file/versions/diff", adminConfig, services.DiffConfigVersion)
+	app.Get("/config-file/versions/:version", adminConfig, services.GetConfigVersion)
+	app.Post("/config-file/versions/:version/rollback", middleware.CsrfProtection, adminConfig, services.RollbackConfigVersion)
+	app.Post("/upload-file", middleware.CsrfProtection, adminConfig, services.HandleFileUpload)
+
+	app.Get("/log-stats-proxy", adminOps, services.GetStatsLogger)
+	app.Get("/metrics", adminOps, monitor.New(monitor.Config{APIOnly: true}))
+
+	// SERVICES TABLE
+	serviceApi := app.Group("/service", middleware.CsrfProtection, adminConfig)
+	serviceApi.Get("/all", services.GetAllService)
+	serviceApi.Get("/concurrency", services.GetServiceConcurrency)
+	serviceApi.Get("/:id", services.GetServiceById)
+	serviceApi.Post("/", services.CreateService)
+	serviceApi.Post("/import", services.ImportServiceConfig)
+	serviceApi.Put("/:id", services.UpdateService)
+	serviceApi.Delete("/:id", services.DeleteService)
+
+	// RESPONSE CACHE
+	app.Post("/cache/purge", middleware.CsrfProtection, adminConfig, services.PurgeCache)
+
+	// API DOCUMENTATION
+	docsApi := app.Group("/docs")
+	if !config.ApiDocsPublic {
+		docsApi.Use(adminOps)
+	}
+	docsApi.Get("/", services.GetDocsUI)
+	docsApi.Get("/openapi.json", services.GetOpenAPIDocument)
+
+	// AUDIT TRAIL
+	app.Get("/audit/events", adminOps, services.GetAllAuditEvent)
+	app.Get("/audit/events/export", adminOps, services.ExportAuditEvent)
+
+	// ADMIN ROLES AND BOOTSTRAP CREDENTIALS
+	adminApi := app.Group("/admin", middleware.CsrfProtection, adminOnly)
+	adminApi.Get("/role/all", services.GetAllAdminRole)
+	adminApi.Post("/role", services.GrantAdminRole)
+	adminApi.Delete("/role/:auth_role_id/:admin_role", services.RevokeAdminRole)
+	adminApi.Get("/basic-auth/all", services.GetAllBasicAuthCredential)
+	adminApi.Post("/basic-auth", services.UpsertBasicAuthCredential)
+	adminApi.Delete("/basic-auth/:username", services.DeleteBasicAuthCredential)
 
-	app.Get("/log-stats-proxy", services.GetStatsLogger)
-	app.Get("/metrics", monitor.New(monitor.Config{APIOnly: true}))
+	// CLUSTER
+	app.Get("/cluster/status", adminOps, services.GetClusterStatus)
+	app.Get("/cluster/health", adminOps, services.GetClusterHealth)
+	app.Post("/cluster/csrf", middleware.CsrfProtection, adminConfig, services.SetCsrfActivated)
 
 	// services.SubscribeServiceEmail()
 	services.SubscribeEvent()
+	services.StartCluster()
 }
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+
+	"go-gerbang/handlers"
+	"go-gerbang/middleware"
+	"go-gerbang/models"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+type AdminRoleInput struct {
+	AuthRoleId int    `json:"auth_role_id" validate:"required"`
+	AdminRole  string `json:"admin_role" validate:"required"`
+}
+
+// GetAllAdminRole lists the built-in admin roles with their permission and
+// the auth roles they are granted to.
+func GetAllAdminRole(c fiber.Ctx) error {
+	authz, err := middleware.Enforcer()
+	if err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	result := []fiber.Map{}
+	for role, policy := range middleware.AdminRolePolicies {
+		grantedTo, err := authz.GetUsersForRole(role)
+		if err != nil {
+			return handlers.InternalServerErrorResponse(c, err)
+		}
+
+		result = append(result, fiber.Map{
+			"admin_role": role,
+			"permission": policy[0],
+			"act":        policy[1],
+			"granted_to": grantedTo,
+		})
+	}
+
+	count := int64(len(result))
+	return handlers.SuccessResponse(c, true, "success to get all admin role", result, &count)
+}
+
+func GrantAdminRole(c fiber.Ctx) error {
+	u := new(AdminRoleInput)
+
+	if err := handlers.ParseBody(c, u); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	if err := handlers.ValidateStruct(*u); err != nil {
+		return handlers.SuccessResponse(c, false, "error validation admin role", err, nil)
+	}
+
+	if _, ok := middleware.AdminRolePolicies[u.AdminRole]; !ok {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("unknown admin role %s", u.AdminRole))
+	}
+
+	var count int64
+	if err := models.CountFindAuthRule(&count, "id_auth_role = ?", u.AuthRoleId); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+	if count == 0 {
+		return handlers.NotFoundErrorResponse(c, fmt.Errorf("auth role is not found"))
+	}
+
+	authz, err := middleware.Enforcer()
+	if err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	if _, err := authz.AddGroupingPolicy(fmt.Sprintf("role:%d", u.AuthRoleId), u.AdminRole); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	broadcastPolicyReload()
+	RecordAudit(c, AuditAdminRoleGrant, "auth_role", strconv.Itoa(u.AuthRoleId), nil, u)
+
+	return handlers.SuccessResponse(c, true, "success to grant admin role", nil, nil)
+}
+
+func RevokeAdminRole(c fiber.Ctx) error {
+	authRoleId, err := strconv.Atoi(c.Params("auth_role_id"))
+	if err != nil {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need auth_role_id params"))
+	}
+
+	adminRole := c.Params("admin_role")
+	if _, ok := middleware.AdminRolePolicies[adminRole]; !ok {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("unknown admin role %s", adminRole))
+	}
+
+	authz, err := middleware.Enforcer()
+	if err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	removed, err := authz.RemoveGroupingPolicy(fmt.Sprintf("role:%d", authRoleId), adminRole)
+	if err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+	if !removed {
+		return handlers.NotFoundErrorResponse(c, fmt.Errorf("admin role is not granted to this auth role"))
+	}
+
+	broadcastPolicyReload()
+	RecordAudit(c, AuditAdminRoleRevoke, "auth_role", strconv.Itoa(authRoleId), AdminRoleInput{AuthRoleId: authRoleId, AdminRole: adminRole}, nil)
+
+	return handlers.SuccessResponse(c, true, "success to revoke admin role", nil, nil)
+}
+
+func GetAllBasicAuthCredential(c fiber.Ctx) error {
+	d := &[]models.BasicAuthCredential{}
+
+	if err := models.FindBasicAuthCredential(d).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	count := int64(len(*d))
+	return handlers.SuccessResponse(c, true, "success to get all basic auth credential", d, &count)
+}
+
+// UpsertBasicAuthCredential stores the bcrypt hash of a /migration
+// credential, once a row exists the env bootstrap credential is ignored.
+func UpsertBasicAuthCredential(c fiber.Ctx) error {
+	u := new(models.BasicAuthCredential)
+
+	if err := handlers.ParseBody(c, u); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	if err := handlers.ValidateStruct(*u); err != nil {
+		return handlers.SuccessResponse(c, false, "error validation basic auth credential", err, nil)
+	}
+
+	var before interface{}
+	existing := &[]models.BasicAuthCredential{}
+	if err := models.FindBasicAuthCredential(existing, "username = ?", u.Username).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+	if len(*existing) > 0 {
+		before = (*existing)[0]
+	}
+
+	u.PasswordHash = handlers.GeneratePasswordHash(u.Password)
+	if err := models.UpsertBasicAuthCredential(u).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditBasicAuthUpsert, "basic_auth", u.Username, before, u)
+
+	return handlers.SuccessResponse(c, true, "success to save basic auth credential", nil, nil)
+}
+
+func DeleteBasicAuthCredential(c fiber.Ctx) error {
+	username := c.Params("username")
+
+	result := models.DeleteBasicAuthCredential(username)
+	if result.Error != nil {
+		return handlers.InternalServerErrorResponse(c, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return handlers.NotFoundErrorResponse(c, fmt.Errorf("basic auth credential is not found"))
+	}
+
+	RecordAudit(c, AuditBasicAuthDelete, "basic_auth", username, models.BasicAuthCredential{Username: username}, nil)
+
+	return handlers.SuccessResponse(c, true, "success to delete basic auth credential", nil, nil)
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"fmt"
+
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/datatypes"
+)
+
+func GetAllApiKey(c fiber.Ctx) error {
+	var count int64
+
+	d := &[]models.ApiKey{}
+
+	ownerId := c.Query("owner_id")
+	if ownerId != "" {
+		if err := models.FindApiKey(d, "owner_id = ?", ownerId).Error; err != nil {
+			return handlers.InternalServerErrorResponse(c, err)
+		}
+		if err := models.CountFindApiKey(&count, "owner_id = ?", ownerId); err != nil {
+			return handlers.InternalServerErrorResponse(c, err)
+		}
+	} else {
+		if err := models.FindApiKey(d).Error; err != nil {
+			return handlers.InternalServerErrorResponse(c, err)
+		}
+		if err := models.CountFindApiKey(&count); err != nil {
+			return handlers.InternalServerErrorResponse(c, err)
+		}
+	}
+
+	return handlers.SuccessResponse(c, true, "success to get all api key", d, &count)
+}
+
+func CreateApiKey(c fiber.Ctx) error {
+	input := new(types.ApiKeyInput)
+
+	if err := handlers.ParseBody(c, input); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	if err := handlers.ValidateStruct(*input); err != nil {
+		return handlers.SuccessResponse(c, false, "error validation api key", err, nil)
+	}
+
+	owner := new(models.User)
+	if err := models.FindUserById(owner, input.OwnerId); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+	if owner.StatusAccount != models.UserStatusActive {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("owner of the api key is not active"))
+	}
+
+	raw, prefix, hash := handlers.GenerateApiKey()
+
+	apiKey := &models.ApiKey{
+		Name:       input.Name,
+		Prefix:     prefix,
+		KeyHash:    hash,
+		OwnerId:    input.OwnerId,
+		Scopes:     datatypes.NewJSONSlice(input.Scopes),
+		AllowedIps: datatypes.NewJSONSlice(input.AllowedIps),
+		RateLimit:  input.RateLimit,
+		ExpiresAt:  input.ExpiresAt,
+	}
+
+	if user, ok := c.Locals("user").(*models.UserData); ok {
+		apiKey.CreatedBy = user.Username
+	}
+
+	if err := models.CreateApiKey(apiKey).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditApiKeyCreate, "api_key", apiKey.IdApiKey.String(), nil, apiKey)
+
+	res := fiber.Map{
+		"item": apiKey,
+		"key":  raw,
+	}
+
+	return handlers.SuccessResponse(c, true, "success to create api key, the key is only shown once", res, nil)
+}
+
+func UpdateApiKey(c fiber.Ctx) error {
+	idApiKey := c.Params("id")
+
+	if idApiKey == "" {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need id params"))
+	}
+
+	input := new(types.ApiKeyUpdateInput)
+
+	if err := handlers.ParseBody(c, input); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	if err := handlers.ValidateStruct(*input); err != nil {
+		return handlers.SuccessResponse(c, false, "error validation api key", err, nil)
+	}
+
+	before := new(models.ApiKey)
+	if err := models.FindApiKeyById(before, idApiKey); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	data := map[string]interface{}{
+		"scopes":      datatypes.NewJSONSlice(input.Scopes),
+		"allowed_ips": datatypes.NewJSONSlice(input.AllowedIps),
+		"rate_limit":  input.RateLimit,
+		"expires_at":  input.ExpiresAt,
+	}
+	if input.Name != "" {
+		data["name"] = input.Name
+	}
+
+	if err := models.UpdateApiKey(idApiKey, data).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	after := new(models.ApiKey)
+	if err := models.FindApiKeyById(after, idApiKey); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditApiKeyUpdate, "api_key", idApiKey, before, after)
+
+	return handlers.SuccessResponse(c, true, "success to update api key", nil, nil)
+}
+
+func RotateApiKey(c fiber.Ctx) error {
+	idApiKey := c.Params("id")
+
+	if idApiKey == "" {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need id params"))
+	}
+
+	before := new(models.ApiKey)
+	if err := models.FindApiKeyById(before, idApiKey); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	raw, prefix, hash := handlers.GenerateApiKey()
+
+	tx := models.RotateApiKey(idApiKey, prefix, hash)
+	if tx.Error != nil {
+		return handlers.InternalServerErrorResponse(c, tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return handlers.NotFoundErrorResponse(c, fmt.Errorf("api key is not found or already revoked"))
+	}
+
+	after := *before
+	after.Prefix = prefix
+	RecordAudit(c, AuditApiKeyRotate, "api_key", idApiKey, before, after)
+
+	res := fiber.Map{
+		"prefix": prefix,
+		"key":    raw,
+	}
+
+	return handlers.SuccessResponse(c, true, "success to rotate api key, the key is only shown once", res, nil)
+}
+
+func RevokeApiKey(c fiber.Ctx) error {
+	idApiKey := c.Params("id")
+
+	if idApiKey == "" {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need id params"))
+	}
+
+	before := new(models.ApiKey)
+	if err := models.FindApiKeyById(before, idApiKey); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	tx := models.RevokeApiKey(idApiKey)
+	if tx.Error != nil {
+		return handlers.InternalServerErrorResponse(c, tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return handlers.NotFoundErrorResponse(c, fmt.Errorf("api key is not found or already revoked"))
+	}
+
+	after := new(models.ApiKey)
+	if err := models.FindApiKeyById(after, idApiKey); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditApiKeyRevoke, "api_key", idApiKey, before, after)
+
+	return handlers.SuccessResponse(c, true, "success to revoke api key", nil, nil)
+}
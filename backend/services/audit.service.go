@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/requestid"
+)
+
+// Audit actions, published on NATS as "audit.<action>".
+const (
+	AuditConfigFileUpdate     = "config_file.update"
+	AuditConfigurationUpsert  = "configuration.upsert"
+	AuditConfigurationDelete  = "configuration.delete"
+	AuditFileUpload           = "file.upload"
+	AuditScriptExecute        = "script.execute"
+	AuditGatewayRestart       = "gateway.restart"
+	AuditCsrfUpdate           = "csrf.update"
+	AuditCachePurge           = "cache.purge"
+	AuditUserCreate           = "user.create"
+	AuditUserUpdate           = "user.update"
+	AuditUserDelete           = "user.delete"
+	AuditAuthRoleCreate       = "auth_role.create"
+	AuditAuthRoleUpdate       = "auth_role.update"
+	AuditAuthRoleDelete       = "auth_role.delete"
+	AuditUserAssignmentCreate = "user_assignment.create"
+	AuditUserAssignmentUpdate = "user_assignment.update"
+	AuditUserAssignmentDelete = "user_assignment.delete"
+	AuditAdminRoleGrant       = "admin_role.grant"
+	AuditAdminRoleRevoke      = "admin_role.revoke"
+	AuditNotificationRetry    = "notification.retry"
+	AuditNotificationDelete   = "notification.delete"
+	AuditApiKeyCreate         = "api_key.create"
+	AuditApiKeyUpdate         = "api_key.update"
+	AuditApiKeyRotate         = "api_key.rotate"
+	AuditApiKeyRevoke         = "api_key.revoke"
+	AuditClientCertCreate     = "client_cert.create"
+	AuditClientCertUpdate     = "client_cert.update"
+	AuditClientCertDelete     = "client_cert.delete"
+	AuditBasicAuthUpsert      = "basic_auth.upsert"
+	AuditBasicAuthDelete      = "basic_auth.delete"
+)
+
+const auditExportLimit = 100000
+
+// RecordAudit appends an audit event for the current request and publishes
+// it on NATS. A failure to record is logged and never fails the action.
+func RecordAudit(c fiber.Ctx, action string, targetType string, target string, before interface{}, after interface{}) {
+	beforeSnapshot := handlers.AuditSnapshot(before)
+	afterSnapshot := handlers.AuditSnapshot(after)
+
+	event := &models.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		Target:     target,
+		Before:     auditJSON(beforeSnapshot),
+		After:      auditJSON(afterSnapshot),
+		Diff:       auditJSON(handlers.AuditDiff(beforeSnapshot, afterSnapshot)),
+		Ip:         c.IP(),
+		RequestId:  requestid.FromContext(c),
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+		Timestamp:  time.Now(),
+	}
+
+	if user, ok := c.Locals("user").(*models.UserData); ok {
+		event.ActorId = user.IdAccount
+		event.ActorName = user.Username
+	}
+
+	if err := models.CreateAuditEvent(event).Error; err != nil {
+		log.Printf("error: audit %s on %s: %s", action, target, err)
+		return
+	}
+
+	PublishEvent("audit."+action, event)
+}
+
+func auditJSON(v interface{}) []byte {
+	if v == nil {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+func parseAuditFilter(c fiber.Ctx) (models.AuditEventFilter, error) {
+	layout := "2006-01-02T15:04:05.000Z07:00"
+
+	filter := models.AuditEventFilter{
+		ActorId:    c.Query("actor_id"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		Target:     c.Query("target"),
+		RequestId:  c.Query("request_id"),
+		Limit:      fiber.Query[int](c, "limit"),
+		Offset:     fiber.Query[int](c, "offset"),
+	}
+
+	if from := c.Query("from"); from != "" {
+		parsed, err := time.Parse(layout, from)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from param")
+		}
+		filter.From = parsed
+	}
+
+	if to := c.Query("to"); to != "" {
+		parsed, err := time.Parse(layout, to)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to param")
+		}
+		filter.To = parsed
+	}
+
+	return filter, nil
+}
+
+func GetAllAuditEvent(c fiber.Ctx) error {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 100
+	}
+
+	var count int64
+	if err := models.CountFindAuditEvent(&count, filter); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	d := &[]models.AuditEvent{}
+	if err := models.FindAuditEvent(d, filter).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	return handlers.SuccessResponse(c, true, "success to get audit events", d, &count)
+}
+
+// AuditEventRecord is the CSV row of event, the values written by clients
+// can not run as spreadsheet formulas.
+func AuditEventRecord(event *models.AuditEvent) []string {
+	return []string{
+		strconv.FormatUint(event.ID, 10),
+		event.Timestamp.Format(time.RFC3339Nano),
+		handlers.CsvCell(event.ActorId),
+		handlers.CsvCell(event.ActorName),
+		handlers.CsvCell(event.Action),
+		handlers.CsvCell(event.TargetType),
+		handlers.CsvCell(event.Target),
+		handlers.CsvCell(event.Ip),
+		handlers.CsvCell(event.RequestId),
+		handlers.CsvCell(event.UserAgent),
+		handlers.CsvCell(string(event.Before)),
+		handlers.CsvCell(string(event.After)),
+		handlers.CsvCell(string(event.Diff)),
+	}
+}
+
+// ExportAuditEvent streams the matching events as CSV (?format=csv) or
+// newline delimited JSON (?format=ndjson, the default).
+func ExportAuditEvent(c fiber.Ctx) error {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+	if filter.Limit <= 0 || filter.Limit > auditExportLimit {
+		filter.Limit = auditExportLimit
+	}
+	filter.Offset = 0
+
+	format := c.Query("format", "ndjson")
+	filename := "audit-events-" + time.Now().Format("20060102-150405")
+
+	switch format {
+	case "csv":
+		c.Set(fiber.HeaderContentType, "text/csv")
+		c.Attachment(filename + ".csv")
+		return c.SendStreamWriter(func(w *bufio.Writer) {
+			writer := csv.NewWriter(w)
+			writer.Write([]string{"id", "timestamp", "actor_id", "actor_name", "action", "target_type", "target", "ip", "request_id", "user_agent", "before", "after", "diff"})
+			err := models.EachAuditEvent(filter, func(event *models.AuditEvent) error {
+				return writer.Write(AuditEventRecord(event))
+			})
+			writer.Flush()
+			if err != nil {
+				log.Printf("error: export audit events: %s", err)
+			}
+		})
+	case "ndjson":
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+		c.Attachment(filename + ".ndjson")
+		return c.SendStreamWriter(func(w *bufio.Writer) {
+			encoder := json.NewEncoder(w)
+			err := models.EachAuditEvent(filter, func(event *models.AuditEvent) error {
+				return encoder.Encode(event)
+			})
+			w.Flush()
+			if err != nil {
+				log.Printf("error: export audit events: %s", err)
+			}
+		})
+	default:
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("format must be csv or ndjson"))
+	}
+}
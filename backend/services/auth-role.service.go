@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"go-gerbang/handlers"
 	"go-gerbang/models"
+	"strconv"
 
 	"github.com/gofiber/fiber/v3"
 )
@@ -41,6 +42,8 @@ func CreateAuthRole(c fiber.Ctx) error {
 		return handlers.InternalServerErrorResponse(c, err)
 	}
 
+	RecordAudit(c, AuditAuthRoleCreate, "auth_role", strconv.Itoa(u.IdAuthRole), nil, u)
+
 	return handlers.SuccessResponse(c, true, "success to create auth role", nil, nil)
 }
 
@@ -61,10 +64,17 @@ func UpdateAuthRole(c fiber.Ctx) error {
 		return handlers.SuccessResponse(c, false, "error validation auth role", err, nil)
 	}
 
+	before := &[]models.AuthRule{}
+	models.FindAuthRule(before, "id_auth_role = ?", authRoleId)
+
 	if err := models.UpdateAuthRule(authRoleId, u).Error; err != nil {
 		return handlers.InternalServerErrorResponse(c, err)
 	}
 
+	after := &[]models.AuthRule{}
+	models.FindAuthRule(after, "id_auth_role = ?", authRoleId)
+	RecordAudit(c, AuditAuthRoleUpdate, "auth_role", authRoleId, firstAuthRule(*before), firstAuthRule(*after))
+
 	return handlers.SuccessResponse(c, true, "success to update auth role", nil, nil)
 }
 
@@ -75,9 +85,21 @@ func DeleteAuthRule(c fiber.Ctx) error {
 		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need id params"))
 	}
 
+	before := &[]models.AuthRule{}
+	models.FindAuthRule(before, "id_auth_role = ?", authRoleId)
+
 	if err := models.DeleteAuthRule(authRoleId); err != nil {
 		return handlers.NotFoundErrorResponse(c, err.Error)
 	}
 
+	RecordAudit(c, AuditAuthRoleDelete, "auth_role", authRoleId, firstAuthRule(*before), nil)
+
 	return handlers.SuccessResponse(c, true, "success to delete auth role", nil, nil)
 }
+
+func firstAuthRule(rules []models.AuthRule) interface{} {
+	if len(rules) == 0 {
+		return nil
+	}
+	return rules[0]
+}
@@ -49,17 +49,27 @@ func Signup(c fiber.Ctx) error {
 		user.StatusAccount = 10
 	}
 
-	if err := models.CreateUser(user); err.Error != nil {
-		return handlers.ConflictErrorResponse(c, err.Error)
-	}
-
 	sendNotification := fiber.Query[bool](c, "notif")
 	providerNotification := c.Query("provider")
 	querySender := c.Query("sender")
 	sendPass := fiber.Query[bool](c, "sendPass")
 
+	// the email is queued by the outbox relay with user.created, sendPass
+	// sends a link to set the password rather than the password
+	var notification interface{}
 	if sendNotification && providerNotification != "" {
-		QueueUserInformation(providerNotification, querySender, user, sendPass)
+		setPasswordUrl := ""
+		if sendPass {
+			var err error
+			if setPasswordUrl, err = newSetPasswordUrl(c, user); err != nil {
+				return handlers.UnprocessableEntityErrorResponse(c, err)
+			}
+		}
+		notification = UserInformationEmail(providerNotification, querySender, c.Query("locale"), user, setPasswordUrl)
+	}
+
+	if err := models.CreateUserWithEvent(user, notification); err != nil {
+		return handlers.ConflictErrorResponse(c, err)
 	}
 
 	return handlers.SuccessResponse(c, true, "Success Create User", nil, nil)
@@ -122,7 +132,9 @@ func Login(c fiber.Ctx) error {
 		u.LoginIp = c.IP()
 		if block { // BLOCK Query
 			if user.LoginAttempts >= 3 {
-				models.BlockUser(user.IdAccount)
+				if err := models.BlockUserWithEvent(user); err != nil {
+					return handlers.InternalServerErrorResponse(c, err)
+				}
 				return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("you're account has block, you're already fill wrong password 3 time"))
 			} else {
 				models.UpdateUser(user.IdAccount, u)
@@ -251,7 +263,7 @@ func ChangePassword(c fiber.Ctx) error {
 
 	user.PasswordHash = handlers.GeneratePasswordHash(b.Password)
 	user.PasswordResetToken = nil
-	if err := models.UpdateUserPassword(user.IdAccount, user).Error; err != nil {
+	if err := models.UpdateUserPasswordWithEvent(user); err != nil {
 		return handlers.InternalServerErrorResponse(c, err)
 	}
 
@@ -278,13 +290,6 @@ func RequestResetPassword(c fiber.Ctx) error {
 		return handlers.BadRequestErrorResponse(c, err)
 	}
 
-	QuerySender := c.Query("sender")
-
-	Sender := "GOGERBANG"
-	if QuerySender != "" {
-		Sender = QuerySender
-	}
-
 	BaseUrl := c.Query("baseUrl")
 	if BaseUrl == "" {
 		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need base baseUrl params"))
@@ -293,32 +298,15 @@ func RequestResetPassword(c fiber.Ctx) error {
 	rawQuery := c.RequestCtx().URI().QueryString()
 	queryStr := string(rawQuery)
 
-	sendEmail := new(types.SendingEmailToBroker)
-	sendEmail.Sender = Sender
-	sendEmail.Subject = "Reset Password"
-	sendEmail.Title = "You are request for reset password"
-	sendEmail.BodyText = `Hi ` + user.FullName + `
-	
-	for reset password please click link below:
-		
-	` + BaseUrl + `/auth/forget-password?token=` + randomReset + `
-
-	this link only active in 24 hours`
-	sendEmail.Body = `Hi ` + user.FullName + `<br/> 
-		for reset password please click link below:
-		<div style="padding-top:30px;padding-bottom:28px;text-align:center">
-			<a href='` + BaseUrl + `/auth/forget-password?token=` + randomReset + `&` + queryStr + `' style="font-family:'Google Sans',Roboto,RobotoDraft,Helvetica,Arial,sans-serif;line-height:16px;color:#ffffff;font-weight:400;text-decoration:none;font-size:14px;display:inline-block;padding:10px 24px;background-color:#171717;border-radius:5px;min-width:90px">Reset Link</a>
-		</div>
-		this link only active in 24 hours`
-	sendEmail.Footer = "you are receiving this mail from " + Sender
-	sendEmail.Emails = []types.Email{
-		{
-			Name:      user.FullName,
-			EmailAddr: accountEmail,
-		},
-	}
-
-	PublishEvent("user.notification", sendEmail)
+	sendEmail := TemplateEmail(c.Query("provider"), mailSender(c.Query("sender")), EmailTemplateResetPassword, c.Query("locale"), map[string]string{
+		"fullName": user.FullName,
+		"resetUrl": BaseUrl + "/auth/forget-password?token=" + randomReset + "&" + queryStr,
+	}, types.Email{
+		Name:      user.FullName,
+		EmailAddr: accountEmail,
+	})
+
+	QueueNotification(sendEmail)
 
 	return handlers.SuccessResponse(c, true, "Silahkan Cek Email", nil, nil)
 }
@@ -368,7 +356,7 @@ func ResetPassword(c fiber.Ctx) error {
 
 	user.PasswordHash = handlers.GeneratePasswordHash(input.Password)
 	user.PasswordResetToken = nil
-	if err := models.UpdateUserPassword(user.IdAccount, user).Error; err != nil {
+	if err := models.UpdateUserPasswordWithEvent(user); err != nil {
 		return handlers.NotFoundErrorResponse(c, err)
 	}
 
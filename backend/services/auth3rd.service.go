@@ -53,50 +53,24 @@ func LoginWithGoogle(c fiber.Ctx) error {
 			user.StatusAccount = 10
 		}
 
-		if err := models.CreateUser(user); err.Error != nil {
-			return handlers.ConflictErrorResponse(c, err.Error)
-		}
-
 		sendNotification := fiber.Query[bool](c, "notif")
-		QuerySender := c.Query("sender")
+
+		// the email is queued by the outbox relay with user.created
+		var notification interface{}
 
 		if sendNotification {
-			Sender := "GOGERBANG"
-			if QuerySender != "" {
-				Sender = QuerySender
-			}
-
-			sendEmail := new(types.SendingEmailToBroker)
-			sendEmail.Sender = Sender
-			sendEmail.Subject = "Create Account Success"
-			sendEmail.Title = "Akun Anda Berhasil Di Buat"
-			sendEmail.BodyText = `
-				Hi, ` + user.FullName + `, berikut inform
-				for reset password pleasasi akun anda:e click link below
-
-				username: ` + user.Username + `
-				email: ` + user.Email + `
-
-				Tetap jaga rahasia akun anda, mohon untuk jangan diberikan kepada siapapun termasuk Admin.
-			`
-			sendEmail.Body = `
-				<div class="font-family: Roboto-Regular, Helvetica, Arial, sans-serif; font-size: 14px; color: rgba(0, 0, 0, 0.87); padding-top: 20px; text-align: center;">Hi, ` + user.FullName + `, berikut informasi akun anda:</div>
-				<br/>
-				<div>username: <strong>` + user.FullName + `</strong></div>
-				<div>email: <strong>` + user.Email + `</strong></div>` + `
-				<br/>
-				<div class="padding-top: 20px; font-size: 12px; line-height: 16px; color: rgb(95, 99, 104); letter-spacing: 0.3px; text-align: center;">Tetap jaga rahasia akun anda, mohon untuk jangan diberikan kepada siapapun termasuk Admin.</div>
-			`
-			sendEmail.Footer = "ini merupakan email otomatis dari " + Sender
-			sendEmail.Emails = []types.Email{
-				{
-					Name:      user.FullName,
-					EmailAddr: user.Email,
-				},
-			}
-
-			// go PublishServiceEmail(sendEmail)
-			PublishEvent("user.notification", sendEmail)
+			notification = TemplateEmail(c.Query("provider"), mailSender(c.Query("sender")), EmailTemplateWelcome, c.Query("locale"), map[string]string{
+				"fullName": user.FullName,
+				"username": user.Username,
+				"email":    user.Email,
+			}, types.Email{
+				Name:      user.FullName,
+				EmailAddr: user.Email,
+			})
+		}
+
+		if err := models.CreateUserWithEvent(user, notification); err != nil {
+			return handlers.ConflictErrorResponse(c, err)
 		}
 
 		// return handlers.NotFoundErrorResponse(c, err)
@@ -1,43 +1,273 @@
 package services
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
 	"log"
+	"slices"
+	"strings"
+	"time"
 
 	"go-gerbang/broker"
 	"go-gerbang/handlers"
+	"go-gerbang/middleware"
+	"go-gerbang/models"
+	"go-gerbang/proxyroute"
 	"go-gerbang/types"
 
+	"github.com/fasthttp/websocket"
 	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/requestid"
 	"github.com/nats-io/nats.go"
 )
 
+const (
+	subscribeMaxSubjects = 10
+	subscribeBuffer      = 256
+	subscribeKeepAlive   = 25 * time.Second
+	subscribeWriteWait   = 10 * time.Second
+)
+
+var brokerUpgrader = websocket.FastHTTPUpgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// PublishService publishes the JSON data of the body to a subject the roles
+// of the caller may publish to.
 func PublishService(c fiber.Ctx) error {
-	input := new(types.SendingEmailToBroker)
+	input := new(types.PublishInput)
 	if err := handlers.ParseBody(c, input); err != nil {
 		return handlers.BadRequestErrorResponse(c, err)
 	}
 
-	messageBytes, err := json.Marshal(input)
+	if err := handlers.ValidateStruct(*input); err != nil {
+		return handlers.SuccessResponse(c, false, "error validation publish", err, nil)
+	}
+
+	if !middleware.ValidSubject(input.Subject, false) {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("subject must be a NATS subject without wildcards"))
+	}
+
+	user, ok := c.Locals("user").(*models.UserData)
+	if !ok {
+		return handlers.UnauthorizedErrorResponse(c, fmt.Errorf("user not found"))
+	}
+
+	allowed, err := middleware.HasSubjectPermission(user, input.Subject, middleware.SubjectActPublish)
 	if err != nil {
-		return handlers.InternalServerErrorResponse(c, err)
+		log.Printf("error: enforcer: %s", err)
+		return handlers.InternalServerErrorResponse(c, fmt.Errorf("failed to check your subject access"))
+	}
+	if !allowed {
+		return handlers.ForbiddenErrorResponse(c, fmt.Errorf("your role can not publish to %s", input.Subject))
 	}
 
-	subject := "send_mail"
-	if err := broker.NatsClient.Publish(subject, []byte(messageBytes)); err != nil {
+	msg := nats.NewMsg(input.Subject)
+	msg.Header.Set(proxyroute.NatsHeaderUserId, user.IdAccount)
+	if id := requestid.FromContext(c); id != "" {
+		msg.Header.Set(proxyroute.NatsHeaderRequestId, id)
+	}
+	msg.Data = input.Data
+
+	if err := broker.NatsClient.PublishMsg(msg); err != nil {
 		return handlers.InternalServerErrorResponse(c, err)
 	}
 
-	return handlers.SuccessResponse(c, true, "on publish", nil, nil)
+	return handlers.SuccessResponse(c, true, "success to publish", fiber.Map{"subject": input.Subject}, nil)
 }
 
+// SubscribeService streams the messages of the subject query values as
+// server-sent events, every subject may use wildcards and must be allowed
+// for subscribe to the roles of the caller.
 func SubscribeService(c fiber.Ctx) error {
-	subject := "send_mail"
-	// broker.NatsClient.Subscribe(subject, func(msg *nats.Msg) {
-	// 	fmt.Printf("Received message on %s: %s\n", subject, string(msg.Data))
-	// })
+	subjects, respond, err := subscribeSubjects(c)
+	if err != nil {
+		return respond(c, err)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	return c.SendStreamWriter(func(w *bufio.Writer) {
+		events := make(chan *nats.Msg, subscribeBuffer)
+		subs, err := chanSubscribe(subjects, events)
+		defer unsubscribeAll(subs)
+		if err != nil {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+			w.Flush()
+			return
+		}
+
+		fmt.Fprintf(w, "retry: 3000\n: subscribed to %s\n\n", strings.Join(subjects, " "))
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(subscribeKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case msg := <-events:
+				fmt.Fprintf(w, "event: message\ndata: %s\n\n", brokerEvent(msg))
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": ping\n\n")
+			}
+			// the client is gone once a flush fails
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// SubscribeWebSocket is SubscribeService over a WebSocket, every message is
+// sent as one text frame. Messages from the client are ignored.
+func SubscribeWebSocket(c fiber.Ctx) error {
+	if !websocket.FastHTTPIsWebSocketUpgrade(c.RequestCtx()) {
+		return handlers.BadRequestErrorResponse(c, fmt.Errorf("need a websocket upgrade request"))
+	}
+
+	subjects, respond, err := subscribeSubjects(c)
+	if err != nil {
+		return respond(c, err)
+	}
+
+	return brokerUpgrader.Upgrade(c.RequestCtx(), func(conn *websocket.Conn) {
+		defer conn.Close()
+
+		events := make(chan *nats.Msg, subscribeBuffer)
+		subs, err := chanSubscribe(subjects, events)
+		defer unsubscribeAll(subs)
+		if err != nil {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+			return
+		}
+
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		keepAlive := time.NewTicker(subscribeKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			conn.SetWriteDeadline(time.Now().Add(subscribeWriteWait))
+			select {
+			case msg := <-events:
+				err = conn.WriteMessage(websocket.TextMessage, brokerEvent(msg))
+			case <-keepAlive.C:
+				err = conn.WriteMessage(websocket.PingMessage, nil)
+			case <-closed:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
+
+// subscribeSubjects reads the subject query values, repeated or comma
+// separated, and checks them against the roles of the caller. respond sends
+// err when it is not nil.
+func subscribeSubjects(c fiber.Ctx) (subjects []string, respond func(fiber.Ctx, error) error, err error) {
+	user, ok := c.Locals("user").(*models.UserData)
+	if !ok {
+		return nil, handlers.UnauthorizedErrorResponse, fmt.Errorf("user not found")
+	}
+
+	for _, value := range c.RequestCtx().QueryArgs().PeekMulti("subject") {
+		for _, subject := range strings.Split(string(value), ",") {
+			if subject = strings.TrimSpace(subject); subject != "" && !slices.Contains(subjects, subject) {
+				subjects = append(subjects, subject)
+			}
+		}
+	}
+
+	if len(subjects) == 0 {
+		return nil, handlers.UnprocessableEntityErrorResponse, fmt.Errorf("need subject query")
+	}
+	if len(subjects) > subscribeMaxSubjects {
+		return nil, handlers.UnprocessableEntityErrorResponse, fmt.Errorf("can not subscribe to more than %d subjects", subscribeMaxSubjects)
+	}
+
+	for _, subject := range subjects {
+		if !middleware.ValidSubject(subject, true) {
+			return nil, handlers.UnprocessableEntityErrorResponse, fmt.Errorf("%s is not a NATS subject", subject)
+		}
+
+		allowed, err := middleware.HasSubjectPermission(user, subject, middleware.SubjectActSubscribe)
+		if err != nil {
+			log.Printf("error: enforcer: %s", err)
+			return nil, handlers.InternalServerErrorResponse, fmt.Errorf("failed to check your subject access")
+		}
+		if !allowed {
+			return nil, handlers.ForbiddenErrorResponse, fmt.Errorf("your role can not subscribe to %s", subject)
+		}
+	}
+
+	// a subject covered by another one would deliver its messages twice
+	return slices.DeleteFunc(slices.Clone(subjects), func(subject string) bool {
+		for _, other := range subjects {
+			if other != subject && middleware.SubjectCovers(other, subject) {
+				return true
+			}
+		}
+		return false
+	}), nil, nil
+}
+
+func chanSubscribe(subjects []string, events chan *nats.Msg) ([]*nats.Subscription, error) {
+	subs := []*nats.Subscription{}
+	if broker.NatsClient == nil {
+		return subs, fmt.Errorf("nats client is not connected")
+	}
+
+	for _, subject := range subjects {
+		sub, err := broker.NatsClient.ChanSubscribe(subject, events)
+		if err != nil {
+			return subs, err
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, nil
+}
 
-	return handlers.SuccessResponse(c, true, "on subscribe", subject, nil)
+func unsubscribeAll(subs []*nats.Subscription) {
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+}
+
+// brokerEvent encodes msg for the subscribers, data that is not JSON is sent
+// as a JSON string.
+func brokerEvent(msg *nats.Msg) []byte {
+	data := json.RawMessage(msg.Data)
+	if !json.Valid(msg.Data) {
+		data, _ = json.Marshal(string(msg.Data))
+	}
+
+	event, _ := json.Marshal(types.BrokerEvent{
+		Subject:     msg.Subject,
+		Data:        data,
+		PublishedBy: msg.Header.Get(proxyroute.NatsHeaderUserId),
+		ReceivedAt:  time.Now().Format(time.RFC3339Nano),
+	})
+	return event
 }
 
 func PublishEvent(subject string, rawData interface{}) {
@@ -53,14 +283,11 @@ func PublishEvent(subject string, rawData interface{}) {
 }
 
 func SubscribeEvent() {
-	handlers := map[string]nats.MsgHandler{
-		"user.notification": handleMsg,
+	if err := StartNotificationConsumer(); err != nil {
+		log.Println("Error on consume notification:", err)
 	}
-
-	for subject, handler := range handlers {
-		if _, err := broker.NatsClient.Subscribe(subject, handler); err != nil {
-			log.Println("Error on subcribe NATS:", err)
-		}
+	if err := StartWebhookConsumers(); err != nil {
+		log.Println("Error on consume webhook:", err)
 	}
 
 	// log.Println("Listening for subcribe events...")
@@ -90,70 +317,80 @@ func GetImageEmail(sender string, provider string) string {
 	return ""
 }
 
-func handleMsg(msg *nats.Msg) {
+// mailProvider is the provider configured for sender, Resend first.
+func mailProvider(sender string) string {
+	for _, key := range handlers.GetEmailResendConfig() {
+		if key.Sender == sender {
+			return "Resend"
+		}
+	}
+	for _, key := range handlers.GetEmailSMTPConfig() {
+		if key.Sender == sender {
+			return "SMTP"
+		}
+	}
+	return ""
+}
+
+// sendNotification sends the email of a notification message, an invalid
+// message fails with errNotificationInvalid and is never retried.
+func sendNotification(data []byte) error {
 	var email types.SendingEmailToBroker
 
-	if err := json.Unmarshal(msg.Data, &email); err != nil {
-		log.Println("Failed to parse message data:", err)
-		return
+	if err := json.Unmarshal(data, &email); err != nil {
+		return fmt.Errorf("%w: %s", errNotificationInvalid, err)
 	}
 
-	ImageEmail := GetImageEmail(email.Sender, email.Provider)
-	getImage := ImageEmail
+	provider := email.Provider
+	if provider == "" {
+		provider = mailProvider(email.Sender)
+	}
+	image := GetImageEmail(email.Sender, provider)
 
 	dataSend := new(types.ListEmail)
 	dataSend.Sender = email.Sender
-	dataSend.Subject = email.Subject
-	dataSend.BodyTemplateText = email.Title + email.BodyText + email.Footer
-	dataSend.BodyTemplateHtml = `<div style="margin: 0px; padding: 0px;" bgcolor="#FFFFFF">
-		<table width="100%" height="100%" style="min-width: 348px;" border="0" cellspacing="0" cellpadding="0" lang="en">
-			<tbody>
-				<tr height="32" style="height: 32px;">
-					<td></td>
-				</tr>
-				<tr align="center">
-					<td>
-						<table border="0" cellspacing="0" cellpadding="0" style="padding-bottom: 20px; max-width: 516px; min-width: 220px;">
-							<tbody>
-								<tr>
-									<td width="8" style="width: 8px;"></td>
-									<td>
-										<div style="border-style: solid; border-width: thin; border-color: rgb(218, 220, 224); border-radius: 8px; padding: 40px 20px;" align="center">
-											` + getImage + `
-											<div style="font-family: Google Sans, Roboto, RobotoDraft, Helvetica, Arial, sans-serif; border-bottom: thin solid rgb(218, 220, 224); color: rgba(0, 0, 0, 0.87); line-height: 32px; padding-bottom: 24px; text-align: center; word-break: break-word;">
-												<div style="font-size: 24px;">
-													` + email.Title + `
-												</div>
-											</div>
-											<div style="font-family: Roboto-Regular, Helvetica, Arial, sans-serif; font-size: 14px; color: rgba(0, 0, 0, 0.87); line-height: 20px; padding-top: 20px; text-align: center;">
-												` + email.Body + `
-											</div>
-										</div>
-										<div style="font-family: Roboto-Regular, Helvetica, Arial, sans-serif; color: rgba(0, 0, 0, 0.54); font-size: 11px; line-height: 18px; padding-top: 12px; text-align: center;">
-											` + email.Footer + `
-										</div>
-									</td>
-									<td width="8" style="width: 8px;"></td>
-								</tr>
-							</tbody>
-						</table>
-					</td>
-				</tr>
-				<tr height="32" style="height: 32px;">
-					<td></td>
-				</tr>
-			</tbody>
-		</table>
-	</div>`
 	dataSend.Emails = email.Emails
 
-	if email.Provider == "Resend" {
+	if email.Template != "" {
+		content, locale, err := findEmailTemplate(email.Sender, email.Template, email.Locale)
+		if errors.Is(err, errEmailTemplateNotFound) {
+			return fmt.Errorf("%w: %s", errNotificationInvalid, err)
+		}
+		if err != nil {
+			return err
+		}
+
+		rendered, err := handlers.RenderEmailTemplate(content, locale, image, emailVariables(email.Sender, email.Variables))
+		if err != nil {
+			return fmt.Errorf("%w: template %s: %s", errNotificationInvalid, email.Template, err)
+		}
+
+		dataSend.Subject = rendered.Subject
+		dataSend.BodyTemplateText = rendered.Text
+		dataSend.BodyTemplateHtml = rendered.Html
+	} else {
+		// raw html, sent by the mail test and queued before the templates
+		html, err := handlers.RenderEmailLayout("en", image, email.Title, template.HTML(email.Body), email.Footer)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errNotificationInvalid, err)
+		}
+
+		dataSend.Subject = email.Subject
+		dataSend.BodyTemplateText = email.Title + email.BodyText + email.Footer
+		dataSend.BodyTemplateHtml = html
+	}
+
+	if provider == "Resend" {
 		if !handlers.SendResendMail(dataSend) {
-			log.Println("failed to send email")
+			return fmt.Errorf("failed to send email using %s", provider)
 		}
-	} else if email.Provider == "SMTP" {
+	} else if provider == "SMTP" {
 		if !handlers.SendSMTPMail(dataSend) {
-			log.Println("failed to send email")
+			return fmt.Errorf("failed to send email using %s", provider)
 		}
+	} else {
+		return fmt.Errorf("%w: unknown provider %q for sender %q", errNotificationInvalid, provider, email.Sender)
 	}
+
+	return nil
 }
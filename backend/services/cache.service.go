@@ -0,0 +1,60 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"go-gerbang/handlers"
+	"go-gerbang/proxyroute"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+type CachePurgeInput struct {
+	Prefix string   `json:"prefix"`
+	Tags   []string `json:"tags"`
+}
+
+// PurgeCache deletes the cached responses under a path prefix and/or with
+// one of the tags. The cache lives in Redis, so every instance is purged.
+func PurgeCache(c fiber.Ctx) error {
+	u := new(CachePurgeInput)
+
+	if err := handlers.ParseBody(c, u); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	if u.Prefix == "" && len(u.Tags) == 0 {
+		return handlers.BadRequestErrorResponse(c, fmt.Errorf("prefix or tags is required"))
+	}
+	if u.Prefix != "" && !strings.HasPrefix(u.Prefix, "/") {
+		return handlers.BadRequestErrorResponse(c, fmt.Errorf("prefix must start with /"))
+	}
+
+	var purged int64
+	if u.Prefix != "" {
+		deleted, err := proxyroute.PurgeCachePrefix(u.Prefix)
+		if err != nil {
+			return handlers.InternalServerErrorResponse(c, err)
+		}
+		purged += deleted
+	}
+
+	for _, tag := range u.Tags {
+		deleted, err := proxyroute.PurgeCacheTag(tag)
+		if err != nil {
+			return handlers.InternalServerErrorResponse(c, err)
+		}
+		purged += deleted
+	}
+
+	target := u.Prefix
+	if target == "" {
+		target = strings.Join(u.Tags, ",")
+	}
+
+	result := fiber.Map{"prefix": u.Prefix, "tags": u.Tags, "purged": purged}
+	RecordAudit(c, AuditCachePurge, "cache", target, nil, result)
+
+	return handlers.SuccessResponse(c, true, "success to purge cache", result, &purged)
+}
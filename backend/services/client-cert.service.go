@@ -0,0 +1,107 @@
+package services
+
+import (
+	"fmt"
+
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+func GetAllClientCertBinding(c fiber.Ctx) error {
+	d := &[]models.ClientCertBinding{}
+
+	if err := models.FindClientCertBinding(d).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	count := int64(len(*d))
+
+	return handlers.SuccessResponse(c, true, "success to get all client certificate binding", d, &count)
+}
+
+func CreateClientCertBinding(c fiber.Ctx) error {
+	u := new(models.ClientCertBinding)
+
+	if err := handlers.ParseBody(c, u); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	if err := handlers.ValidateStruct(*u); err != nil {
+		return handlers.SuccessResponse(c, false, "error validation client certificate binding", err, nil)
+	}
+
+	if (u.AccountId == "") == (u.IdApiKey == "") {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("fill exactly one of accountId or idApiKey"))
+	}
+
+	if err := models.CreateClientCertBinding(u).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditClientCertCreate, "client_cert", u.IdClientCertBinding.String(), nil, u)
+
+	return handlers.SuccessResponse(c, true, "success to create client certificate binding", u, nil)
+}
+
+func UpdateClientCertBinding(c fiber.Ctx) error {
+	idBinding := c.Params("id")
+
+	if idBinding == "" {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need id params"))
+	}
+
+	u := new(models.ClientCertBinding)
+
+	if err := handlers.ParseBody(c, u); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	if err := handlers.ValidateStruct(*u); err != nil {
+		return handlers.SuccessResponse(c, false, "error validation client certificate binding", err, nil)
+	}
+
+	before := new(models.ClientCertBinding)
+	if err := models.FindClientCertBindingById(before, idBinding); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	if err := models.UpdateClientCertBinding(idBinding, u).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	after := new(models.ClientCertBinding)
+	if err := models.FindClientCertBindingById(after, idBinding); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditClientCertUpdate, "client_cert", idBinding, before, after)
+
+	return handlers.SuccessResponse(c, true, "success to update client certificate binding", nil, nil)
+}
+
+func DeleteClientCertBinding(c fiber.Ctx) error {
+	idBinding := c.Params("id")
+
+	if idBinding == "" {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need id params"))
+	}
+
+	before := new(models.ClientCertBinding)
+	if err := models.FindClientCertBindingById(before, idBinding); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	tx := models.DeleteClientCertBinding(idBinding)
+	if tx.Error != nil {
+		return handlers.InternalServerErrorResponse(c, tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return handlers.NotFoundErrorResponse(c, fmt.Errorf("client certificate binding is not found"))
+	}
+
+	RecordAudit(c, AuditClientCertDelete, "client_cert", idBinding, before, nil)
+
+	return handlers.SuccessResponse(c, true, "success to delete client certificate binding", nil, nil)
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"go-gerbang/cluster"
+	"go-gerbang/config"
+	"go-gerbang/database"
+	"go-gerbang/handlers"
+	"go-gerbang/middleware"
+	"go-gerbang/models"
+	"go-gerbang/proxyroute"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const healthCheckKey = "gateway:cluster:health"
+
+type ConfigReloadEvent struct {
+	Version int `json:"version"`
+}
+
+type CsrfActivatedInput struct {
+	Activated bool `json:"activated"`
+}
+
+// StartCluster registers the handlers of the cluster events and the jobs
+// that only the leader runs.
+func StartCluster() {
+	cluster.On(cluster.EventConfigReload, func(event cluster.Event) {
+		var payload ConfigReloadEvent
+		if err := event.Decode(&payload); err != nil {
+			log.Printf("error: %s: %s", cluster.EventConfigReload, err)
+			return
+		}
+		if err := writeConfigVersion(payload.Version); err != nil {
+			log.Printf("error: apply config version %d from %s: %s", payload.Version, event.Origin, err)
+		}
+	})
+
+	cluster.On(cluster.EventMailInvalidate, func(event cluster.Event) {
+		handlers.InvalidateMailConfig()
+	})
+
+	cluster.On(cluster.EventPolicyReload, func(event cluster.Event) {
+		if err := middleware.ReloadPolicy(); err != nil {
+			log.Printf("error: %s: %s", cluster.EventPolicyReload, err)
+		}
+	})
+
+	cluster.On(cluster.EventCsrfActivated, func(event cluster.Event) {
+		var payload CsrfActivatedInput
+		if err := event.Decode(&payload); err != nil {
+			log.Printf("error: %s: %s", cluster.EventCsrfActivated, err)
+			return
+		}
+		middleware.CsrfActivated.Store(payload.Activated)
+	})
+
+	if config.ServicesFromDatabase() {
+		proxyroute.SubscribeServiceReload()
+	}
+
+	cluster.Every("log-retention", time.Hour, runLogRetention)
+	cluster.Every("health-check", time.Duration(config.HealthCheckInterval)*time.Second, runHealthCheck)
+	cluster.Every("outbox-relay", time.Duration(config.OutboxRelayInterval)*time.Second, runOutboxRelay)
+
+	cluster.Start()
+}
+
+// writeConfigVersion writes a version published by another instance to the
+// local config file, the file watcher then reloads the routes.
+func writeConfigVersion(version int) error {
+	if config.ServicesFromDatabase() {
+		return nil
+	}
+
+	cfg, err := loadConfigVersion(version)
+	if err != nil {
+		return err
+	}
+
+	return handlers.SaveConfig(config.BasePath+config.ConfigPath, cfg)
+}
+
+func broadcastConfigReload(version int) {
+	if err := cluster.Publish(cluster.EventConfigReload, ConfigReloadEvent{Version: version}); err != nil {
+		log.Printf("error: publish %s: %s", cluster.EventConfigReload, err)
+	}
+}
+
+func broadcastMailInvalidate() {
+	handlers.InvalidateMailConfig()
+	if err := cluster.Publish(cluster.EventMailInvalidate, nil); err != nil {
+		log.Printf("error: publish %s: %s", cluster.EventMailInvalidate, err)
+	}
+}
+
+// broadcastPolicyReload makes the other instances load the casbin policies
+// changed by this one.
+func broadcastPolicyReload() {
+	if err := cluster.Publish(cluster.EventPolicyReload, nil); err != nil {
+		log.Printf("error: publish %s: %s", cluster.EventPolicyReload, err)
+	}
+}
+
+func runLogRetention() error {
+	if config.LogRetentionDays <= 0 {
+		return nil
+	}
+
+	before := time.Now().AddDate(0, 0, -config.LogRetentionDays)
+	result := models.DeleteLoggerBefore(before)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected > 0 {
+		log.Printf("cluster: log retention removed %d loggers before %s", result.RowsAffected, before.Format(time.RFC3339))
+	}
+
+	result = models.DeleteWebhookDeliveryBefore(before)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected > 0 {
+		log.Printf("cluster: log retention removed %d webhook deliveries before %s", result.RowsAffected, before.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// runHealthCheck probes every upstream and stores the results in Redis, so
+// every instance serves the same view on /cluster/health.
+func runHealthCheck() error {
+	handlers.MapMicroServiceMutex.RLock()
+	services := []types.Service{}
+	if handlers.MapMicroService != nil {
+		services = append(services, handlers.MapMicroService.Services...)
+	}
+	handlers.MapMicroServiceMutex.RUnlock()
+
+	results := probeServices(services)
+
+	values := make(map[string]interface{}, len(results))
+	for _, result := range results {
+		data, err := json.Marshal(result)
+		if err != nil {
+			continue
+		}
+		values[result.Path] = data
+	}
+
+	pipe := database.RedisDb.TxPipeline()
+	pipe.Del(database.RedisCtx, healthCheckKey)
+	if len(values) > 0 {
+		pipe.HSet(database.RedisCtx, healthCheckKey, values)
+	}
+	_, err := pipe.Exec(database.RedisCtx)
+	return err
+}
+
+func GetClusterStatus(c fiber.Ctx) error {
+	nodes, err := cluster.Nodes()
+	if err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	result := fiber.Map{
+		"instance_id":    cluster.InstanceId,
+		"leader":         cluster.Leader(),
+		"nodes":          nodes,
+		"config_version": handlers.ActiveConfigVersion.Load(),
+		"csrf_activated": middleware.CsrfActivated.Load(),
+	}
+
+	count := int64(len(nodes))
+	return handlers.SuccessResponse(c, true, "success to get cluster status", result, &count)
+}
+
+func GetClusterHealth(c fiber.Ctx) error {
+	entries, err := database.RedisDb.HGetAll(database.RedisCtx, healthCheckKey).Result()
+	if err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	results := []proxyroute.ProbeResult{}
+	for _, raw := range entries {
+		var result proxyroute.ProbeResult
+		if err := json.Unmarshal([]byte(raw), &result); err == nil {
+			results = append(results, result)
+		}
+	}
+
+	count := int64(len(results))
+	return handlers.SuccessResponse(c, true, "success to get health check", results, &count)
+}
+
+func SetCsrfActivated(c fiber.Ctx) error {
+	u := new(CsrfActivatedInput)
+
+	if err := handlers.ParseBody(c, u); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	before := CsrfActivatedInput{Activated: middleware.CsrfActivated.Load()}
+	middleware.CsrfActivated.Store(u.Activated)
+
+	if err := cluster.Publish(cluster.EventCsrfActivated, u); err != nil {
+		return handlers.InternalServerErrorResponse(c, fmt.Errorf("csrf is changed on this instance only: %w", err))
+	}
+
+	RecordAudit(c, AuditCsrfUpdate, "gateway", "csrf_activated", before, u)
+
+	return handlers.SuccessResponse(c, true, "success to update csrf", u, nil)
+}
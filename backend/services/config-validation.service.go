@@ -0,0 +1,98 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go-gerbang/handlers"
+	"go-gerbang/proxyroute"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const configProbeTimeout = 5 * time.Second
+
+type ConfigValidationReport struct {
+	Valid    bool                     `json:"valid"`
+	Errors   []handlers.ConfigIssue   `json:"errors"`
+	Warnings []handlers.ConfigIssue   `json:"warnings"`
+	Diff     *handlers.ConfigDiff     `json:"diff,omitempty"`
+	Probes   []proxyroute.ProbeResult `json:"probes,omitempty"`
+}
+
+func newConfigValidationReport(issues []handlers.ConfigIssue) ConfigValidationReport {
+	report := ConfigValidationReport{
+		Errors:   []handlers.ConfigIssue{},
+		Warnings: []handlers.ConfigIssue{},
+	}
+
+	for _, issue := range issues {
+		if issue.Severity == handlers.ConfigIssueError {
+			report.Errors = append(report.Errors, issue)
+		} else {
+			report.Warnings = append(report.Warnings, issue)
+		}
+	}
+	report.Valid = len(report.Errors) == 0
+
+	return report
+}
+
+func configValidationResponse(c fiber.Ctx, issues []handlers.ConfigIssue, err error) error {
+	var validationErr *handlers.ConfigValidationError
+	if !errors.As(err, &validationErr) {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	return handlers.SuccessResponse(c, false, "error validation config", newConfigValidationReport(issues), nil)
+}
+
+// DryRunConfig validates a proposed config without saving it and shows
+// which routes would be added, removed or changed. With ?probe=true every
+// upstream of the proposed config is called once.
+func DryRunConfig(c fiber.Ctx) error {
+	proposed, issues, err := handlers.ParseConfig(c.Body())
+	report := newConfigValidationReport(issues)
+	if proposed == nil {
+		return configValidationResponse(c, issues, err)
+	}
+
+	handlers.MapMicroServiceMutex.RLock()
+	current := &types.ConfigServices{}
+	if handlers.MapMicroService != nil {
+		current.Services = append(current.Services, handlers.MapMicroService.Services...)
+	}
+	handlers.MapMicroServiceMutex.RUnlock()
+
+	diff := handlers.DiffConfigServices(current, proposed)
+	report.Diff = &diff
+
+	if fiber.Query[bool](c, "probe") {
+		report.Probes = probeServices(proposed.Services)
+	}
+
+	message := "config is valid"
+	if !report.Valid {
+		message = "config is invalid"
+	}
+
+	return handlers.SuccessResponse(c, report.Valid, message, report, nil)
+}
+
+func probeServices(services []types.Service) []proxyroute.ProbeResult {
+	results := make([]proxyroute.ProbeResult, len(services))
+
+	var wg sync.WaitGroup
+	for i, service := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = proxyroute.ProbeService(service, configProbeTimeout)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
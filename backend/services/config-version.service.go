@@ -0,0 +1,151 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"go-gerbang/config"
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+	"go-gerbang/proxyroute"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// HeaderConfigVersion carries the active config version, /info sends it in
+// its body as well.
+const HeaderConfigVersion = "X-Config-Version"
+
+type ConfigRollbackInput struct {
+	Comment string `json:"comment"`
+}
+
+func newConfigVersion(c fiber.Ctx, source string, comment string) *models.ConfigVersion {
+	version := &models.ConfigVersion{
+		Source:  source,
+		Comment: comment,
+	}
+
+	if user, ok := c.Locals("user").(*models.UserData); ok {
+		version.AuthorId = user.IdAccount
+		version.Author = user.Username
+	}
+
+	return version
+}
+
+func GetAllConfigVersion(c fiber.Ctx) error {
+	limit := fiber.Query[int](c, "limit")
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	var count int64
+	if err := models.CountFindConfigVersion(&count); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	d := &[]models.ConfigVersion{}
+	if err := models.FindConfigVersion(d, limit, fiber.Query[int](c, "offset")).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	c.Set(HeaderConfigVersion, strconv.FormatInt(handlers.ActiveConfigVersion.Load(), 10))
+	return handlers.SuccessResponse(c, true, "success to get config versions", d, &count)
+}
+
+func GetConfigVersion(c fiber.Ctx) error {
+	version := new(models.ConfigVersion)
+	if err := models.FindConfigVersionByVersion(version, c.Params("version")); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	return handlers.SuccessResponse(c, true, "success to get config version", version, nil)
+}
+
+// DiffConfigVersion compares ?from with ?to, to defaults to the active
+// version.
+func DiffConfigVersion(c fiber.Ctx) error {
+	from := fiber.Query[int](c, "from")
+	to := fiber.Query[int](c, "to", int(handlers.ActiveConfigVersion.Load()))
+	if from == 0 || to == 0 {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need from and to params"))
+	}
+
+	fromConfig, err := loadConfigVersion(from)
+	if err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	toConfig, err := loadConfigVersion(to)
+	if err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	result := fiber.Map{
+		"from": from,
+		"to":   to,
+		"diff": handlers.DiffConfigServices(fromConfig, toConfig),
+	}
+
+	return handlers.SuccessResponse(c, true, "success to diff config versions", result, nil)
+}
+
+// RollbackConfigVersion writes an old version back to the config file as a
+// new version, the history itself is never rewritten.
+func RollbackConfigVersion(c fiber.Ctx) error {
+	target, err := strconv.Atoi(c.Params("version"))
+	if err != nil {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need version params"))
+	}
+
+	input := new(ConfigRollbackInput)
+	if len(c.Body()) > 0 {
+		if err := handlers.ParseBody(c, input); err != nil {
+			return handlers.BadRequestErrorResponse(c, err)
+		}
+	}
+
+	rollbackConfig, err := loadConfigVersion(target)
+	if err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	if issues := handlers.ValidateConfig(rollbackConfig); len(handlers.ConfigIssueErrors(issues)) > 0 {
+		return configValidationResponse(c, issues, &handlers.ConfigValidationError{Issues: issues})
+	}
+
+	before, _ := proxyroute.LoadServices()
+
+	comment := input.Comment
+	if comment == "" {
+		comment = fmt.Sprintf("rollback to version %d", target)
+	}
+
+	version := newConfigVersion(c, models.ConfigVersionSourceRollback, comment)
+	version.RollbackOf = target
+	if err := applyServiceConfig(rollbackConfig, version); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditConfigFileUpdate, "config_file", config.ConfigPath, before, rollbackConfig)
+
+	c.Set(HeaderConfigVersion, strconv.Itoa(version.Version))
+	return handlers.SuccessResponse(c, true, "success to rollback config", version, nil)
+}
+
+func loadConfigVersion(version int) (*types.ConfigServices, error) {
+	stored := new(models.ConfigVersion)
+	if err := models.FindConfigVersionByVersion(stored, version); err != nil {
+		return nil, err
+	}
+
+	cfg := new(types.ConfigServices)
+	if err := json.Unmarshal(stored.Config, cfg); err != nil {
+		return nil, fmt.Errorf("config version %d is corrupted: %w", version, err)
+	}
+
+	return cfg, nil
+}
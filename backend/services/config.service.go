@@ -72,9 +72,22 @@ func UpsertConfiguration(c fiber.Ctx) error {
 		return handlers.InternalServerErrorResponse(c, err)
 	}
 
+	RecordAudit(c, AuditConfigurationUpsert, "configuration", configurationTarget(body), nil, body)
+	broadcastMailInvalidate()
+
 	return handlers.SuccessResponse(c, true, "success to insert config", body, nil)
 }
 
+func configurationTarget(body []models.Configuration) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if body[0].ConfigurationName != nil {
+		return body[0].ConfigurationGroup + "/" + *body[0].ConfigurationName
+	}
+	return body[0].ConfigurationGroup
+}
+
 func DeleteConfiguration(c fiber.Ctx) error {
 	group := c.Params("group")
 	if group == "" {
@@ -86,10 +99,16 @@ func DeleteConfiguration(c fiber.Ctx) error {
 		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need config_name params"))
 	}
 
+	before := &[]models.Configuration{}
+	models.FindConfiguration(before, "configuration_group = ? AND configuration_name = ?", group, config_name)
+
 	if err := models.DeleteConfigurationByConfName(group, config_name); err != nil {
 		return handlers.NotFoundErrorResponse(c, err)
 	}
 
+	RecordAudit(c, AuditConfigurationDelete, "configuration", group+"/"+config_name, before, nil)
+	broadcastMailInvalidate()
+
 	// RESET INDEX
 	d := &[]models.GroupConfiguration{}
 	err := models.FindGroupConfiguration(d, "configuration_group = ?", group).Error
@@ -120,6 +139,8 @@ func ConfigExecuteScript(c fiber.Ctx) error {
 		})
 	}
 
+	RecordAudit(c, AuditScriptExecute, "script", config_work_dir+"\\"+config_file, nil, nil)
+
 	// Run in background goroutine (non-blocking)
 	go func() {
 		handlers.ExecuteScript(config_work_dir+"\\"+config_file, config_work_dir)
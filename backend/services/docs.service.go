@@ -0,0 +1,293 @@
+package services
+
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-gerbang/handlers"
+	"go-gerbang/middleware"
+	"go-gerbang/models"
+	"go-gerbang/proxyroute"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// prefixes of the gateway's own routes published in the documentation
+var docsGatewayPrefixes = []string{"/api/v1/auth", "/users", "/auth/"}
+
+// request bodies of the gateway handlers, by handler
+var docsRequestBodies = map[uintptr]interface{}{
+	handlerPointer(Login):                     types.LoginInput{},
+	handlerPointer(ValidateUserPasswordById):  types.LoginInput{},
+	handlerPointer(RequestResetPassword):      types.LoginInput{},
+	handlerPointer(ResetPassword):             types.ResetPasswordInput{},
+	handlerPointer(ChangePassword):            types.ResetPasswordInput{},
+	handlerPointer(LoginWithGoogle):           types.GoogleLogin{},
+	handlerPointer(Signup):                    models.User{},
+	handlerPointer(CreateUser):                models.User{},
+	handlerPointer(UpdateUser):                models.User{},
+	handlerPointer(CreateAuthRole):            models.AuthRule{},
+	handlerPointer(UpdateAuthRole):            models.AuthRule{},
+	handlerPointer(CreateUserAssignment):      models.UserAssignment{},
+	handlerPointer(UpdateUserAssignment):      models.UserAssignment{},
+	handlerPointer(CreateUserAssignmentsBulk): []models.UserAssignment{},
+	handlerPointer(UpdateUserAssignmentsBulk): []models.UserAssignment{},
+	handlerPointer(CreateApiKey):              types.ApiKeyInput{},
+	handlerPointer(UpdateApiKey):              types.ApiKeyInput{},
+	handlerPointer(CreateClientCertBinding):   models.ClientCertBinding{},
+	handlerPointer(UpdateClientCertBinding):   models.ClientCertBinding{},
+}
+
+var (
+	docsAuth        = handlerPointer(middleware.Auth)
+	docsCsrf        = handlerPointer(middleware.CsrfProtection)
+	docsCaptcha     = handlerPointer(middleware.ValidateCaptcha)
+	docsSession     = handlerPointer(middleware.ValidateSession)
+	docsAdminGuard  = handlerPointer(middleware.AdminGuard(""))
+	docsCacheTTL    = 5 * time.Minute
+	docsCacheMutex  sync.Mutex
+	docsCache       map[string]interface{}
+	docsCacheExpire time.Time
+)
+
+const docsUIPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>API Gateway</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>
+window.ui = SwaggerUIBundle({ url: "openapi.json", dom_id: "#swagger-ui", withCredentials: true });
+</script>
+</body>
+</html>`
+
+// GetDocsUI serves the documentation UI of the combined OpenAPI document.
+func GetDocsUI(c fiber.Ctx) error {
+	if !strings.HasSuffix(c.Path(), "/") {
+		return c.Redirect().To(c.Path() + "/")
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.SendString(docsUIPage)
+}
+
+// GetOpenAPIDocument serves the OpenAPI document of the gateway's own APIs
+// merged with the documents of the proxied services. It is rebuilt every
+// few minutes, or on ?refresh=true.
+func GetOpenAPIDocument(c fiber.Ctx) error {
+	docsCacheMutex.Lock()
+	defer docsCacheMutex.Unlock()
+
+	if docsCache == nil || time.Now().After(docsCacheExpire) || c.Query("refresh") == "true" {
+		docsCache = buildOpenAPIDocument(c.App())
+		docsCacheExpire = time.Now().Add(docsCacheTTL)
+	}
+
+	return c.JSON(docsCache)
+}
+
+func buildOpenAPIDocument(app *fiber.App) map[string]interface{} {
+	paths := gatewayPaths(app)
+	components := map[string]map[string]interface{}{
+		"schemas": {
+			"SuccessResponse": handlers.JSONSchemaOf(handlers.SuccessStruct{}),
+		},
+		"securitySchemes": {
+			proxyroute.SecurityBearer:  map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			proxyroute.SecurityCookie:  map[string]interface{}{"type": "apiKey", "in": "cookie", "name": middleware.CookieJWT},
+			proxyroute.SecurityApiKey:  map[string]interface{}{"type": "apiKey", "in": "header", "name": middleware.HeaderApiKey},
+			proxyroute.SecurityCsrf:    map[string]interface{}{"type": "apiKey", "in": "header", "name": middleware.CsrfHeaderName},
+			proxyroute.SecuritySession: map[string]interface{}{"type": "apiKey", "in": "cookie", "name": middleware.CookieSession},
+		},
+	}
+	tags := []interface{}{map[string]interface{}{"name": "gateway", "description": "Authentication, users and roles of the gateway"}}
+
+	specs, errs := proxyroute.AggregateOpenAPI()
+	for _, spec := range specs {
+		tags = append(tags, map[string]interface{}{"name": spec.Service})
+		for path, item := range spec.Paths {
+			paths[path] = item
+		}
+		for section, entries := range spec.Components {
+			if components[section] == nil {
+				components[section] = map[string]interface{}{}
+			}
+			for name, value := range entries {
+				components[section][name] = value
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "API Gateway",
+			"version": "1.0.0",
+		},
+		"servers":       []interface{}{map[string]interface{}{"url": "/"}},
+		"tags":          tags,
+		"paths":         paths,
+		"components":    components,
+		"x-generated":   time.Now().Format(time.RFC3339),
+		"x-load-errors": errs,
+	}
+}
+
+// gatewayPaths describes the routes of the gateway under
+// docsGatewayPrefixes from the handlers they are registered with.
+func gatewayPaths(app *fiber.App) map[string]interface{} {
+	// GetRoutes(true) leaves the USE routes out, what remains of the full
+	// list are the middlewares of app.Use and of the groups
+	registered := map[string]int{}
+	for _, route := range app.GetRoutes(true) {
+		registered[routeKey(route)]++
+	}
+	uses := map[string][]fiber.Route{}
+	routes := []fiber.Route{}
+	for _, route := range app.GetRoutes() {
+		if key := routeKey(route); registered[key] > 0 {
+			registered[key]--
+			routes = append(routes, route)
+			continue
+		}
+		uses[route.Method] = append(uses[route.Method], route)
+	}
+
+	paths := map[string]interface{}{}
+	for _, route := range routes {
+		if !isDocsRoute(route.Path) || route.Method == fiber.MethodHead || len(route.Handlers) == 0 {
+			continue
+		}
+
+		chain := []fiber.Handler{}
+		for _, use := range uses[route.Method] {
+			prefix := strings.TrimSuffix(use.Path, "/")
+			if route.Path == use.Path || strings.HasPrefix(route.Path, prefix+"/") {
+				chain = append(chain, use.Handlers...)
+			}
+		}
+		chain = append(chain, route.Handlers...)
+
+		path, parameters := openAPIPath(route.Path)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = gatewayOperation(route, chain, parameters)
+	}
+
+	return paths
+}
+
+func routeKey(route fiber.Route) string {
+	key := route.Method + " " + route.Path
+	for _, h := range route.Handlers {
+		key += " " + strconv.FormatUint(uint64(handlerPointer(h)), 16)
+	}
+	return key
+}
+
+func gatewayOperation(route fiber.Route, chain []fiber.Handler, parameters []interface{}) map[string]interface{} {
+	final := chain[len(chain)-1]
+	name := runtime.FuncForPC(handlerPointer(final)).Name()
+	name = name[strings.LastIndex(name, ".")+1:]
+
+	service := types.Service{Service: "gateway"}
+	captcha := false
+	for _, h := range chain {
+		switch handlerPointer(h) {
+		case docsAuth:
+			service.AuthProtection = true
+		case docsCsrf:
+			service.CsrfProtection = true
+		case docsSession:
+			service.SessionProtection = true
+		case docsAdminGuard:
+			// authenticates on its own unless Auth ran before it
+			if !service.AuthProtection {
+				service.AuthProtection = true
+				service.AuthMode = types.AuthModeJWTOrApiKey
+			}
+			service.RbacProtection = true
+		case docsCaptcha:
+			captcha = true
+		}
+	}
+
+	extension := proxyroute.GatewayExtension(service)
+	extension["captcha"] = captcha
+
+	operation := map[string]interface{}{
+		"tags":        []string{"gateway"},
+		"summary":     name,
+		"operationId": strings.ToLower(route.Method) + "_" + name,
+		"security":    proxyroute.OperationSecurity(service),
+		"x-gateway":   extension,
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "OK",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{"$ref": "#/components/schemas/SuccessResponse"},
+					},
+				},
+			},
+		},
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if body, ok := docsRequestBodies[handlerPointer(final)]; ok {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": handlers.JSONSchemaOf(body)},
+			},
+		}
+	}
+
+	return operation
+}
+
+// openAPIPath turns /users/by-id/:userId into /users/by-id/{userId}.
+func openAPIPath(path string) (string, []interface{}) {
+	parameters := []interface{}{}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+		name := strings.TrimSuffix(segment[1:], "?")
+		segments[i] = "{" + name + "}"
+		parameters = append(parameters, map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	return strings.Join(segments, "/"), parameters
+}
+
+func isDocsRoute(path string) bool {
+	for _, prefix := range docsGatewayPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func handlerPointer(h fiber.Handler) uintptr {
+	return reflect.ValueOf(h).Pointer()
+}
@@ -0,0 +1,437 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
+)
+
+const (
+	AuditEmailTemplateSave     = "email_template.save"
+	AuditEmailTemplateRollback = "email_template.rollback"
+	AuditEmailTemplateDelete   = "email_template.delete"
+)
+
+// Templates sent by the gateway, other names are custom templates.
+const (
+	EmailTemplateResetPassword = "reset-password"
+	EmailTemplateWelcome       = "welcome"
+	EmailTemplateAccountInfo   = "account-info"
+)
+
+const EmailLocaleDefault = "id"
+
+var EmailLocales = []string{"id", "en"}
+
+var (
+	errEmailTemplateNotFound = errors.New("email template is not found")
+	emailTemplateName        = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+)
+
+const emailSecretNotice = `<div style="padding-top: 20px; font-size: 12px; line-height: 16px; color: rgb(95, 99, 104); letter-spacing: 0.3px; text-align: center;">`
+
+// builtinEmailTemplates are sent when no version is stored, keyed by
+// name/locale.
+var builtinEmailTemplates = map[string]handlers.EmailTemplateContent{
+	EmailTemplateResetPassword + "/id": {
+		Subject: "Reset Password",
+		Title:   "Permintaan Reset Password",
+		BodyHtml: `Hi {{.fullName}},<br/>
+		untuk reset password silahkan klik link di bawah ini:
+		<div style="padding-top:30px;padding-bottom:28px;text-align:center">
+			<a href="{{.resetUrl}}" style="font-family:'Google Sans',Roboto,RobotoDraft,Helvetica,Arial,sans-serif;line-height:16px;color:#ffffff;font-weight:400;text-decoration:none;font-size:14px;display:inline-block;padding:10px 24px;background-color:#171717;border-radius:5px;min-width:90px">Reset Password</a>
+		</div>
+		link ini hanya aktif selama 24 jam`,
+		BodyText: `Hi {{.fullName}},
+
+untuk reset password silahkan buka link di bawah ini:
+
+{{.resetUrl}}
+
+link ini hanya aktif selama 24 jam`,
+		Footer: "email ini dikirim oleh {{.sender}}",
+	},
+	EmailTemplateResetPassword + "/en": {
+		Subject: "Reset Password",
+		Title:   "You requested a password reset",
+		BodyHtml: `Hi {{.fullName}},<br/>
+		for reset password please click link below:
+		<div style="padding-top:30px;padding-bottom:28px;text-align:center">
+			<a href="{{.resetUrl}}" style="font-family:'Google Sans',Roboto,RobotoDraft,Helvetica,Arial,sans-serif;line-height:16px;color:#ffffff;font-weight:400;text-decoration:none;font-size:14px;display:inline-block;padding:10px 24px;background-color:#171717;border-radius:5px;min-width:90px">Reset Link</a>
+		</div>
+		this link only active in 24 hours`,
+		BodyText: `Hi {{.fullName}},
+
+for reset password please open link below:
+
+{{.resetUrl}}
+
+this link only active in 24 hours`,
+		Footer: "you are receiving this mail from {{.sender}}",
+	},
+	EmailTemplateWelcome + "/id": {
+		Subject: "Selamat Datang di {{.sender}}",
+		Title:   "Akun Anda Berhasil Di Buat",
+		BodyHtml: `<div>Hi, {{.fullName}}, berikut informasi akun anda:</div>
+		<br/>
+		<div>username: <strong>{{.username}}</strong></div>
+		<div>email: <strong>{{.email}}</strong></div>
+		<br/>
+		` + emailSecretNotice + `Tetap jaga rahasia akun anda, mohon untuk jangan diberikan kepada siapapun termasuk Admin.</div>`,
+		BodyText: `Hi, {{.fullName}}, berikut informasi akun anda:
+
+username: {{.username}}
+email: {{.email}}
+
+Tetap jaga rahasia akun anda, mohon untuk jangan diberikan kepada siapapun termasuk Admin.`,
+		Footer: "ini merupakan email otomatis dari {{.sender}}",
+	},
+	EmailTemplateWelcome + "/en": {
+		Subject: "Welcome to {{.sender}}",
+		Title:   "Your Account Has Been Created",
+		BodyHtml: `<div>Hi, {{.fullName}}, here is your account information:</div>
+		<br/>
+		<div>username: <strong>{{.username}}</strong></div>
+		<div>email: <strong>{{.email}}</strong></div>
+		<br/>
+		` + emailSecretNotice + `Keep your account secret, never share it with anyone including the Admin.</div>`,
+		BodyText: `Hi, {{.fullName}}, here is your account information:
+
+username: {{.username}}
+email: {{.email}}
+
+Keep your account secret, never share it with anyone including the Admin.`,
+		Footer: "this is an automatic email from {{.sender}}",
+	},
+	EmailTemplateAccountInfo + "/id": {
+		Subject: "Create Account Success",
+		Title:   "Akun Anda Berhasil Di Buat",
+		BodyHtml: `<div>Hi, {{.fullName}}, berikut informasi akun anda:</div>
+		<br/>
+		<div>username: <strong>{{.username}}</strong></div>
+		<div>email: <strong>{{.email}}</strong></div>
+		{{if .resetUrl}}<div>atur password anda melalui <a href="{{.resetUrl}}">link ini</a>, link hanya aktif selama 24 jam</div>{{end}}
+		<br/>
+		` + emailSecretNotice + `Tetap jaga rahasia akun anda, mohon untuk jangan diberikan kepada siapapun termasuk Admin.</div>`,
+		BodyText: `Hi, {{.fullName}}, berikut informasi akun anda:
+
+username: {{.username}}
+email: {{.email}}
+{{if .resetUrl}}atur password anda melalui link berikut, link hanya aktif selama 24 jam:
+{{.resetUrl}}
+{{end}}
+Tetap jaga rahasia akun anda, mohon untuk jangan diberikan kepada siapapun termasuk Admin.`,
+		Footer: "ini merupakan email otomatis dari {{.sender}}",
+	},
+	EmailTemplateAccountInfo + "/en": {
+		Subject: "Create Account Success",
+		Title:   "Your Account Has Been Created",
+		BodyHtml: `<div>Hi, {{.fullName}}, here is your account information:</div>
+		<br/>
+		<div>username: <strong>{{.username}}</strong></div>
+		<div>email: <strong>{{.email}}</strong></div>
+		{{if .resetUrl}}<div>set your password with <a href="{{.resetUrl}}">this link</a>, it is only active for 24 hours</div>{{end}}
+		<br/>
+		` + emailSecretNotice + `Keep your account secret, never share it with anyone including the Admin.</div>`,
+		BodyText: `Hi, {{.fullName}}, here is your account information:
+
+username: {{.username}}
+email: {{.email}}
+{{if .resetUrl}}set your password with the link below, it is only active for 24 hours:
+{{.resetUrl}}
+{{end}}
+Keep your account secret, never share it with anyone including the Admin.`,
+		Footer: "this is an automatic email from {{.sender}}",
+	},
+}
+
+// emailTemplateSample is the data of a preview.
+func emailTemplateSample(sender string) map[string]string {
+	return map[string]string{
+		"sender":   sender,
+		"fullName": "Budi Santoso",
+		"username": "budi",
+		"email":    "budi@example.com",
+		"resetUrl": "https://example.com/auth/forget-password?token=sample",
+	}
+}
+
+// emailLocale reduces locale to one of EmailLocales, "en-US" is "en".
+func emailLocale(locale string) string {
+	locale, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(locale)), "-")
+	if slices.Contains(EmailLocales, locale) {
+		return locale
+	}
+	return EmailLocaleDefault
+}
+
+// findEmailTemplate returns the template in use and its locale. It looks
+// for the template of the sender, then the default one, then the built-in
+// one, in locale and then in EmailLocaleDefault.
+func findEmailTemplate(sender string, name string, locale string) (handlers.EmailTemplateContent, string, error) {
+	locales := []string{emailLocale(locale)}
+	if locales[0] != EmailLocaleDefault {
+		locales = append(locales, EmailLocaleDefault)
+	}
+	senders := []string{sender}
+	if sender != "" {
+		senders = append(senders, "")
+	}
+
+	for _, locale := range locales {
+		for _, sender := range senders {
+			stored := new(models.EmailTemplate)
+			err := models.FindEmailTemplateInUse(stored, sender, name, locale)
+			if err == nil {
+				return emailTemplateContent(stored), locale, nil
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return handlers.EmailTemplateContent{}, "", err
+			}
+		}
+
+		if builtin, ok := builtinEmailTemplates[name+"/"+locale]; ok {
+			return builtin, locale, nil
+		}
+	}
+
+	return handlers.EmailTemplateContent{}, "", fmt.Errorf("%w: %s", errEmailTemplateNotFound, name)
+}
+
+func emailTemplateContent(template *models.EmailTemplate) handlers.EmailTemplateContent {
+	return handlers.EmailTemplateContent{
+		Subject:  template.Subject,
+		Title:    template.Title,
+		BodyHtml: template.BodyHtml,
+		BodyText: template.BodyText,
+		Footer:   template.Footer,
+	}
+}
+
+// emailVariables adds the sender to the variables of a notification.
+func emailVariables(sender string, variables map[string]string) map[string]string {
+	result := map[string]string{"sender": sender}
+	maps.Copy(result, variables)
+	return result
+}
+
+func GetAllEmailTemplate(c fiber.Ctx) error {
+	var sender *string
+	if c.RequestCtx().QueryArgs().Has("sender") {
+		value := c.Query("sender")
+		sender = &value
+	}
+
+	d := &[]models.EmailTemplate{}
+	if err := models.FindLatestEmailTemplate(d, sender, c.Query("name"), c.Query("locale")).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+	count := int64(len(*d))
+
+	return handlers.SuccessResponse(c, true, "success to get all email template", d, &count)
+}
+
+// GetBuiltinEmailTemplate lists the built-in templates, a starting point for
+// a stored version.
+func GetBuiltinEmailTemplate(c fiber.Ctx) error {
+	d := []fiber.Map{}
+	for _, key := range slices.Sorted(maps.Keys(builtinEmailTemplates)) {
+		name, locale, _ := strings.Cut(key, "/")
+		d = append(d, fiber.Map{
+			"name":     name,
+			"locale":   locale,
+			"template": builtinEmailTemplates[key],
+		})
+	}
+	count := int64(len(d))
+
+	return handlers.SuccessResponse(c, true, "success to get builtin email template", d, &count)
+}
+
+func GetEmailTemplateVersion(c fiber.Ctx) error {
+	name := c.Query("name")
+	locale := c.Query("locale")
+	if name == "" || locale == "" {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need name and locale params"))
+	}
+
+	d := &[]models.EmailTemplate{}
+	if err := models.FindEmailTemplateVersion(d, c.Query("sender"), name, locale).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+	count := int64(len(*d))
+
+	return handlers.SuccessResponse(c, true, "success to get email template versions", d, &count)
+}
+
+func GetEmailTemplateById(c fiber.Ctx) error {
+	template := new(models.EmailTemplate)
+	if err := models.FindEmailTemplateById(template, c.Params("id")); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	return handlers.SuccessResponse(c, true, "success to get email template", template, nil)
+}
+
+// SaveEmailTemplate stores the input as the next version of the template.
+func SaveEmailTemplate(c fiber.Ctx) error {
+	input := new(types.EmailTemplateInput)
+
+	if err := handlers.ParseBody(c, input); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	if err := handlers.ValidateStruct(*input); err != nil {
+		return handlers.SuccessResponse(c, false, "error validation email template", err, nil)
+	}
+	if !emailTemplateName.MatchString(input.Name) {
+		return handlers.BadRequestErrorResponse(c, fmt.Errorf("template name may only hold lowercase letters, digits and dashes"))
+	}
+
+	template := &models.EmailTemplate{
+		Sender:   input.Sender,
+		Name:     input.Name,
+		Locale:   input.Locale,
+		Subject:  input.Subject,
+		Title:    input.Title,
+		BodyHtml: input.BodyHtml,
+		BodyText: input.BodyText,
+		Footer:   input.Footer,
+	}
+	if err := handlers.ParseEmailTemplate(emailTemplateContent(template)); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	if user, ok := c.Locals("user").(*models.UserData); ok {
+		template.Author = user.Username
+	}
+
+	if err := models.CreateEmailTemplateVersion(template); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditEmailTemplateSave, "email_template", emailTemplateTarget(template), nil, template)
+
+	return handlers.SuccessResponse(c, true, "success to save email template", template, nil)
+}
+
+// RollbackEmailTemplate stores a copy of an older version as the next one.
+func RollbackEmailTemplate(c fiber.Ctx) error {
+	source := new(models.EmailTemplate)
+	if err := models.FindEmailTemplateById(source, c.Params("id")); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	before := new(models.EmailTemplate)
+	if err := models.FindEmailTemplateInUse(before, source.Sender, source.Name, source.Locale); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	template := &models.EmailTemplate{
+		Sender:     source.Sender,
+		Name:       source.Name,
+		Locale:     source.Locale,
+		Subject:    source.Subject,
+		Title:      source.Title,
+		BodyHtml:   source.BodyHtml,
+		BodyText:   source.BodyText,
+		Footer:     source.Footer,
+		RollbackOf: source.Version,
+	}
+
+	if user, ok := c.Locals("user").(*models.UserData); ok {
+		template.Author = user.Username
+	}
+
+	if err := models.CreateEmailTemplateVersion(template); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditEmailTemplateRollback, "email_template", emailTemplateTarget(template), before, template)
+
+	return handlers.SuccessResponse(c, true, fmt.Sprintf("success to rollback email template to version %d", source.Version), template, nil)
+}
+
+// DeleteEmailTemplate removes every version of the template of id.
+func DeleteEmailTemplate(c fiber.Ctx) error {
+	before := new(models.EmailTemplate)
+	if err := models.FindEmailTemplateById(before, c.Params("id")); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	if err := models.DeleteEmailTemplate(before.Sender, before.Name, before.Locale).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditEmailTemplateDelete, "email_template", emailTemplateTarget(before), before, nil)
+
+	return handlers.SuccessResponse(c, true, "success to delete email template", nil, nil)
+}
+
+// PreviewEmailTemplate renders a template with sample data, ?format=html
+// sends the html alone.
+func PreviewEmailTemplate(c fiber.Ctx) error {
+	input := new(types.EmailTemplatePreviewInput)
+
+	if err := handlers.ParseBody(c, input); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	sender := mailSender(input.Sender)
+	locale := emailLocale(input.Locale)
+
+	content := handlers.EmailTemplateContent{
+		Subject:  input.Subject,
+		Title:    input.Title,
+		BodyHtml: input.BodyHtml,
+		BodyText: input.BodyText,
+		Footer:   input.Footer,
+	}
+	if content.BodyHtml == "" {
+		if input.Name == "" {
+			return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need a template name or a bodyHtml"))
+		}
+
+		var err error
+		content, locale, err = findEmailTemplate(input.Sender, input.Name, locale)
+		if errors.Is(err, errEmailTemplateNotFound) {
+			return handlers.NotFoundErrorResponse(c, err)
+		}
+		if err != nil {
+			return handlers.InternalServerErrorResponse(c, err)
+		}
+	}
+
+	variables := emailTemplateSample(sender)
+	maps.Copy(variables, input.Variables)
+
+	rendered, err := handlers.RenderEmailTemplate(content, locale, GetImageEmail(sender, mailProvider(sender)), variables)
+	if err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	if c.Query("format") == "html" {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		return c.SendString(rendered.Html)
+	}
+
+	return handlers.SuccessResponse(c, true, "success to preview email template", rendered, nil)
+}
+
+func emailTemplateTarget(template *models.EmailTemplate) string {
+	sender := template.Sender
+	if sender == "" {
+		sender = "*"
+	}
+	return sender + "/" + template.Name + "/" + template.Locale
+}
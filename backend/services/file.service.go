@@ -7,27 +7,34 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"go-gerbang/config"
 	"go-gerbang/handlers"
-	"go-gerbang/types"
+	"go-gerbang/models"
+	"go-gerbang/proxyroute"
 
 	"github.com/gofiber/fiber/v3"
 )
 
 func HandleConfigFile(c fiber.Ctx) error {
-	u := new(types.ConfigServices)
-
-	if err := handlers.ParseBody(c, u); err != nil {
-		return handlers.BadRequestErrorResponse(c, err)
+	u, issues, err := handlers.ParseConfig(c.Body())
+	if err != nil {
+		return configValidationResponse(c, issues, err)
 	}
 
-	if err := handlers.SaveConfig(config.BasePath+config.ConfigPath, u); err != nil {
+	before, _ := proxyroute.LoadServices()
+
+	version := newConfigVersion(c, models.ConfigVersionSourceApi, c.Query("comment"))
+	if err := applyServiceConfig(u, version); err != nil {
 		return handlers.InternalServerErrorResponse(c, err)
 	}
 
+	RecordAudit(c, AuditConfigFileUpdate, "config_file", config.ConfigPath, before, u)
+	c.Set(HeaderConfigVersion, strconv.Itoa(version.Version))
+
 	return handlers.SuccessResponse(c, true, "success update config file", u, nil)
 }
 
@@ -89,6 +96,8 @@ func HandleFileUpload(c fiber.Ctx) error {
 		}
 	}
 
+	RecordAudit(c, AuditFileUpload, "file", uploadRoot, nil, fiber.Map{"files": len(files), "backup": backupDir})
+
 	return handlers.SuccessResponse(c, true, "success upload file", nil, nil)
 }
 
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"go-gerbang/config"
+	"go-gerbang/handlers"
+	"go-gerbang/middleware"
+	"go-gerbang/models"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	HeaderAuthUserId   = "X-Auth-User-Id"
+	HeaderAuthUsername = "X-Auth-Username"
+	HeaderAuthFullName = "X-Auth-Full-Name"
+	HeaderAuthEmail    = "X-Auth-Email"
+	HeaderAuthRoles    = "X-Auth-Roles"
+)
+
+// ForwardAuth is compatible with nginx auth_request and Traefik forwardAuth.
+// The original request is read from X-Forwarded-Method/X-Forwarded-Uri
+// (Traefik) or X-Original-Method/X-Original-URI (nginx). Session and RBAC
+// checks follow the matching service of config.json, the session and rbac
+// query params can only add a check. An X-API-Key is only accepted when the
+// auth_mode of the service allows it. A path without a service is denied,
+// unless FORWARD_AUTH_ALLOW_UNMATCHED is set, then it always needs RBAC and
+// an API key scoped for "*".
+func ForwardAuth(c fiber.Ctx) error {
+	method := firstNonEmpty(c.Get("X-Forwarded-Method"), c.Get("X-Original-Method"), c.Method())
+	uri := firstNonEmpty(c.Get("X-Forwarded-Uri"), c.Get("X-Original-URI"))
+	if uri == "" {
+		return handlers.BadRequestErrorResponse(c, fmt.Errorf("need X-Forwarded-Uri or X-Original-URI header"))
+	}
+
+	path, err := forwardedPath(uri)
+	if err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	service, found := handlers.FindServiceByPath(path)
+	scope := service.Service
+	if !found {
+		if !config.ForwardAuthAllowUnmatched {
+			return handlers.ForbiddenErrorResponse(c, fmt.Errorf("no service is configured for this path"))
+		}
+		scope = "*"
+	}
+
+	checkSession := (found && service.SessionProtection) || fiber.Query[bool](c, "session")
+	checkRbac := !found || service.RbacProtection || fiber.Query[bool](c, "rbac")
+
+	authMode := service.AuthMode
+	if !found {
+		authMode = types.AuthModeJWTOrApiKey
+	}
+
+	var user *models.UserData
+	raw := c.Get(middleware.HeaderApiKey)
+	if authMode == types.AuthModeApiKey || (authMode == types.AuthModeJWTOrApiKey && raw != "") {
+		user, err = middleware.VerifyApiKey(c, raw, scope, method)
+		if err != nil {
+			return middleware.ApiKeyErrorResponse(c, err)
+		}
+	} else {
+		user, err = middleware.Authenticate(c)
+		if err != nil {
+			return handlers.UnauthorizedErrorResponse(c, err)
+		}
+	}
+
+	if checkSession {
+		if err := middleware.CheckSession(c); err != nil {
+			return handlers.UnauthorizedErrorResponse(c, err)
+		}
+	}
+
+	if checkRbac {
+		allowed, err := middleware.EnforceRBAC(user, path, method)
+		if err != nil {
+			return handlers.InternalServerErrorResponse(c, fmt.Errorf("failed to check your role access"))
+		}
+		if !allowed {
+			return handlers.ForbiddenErrorResponse(c, fmt.Errorf("your role don't have access"))
+		}
+	}
+
+	roles := make([]string, 0, len(user.UserAssignments))
+	for _, ua := range user.UserAssignments {
+		roles = append(roles, strconv.Itoa(ua.AuthRoleId))
+	}
+
+	c.Set(HeaderAuthUserId, user.IdAccount)
+	c.Set(HeaderAuthUsername, user.Username)
+	c.Set(HeaderAuthFullName, user.FullName)
+	c.Set(HeaderAuthEmail, user.Email)
+	c.Set(HeaderAuthRoles, strings.Join(roles, ","))
+
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// forwardedPath is the cleaned path of the original uri, the one the proxy
+// in front resolves. Encoded slashes are refused, upstreams disagree on
+// whether they separate segments.
+func forwardedPath(uri string) (string, error) {
+	parsed, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid original uri")
+	}
+
+	escaped := strings.ToLower(parsed.EscapedPath())
+	if strings.Contains(escaped, "%2f") || strings.Contains(escaped, "%5c") {
+		return "", fmt.Errorf("original uri must not contain encoded slashes")
+	}
+
+	return path.Clean("/" + parsed.Path), nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 	"go-gerbang/handlers"
 	"go-gerbang/models"
 	"go-gerbang/proxyroute"
+	"go-gerbang/types"
 
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/middleware/csrf"
@@ -34,29 +36,33 @@ func ProtectService(c fiber.Ctx) error {
 	return c.SendString("Testing Protect Route")
 }
 
+// InfoService lists the active services with their live status, the shared
+// map is copied so the checks never write to it.
 func InfoService(c fiber.Ctx) error {
-	var err error
-	handlers.MapMicroService, err = handlers.LoadConfig(config.BasePath + config.ConfigPath)
-	if err != nil {
-		return err
+	handlers.MapMicroServiceMutex.RLock()
+	services := []types.Service{}
+	if handlers.MapMicroService != nil {
+		services = append(services, handlers.MapMicroService.Services...)
 	}
+	version := handlers.ActiveConfigVersion.Load()
+	handlers.MapMicroServiceMutex.RUnlock()
 
-	for i := range handlers.MapMicroService.Services {
+	for i := range services {
 		// USING NET/HTTP
-		req, err := http.NewRequest("GET", handlers.MapMicroService.Services[i].Url, nil)
+		req, err := http.NewRequest("GET", services[i].Url, nil)
 		if err != nil {
-			handlers.MapMicroService.Services[i].Status = false
+			services[i].Status = false
 			continue
 		}
 
 		resp, err := proxyroute.ProxyClient.Do(req)
 		if err != nil {
-			handlers.MapMicroService.Services[i].Status = false
+			services[i].Status = false
 			continue
 		}
-		defer resp.Body.Close()
+		resp.Body.Close()
 
-		handlers.MapMicroService.Services[i].Status = resp.StatusCode == http.StatusOK
+		services[i].Status = resp.StatusCode == http.StatusOK
 
 		// USING FASTHTTP
 		// req := fasthttp.AcquireRequest()
@@ -77,7 +83,11 @@ func InfoService(c fiber.Ctx) error {
 		// }
 	}
 
-	return c.JSON(handlers.MapMicroService.Services)
+	c.Set(HeaderConfigVersion, strconv.FormatInt(version, 10))
+	return c.JSON(fiber.Map{
+		"version":  version,
+		"services": services,
+	})
 }
 
 func CheckLocalService(c fiber.Ctx) error {
@@ -150,6 +160,9 @@ func RestartHandler(c fiber.Ctx) error {
 		}
 	}()
 
+	hostname, _ := os.Hostname()
+	RecordAudit(c, AuditGatewayRestart, "gateway", hostname, nil, nil)
+
 	return c.JSON(fiber.Map{
 		"message": "Service restarting...",
 		"os":      runtime.GOOS,
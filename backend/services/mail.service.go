@@ -81,6 +81,14 @@ func MailTesting(c fiber.Ctx) error {
 	}
 
 	tipe := c.Query("type")
+	if tipe == "event" && c.Query("template") != "" {
+		// renders the template with the sample data of the preview
+		sendToEvent := TemplateEmail(provider, appName, c.Query("template"), c.Query("locale"), emailTemplateSample(appName), dataSend.Emails[0])
+		if err := QueueNotification(sendToEvent); err != nil {
+			return handlers.InternalServerErrorResponse(c, err)
+		}
+		return handlers.SuccessResponse(c, true, "Send Mail On Event Success", nil, nil)
+	}
 	if tipe == "event" {
 		var sendToEvent types.SendingEmailToBroker
 		sendToEvent = types.SendingEmailToBroker{
@@ -93,7 +101,9 @@ func MailTesting(c fiber.Ctx) error {
 			Footer:   "",
 			Emails:   dataSend.Emails,
 		}
-		PublishEvent("user.notification", sendToEvent)
+		if err := QueueNotification(sendToEvent); err != nil {
+			return handlers.InternalServerErrorResponse(c, err)
+		}
 		return handlers.SuccessResponse(c, true, "Send Mail On Event Success", nil, nil)
 	}
 
@@ -110,49 +120,60 @@ func MailTesting(c fiber.Ctx) error {
 	return handlers.SuccessResponse(c, true, "Check Mail Success", nil, nil)
 }
 
-func QueueUserInformation(providerNotification string, querySender string, user *models.User, sendPass bool) bool {
-	Sender := "GOGERBANG"
-	if querySender != "" {
-		Sender = querySender
+const defaultMailSender = "GOGERBANG"
+
+func mailSender(querySender string) string {
+	if querySender == "" {
+		return defaultMailSender
 	}
+	return querySender
+}
 
-	textPass := ``
-	htmlPass := ``
-	if sendPass {
-		textPass = `password: ` + user.Password
-		htmlPass = `<div>password: <strong>` + user.Password + `</strong></div>`
+// TemplateEmail is a notification rendered from the template name of sender
+// for one recipient, an empty provider is the one configured for sender.
+func TemplateEmail(provider string, sender string, name string, locale string, variables map[string]string, to types.Email) *types.SendingEmailToBroker {
+	return &types.SendingEmailToBroker{
+		Sender:    sender,
+		Provider:  provider,
+		Template:  name,
+		Locale:    emailLocale(locale),
+		Variables: variables,
+		Emails:    []types.Email{to},
 	}
+}
 
-	sendEmail := new(types.SendingEmailToBroker)
-	sendEmail.Sender = Sender
-	sendEmail.Provider = providerNotification
-	sendEmail.Subject = "Create Account Success"
-	sendEmail.Title = "Akun Anda Berhasil Di Buat"
-	sendEmail.BodyText = `
-		Hi, ` + user.FullName + `, berikut informasi akun anda:
-
-		username: ` + user.Username + `
-		email: ` + user.Email + textPass + `
-
-		Tetap jaga rahasia akun anda, mohon untuk jangan diberikan kepada siapapun termasuk Admin.
-	`
-	sendEmail.Body = `
-		<div class="font-family: Roboto-Regular, Helvetica, Arial, sans-serif; font-size: 14px; color: rgba(0, 0, 0, 0.87); padding-top: 20px; text-align: center;">Hi, ` + user.FullName + `, berikut informasi akun anda:</div>
-		<br/>
-		<div>username: <strong>` + user.FullName + `</strong></div>
-		<div>email: <strong>` + user.Email + `</strong></div>` + htmlPass + `
-		<br/>
-		<div class="padding-top: 20px; font-size: 12px; line-height: 16px; color: rgb(95, 99, 104); letter-spacing: 0.3px; text-align: center;">Tetap jaga rahasia akun anda, mohon untuk jangan diberikan kepada siapapun termasuk Admin.</div>
-	`
-	sendEmail.Footer = "ini merupakan email otomatis dari " + Sender
-	sendEmail.Emails = []types.Email{
-		{
-			Name:      user.FullName,
-			EmailAddr: user.Email,
-		},
+func QueueUserInformation(providerNotification string, querySender string, locale string, user *models.User, setPasswordUrl string) bool {
+	return QueueNotification(UserInformationEmail(providerNotification, querySender, locale, user, setPasswordUrl)) == nil
+}
+
+// UserInformationEmail is the account-info email of user. With
+// setPasswordUrl it links to the page where the user sets a password, the
+// password itself is never queued.
+func UserInformationEmail(providerNotification string, querySender string, locale string, user *models.User, setPasswordUrl string) *types.SendingEmailToBroker {
+	variables := map[string]string{
+		"fullName": user.FullName,
+		"username": user.Username,
+		"email":    user.Email,
+	}
+	if setPasswordUrl != "" {
+		variables["resetUrl"] = setPasswordUrl
 	}
 
-	PublishEvent("user.notification", sendEmail)
+	return TemplateEmail(providerNotification, mailSender(querySender), EmailTemplateAccountInfo, locale, variables, types.Email{
+		Name:      user.FullName,
+		EmailAddr: user.Email,
+	})
+}
+
+// newSetPasswordUrl gives user a reset token and returns the ?baseUrl link
+// that sets the password with it. The caller stores the token.
+func newSetPasswordUrl(c fiber.Ctx, user *models.User) (string, error) {
+	baseUrl := c.Query("baseUrl")
+	if baseUrl == "" {
+		return "", fmt.Errorf("need base baseUrl params")
+	}
 
-	return true
+	token := handlers.GenerateResetRandom(64)
+	user.PasswordResetToken = &token
+	return baseUrl + "/auth/forget-password?token=" + token, nil
 }
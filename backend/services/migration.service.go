@@ -2,7 +2,9 @@ package services
 
 import (
 	"errors"
+	"fmt"
 	"go-gerbang/handlers"
+	"go-gerbang/middleware"
 	"go-gerbang/models"
 
 	"go-gerbang/database"
@@ -18,6 +20,16 @@ func CheckMigrationStatus(c fiber.Ctx) error {
 		"auth_rules",
 		"loggers",
 		"configurations",
+		"api_keys",
+		"client_cert_bindings",
+		"basic_auth_credentials",
+		"audit_events",
+		"config_versions",
+		"services",
+		"outbox_events",
+		"webhooks",
+		"webhook_deliveries",
+		"email_templates",
 	}
 
 	missing := []string{}
@@ -60,12 +72,38 @@ func MigrationService(c fiber.Ctx) error {
 		&models.AuthRule{},
 		&models.Logger{},
 		&models.Configuration{},
+		&models.ApiKey{},
+		&models.ClientCertBinding{},
+		&models.BasicAuthCredential{},
+		&models.AuditEvent{},
+		&models.ConfigVersion{},
+		&models.Service{},
+		&models.OutboxEvent{},
+		&models.Webhook{},
+		&models.WebhookDelivery{},
+		&models.EmailTemplate{},
 	)
 
 	if err != nil {
 		return c.JSON(fiber.Map{"message": "failed to migration", "error": err.Error()})
 	}
 
+	if err := models.ProtectAuditEvents(); err != nil {
+		return c.JSON(fiber.Map{"message": "failed to protect audit events", "error": err.Error()})
+	}
+
+	if err := middleware.SeedAdminPolicies(); err != nil {
+		return c.JSON(fiber.Map{"message": "failed to seed admin policies", "error": err.Error()})
+	}
+
+	// existing installs keep their admin account as gateway admin
+	admin := new(models.User)
+	if err := database.GDB.Where("username = ?", "admin").First(admin).Error; err == nil {
+		if err := grantGatewayAdmin(admin.IdAccount.String()); err != nil {
+			return c.JSON(fiber.Map{"message": "failed to grant gateway admin", "error": err.Error()})
+		}
+	}
+
 	return handlers.SuccessResponse(c, true, "success migration", nil, nil)
 }
 
@@ -83,21 +121,63 @@ func MigrateAdminUser(c fiber.Ctx) error {
 	}
 
 	if database.GDB.Migrator().HasTable(&models.User{}) {
-		errCreate := database.GDB.Create(&models.User{
+		admin := &models.User{
 			Username:      "admin",
 			FullName:      "Admin",
 			StatusAccount: 10,
 			// PasswordHash:  handlers.GeneratePasswordHash("@dmin9192"),
 			PasswordHash: handlers.GeneratePasswordHash(u.Password),
-		})
+		}
+		errCreate := database.GDB.Create(admin)
 
 		if errCreate.Error != nil {
 			// IF ERROR CACHE, RUN THIS IN SQL:
 			// DISCARD ALL;
 			return c.JSON(fiber.Map{"message": "failed to create admin", "error": errCreate.Error})
 		}
+
+		if err := grantGatewayAdmin(admin.IdAccount.String()); err != nil {
+			return c.JSON(fiber.Map{"message": "failed to grant gateway admin", "error": err.Error()})
+		}
 		return handlers.SuccessResponse(c, true, "success create admin", nil, nil)
 	} else {
 		return c.JSON(fiber.Map{"message": "table user is not found"})
 	}
 }
+
+// grantGatewayAdmin assigns the account to the "gateway-admin" auth role,
+// creating it when missing, and grants that role the gateway:admin policy.
+func grantGatewayAdmin(accountId string) error {
+	role := new(models.AuthRule)
+	err := database.GDB.Where("name_auth_role = ?", middleware.AdminRoleGateway).First(role).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		role = &models.AuthRule{
+			NameAuthRole: middleware.AdminRoleGateway,
+			DescAuthRole: "Built-in gateway administrator",
+		}
+		err = models.CreateAuthRule(role).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := models.UpdateUserAssignment(&models.UserAssignment{AccountId: accountId, AuthRoleId: role.IdAuthRole}).Error; err != nil {
+		return err
+	}
+
+	if err := middleware.SeedAdminPolicies(); err != nil {
+		return err
+	}
+
+	authz, err := middleware.Enforcer()
+	if err != nil {
+		return err
+	}
+
+	if _, err := authz.AddGroupingPolicy(fmt.Sprintf("role:%d", role.IdAuthRole), middleware.AdminRoleGateway); err != nil {
+		return err
+	}
+
+	broadcastPolicyReload()
+	return nil
+}
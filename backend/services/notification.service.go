@@ -0,0 +1,398 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"go-gerbang/broker"
+	"go-gerbang/config"
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	notificationAckWait    = 2 * time.Minute
+	notificationMaxBackoff = time.Hour
+	notificationTimeout    = 5 * time.Second
+	deadLetterDefaultLimit = 50
+	deadLetterMaximumLimit = 500
+)
+
+var errNotificationInvalid = errors.New("invalid notification")
+
+// QueueNotification stores an email notification in the durable queue, it
+// is sent by the notification consumer of one of the instances.
+func QueueNotification(rawData interface{}, opts ...jetstream.PublishOpt) error {
+	if broker.JetStream == nil {
+		err := fmt.Errorf("jetstream is not available")
+		log.Printf("Error publishing to subject %s: %v", broker.NotificationSubject, err)
+		return err
+	}
+
+	data, err := json.Marshal(rawData)
+	if err != nil {
+		log.Printf("Error marshaling data: %v", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+	defer cancel()
+
+	if _, err := broker.JetStream.Publish(ctx, broker.NotificationSubject, data, opts...); err != nil {
+		log.Printf("Error publishing to subject %s: %v", broker.NotificationSubject, err)
+		return err
+	}
+
+	return nil
+}
+
+// StartNotificationConsumer sends the queued notifications. A failed email
+// is redelivered after an exponential backoff, once NOTIFICATION_MAX_DELIVER
+// attempts failed, or right away for an invalid message, it is moved to the
+// dead-letter stream.
+func StartNotificationConsumer() error {
+	if broker.JetStream == nil {
+		return fmt.Errorf("jetstream is not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+	defer cancel()
+
+	// the attempts are counted here, the server never gives up on its own so
+	// a message is dead-lettered with the error of its last attempt
+	consumer, err := broker.JetStream.CreateOrUpdateConsumer(ctx, broker.NotificationStream, jetstream.ConsumerConfig{
+		Durable:       broker.NotificationConsumer,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       notificationAckWait,
+		MaxDeliver:    -1,
+		MaxAckPending: 16,
+		FilterSubject: broker.NotificationSubject,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = consumer.Consume(processNotification)
+	return err
+}
+
+func processNotification(msg jetstream.Msg) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		log.Printf("error: notification metadata: %s", err)
+		msg.Nak()
+		return
+	}
+
+	maxDeliver := uint64(max(config.NotificationMaxDeliver, 1))
+	if meta.NumDelivered > maxDeliver {
+		// left unacknowledged by a previous attempt
+		deadLetterNotification(msg, meta, fmt.Errorf("no acknowledgement after %d deliveries", maxDeliver))
+		return
+	}
+
+	err = sendNotification(msg.Data())
+	switch {
+	case err == nil:
+		msg.Ack()
+	case errors.Is(err, errNotificationInvalid) || meta.NumDelivered >= maxDeliver:
+		deadLetterNotification(msg, meta, err)
+	default:
+		delay := notificationBackoff(meta.NumDelivered)
+		log.Printf("error: notification %d, attempt %d, retry in %s: %s", meta.Sequence.Stream, meta.NumDelivered, delay, err)
+		msg.NakWithDelay(delay)
+	}
+}
+
+// notificationBackoff is NOTIFICATION_BACKOFF doubled for every failed
+// attempt, at most an hour.
+func notificationBackoff(attempt uint64) time.Duration {
+	delay := time.Duration(max(config.NotificationBackoff, 1)) * time.Second
+	for i := uint64(1); i < attempt && delay < notificationMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, notificationMaxBackoff)
+}
+
+func deadLetterNotification(msg jetstream.Msg, meta *jetstream.MsgMetadata, cause error) {
+	log.Printf("error: notification %d dead-lettered after %d deliveries: %s", meta.Sequence.Stream, meta.NumDelivered, cause)
+
+	dead := nats.NewMsg(broker.NotificationDeadSubject)
+	dead.Header.Set(broker.DeadLetterHeaderError, cause.Error())
+	dead.Header.Set(broker.DeadLetterHeaderDeliveries, strconv.FormatUint(meta.NumDelivered, 10))
+	dead.Header.Set(broker.DeadLetterHeaderSequence, strconv.FormatUint(meta.Sequence.Stream, 10))
+	dead.Header.Set(broker.DeadLetterHeaderQueuedAt, meta.Timestamp.Format(time.RFC3339))
+	dead.Data = msg.Data()
+
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+	defer cancel()
+
+	if _, err := broker.JetStream.PublishMsg(ctx, dead); err != nil {
+		log.Printf("error: dead-letter notification %d: %s", meta.Sequence.Stream, err)
+		msg.NakWithDelay(notificationBackoff(meta.NumDelivered))
+		return
+	}
+
+	msg.TermWithReason(cause.Error())
+}
+
+// deadLetterSensitiveKeys are redacted from the listed notifications on top
+// of the audit ones: reset links and bodies that may hold them once
+// rendered. Retrying sends the original message.
+var deadLetterSensitiveKeys = []string{"url", "link", "body"}
+
+type DeadLetter struct {
+	Sequence   uint64          `json:"sequence"`
+	DeadAt     time.Time       `json:"dead_at"`
+	QueuedAt   string          `json:"queued_at"`
+	Deliveries int             `json:"deliveries"`
+	Error      string          `json:"error"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// GetNotificationQueue reports the state of the notification queue and of
+// its dead-letter stream.
+func GetNotificationQueue(c fiber.Ctx) error {
+	if broker.JetStream == nil {
+		return handlers.ServiceUnavailableErrorResponse(c, fmt.Errorf("jetstream is not available"))
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), notificationTimeout)
+	defer cancel()
+
+	queue, err := broker.JetStream.Stream(ctx, broker.NotificationStream)
+	if err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+	queueInfo, err := queue.Info(ctx)
+	if err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	dead, err := broker.JetStream.Stream(ctx, broker.NotificationDeadStream)
+	if err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+	deadInfo, err := dead.Info(ctx)
+	if err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	result := fiber.Map{
+		"queue": fiber.Map{
+			"messages": queueInfo.State.Msgs,
+			"bytes":    queueInfo.State.Bytes,
+		},
+		"dead_letter": fiber.Map{
+			"messages": deadInfo.State.Msgs,
+			"bytes":    deadInfo.State.Bytes,
+		},
+		"max_deliver": config.NotificationMaxDeliver,
+		"backoff":     config.NotificationBackoff,
+	}
+
+	var outboxPending int64
+	if err := models.CountPendingOutboxEvent(&outboxPending); err == nil {
+		result["outbox_pending"] = outboxPending
+	}
+
+	if consumer, err := queue.Consumer(ctx, broker.NotificationConsumer); err == nil {
+		if info, err := consumer.Info(ctx); err == nil {
+			result["consumer"] = fiber.Map{
+				"pending":     info.NumPending,
+				"ack_pending": info.NumAckPending,
+				"redelivered": info.NumRedelivered,
+			}
+		}
+	}
+
+	return handlers.SuccessResponse(c, true, "success to get notification queue", result, nil)
+}
+
+// GetAllDeadLetter lists the dead-lettered notifications from the ?from
+// sequence, at most ?limit of them.
+func GetAllDeadLetter(c fiber.Ctx) error {
+	from, _ := strconv.ParseUint(c.Query("from", "1"), 10, 64)
+	limit, _ := strconv.Atoi(c.Query("limit", strconv.Itoa(deadLetterDefaultLimit)))
+	if limit <= 0 || limit > deadLetterMaximumLimit {
+		limit = deadLetterDefaultLimit
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), notificationTimeout)
+	defer cancel()
+
+	stream, err := deadLetterStream(ctx)
+	if err != nil {
+		return handlers.ServiceUnavailableErrorResponse(c, err)
+	}
+
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	result := []DeadLetter{}
+	for seq := max(from, 1); len(result) < limit && seq <= info.State.LastSeq; {
+		msg, err := stream.GetMsg(ctx, seq, jetstream.WithGetMsgSubject(broker.NotificationDeadSubject))
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			break
+		}
+		if err != nil {
+			return handlers.InternalServerErrorResponse(c, err)
+		}
+		result = append(result, deadLetterOf(msg))
+		seq = msg.Sequence + 1
+	}
+
+	count := int64(info.State.Msgs)
+	return handlers.SuccessResponse(c, true, "success to get dead-lettered notification", result, &count)
+}
+
+// RetryDeadLetter queues a dead-lettered notification again with a fresh
+// attempt count.
+func RetryDeadLetter(c fiber.Ctx) error {
+	seq, err := strconv.ParseUint(c.Params("seq"), 10, 64)
+	if err != nil {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need seq params"))
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), notificationTimeout)
+	defer cancel()
+
+	stream, err := deadLetterStream(ctx)
+	if err != nil {
+		return handlers.ServiceUnavailableErrorResponse(c, err)
+	}
+
+	msg, err := stream.GetMsg(ctx, seq)
+	if errors.Is(err, jetstream.ErrMsgNotFound) {
+		return handlers.NotFoundErrorResponse(c, fmt.Errorf("dead-lettered notification is not found"))
+	}
+	if err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	if err := requeueDeadLetter(ctx, stream, msg); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditNotificationRetry, "notification", c.Params("seq"), nil, nil)
+
+	return handlers.SuccessResponse(c, true, "success to retry notification", nil, nil)
+}
+
+// RetryAllDeadLetter queues every dead-lettered notification again.
+func RetryAllDeadLetter(c fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.Context(), time.Minute)
+	defer cancel()
+
+	stream, err := deadLetterStream(ctx)
+	if err != nil {
+		return handlers.ServiceUnavailableErrorResponse(c, err)
+	}
+
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	var count int64
+	for seq := uint64(1); seq <= info.State.LastSeq; {
+		msg, err := stream.GetMsg(ctx, seq, jetstream.WithGetMsgSubject(broker.NotificationDeadSubject))
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			break
+		}
+		if err != nil {
+			return handlers.InternalServerErrorResponse(c, err)
+		}
+		if err := requeueDeadLetter(ctx, stream, msg); err != nil {
+			return handlers.InternalServerErrorResponse(c, err)
+		}
+		count++
+		seq = msg.Sequence + 1
+	}
+
+	RecordAudit(c, AuditNotificationRetry, "notification", "all", nil, fiber.Map{"count": count})
+
+	return handlers.SuccessResponse(c, true, "success to retry all notification", nil, &count)
+}
+
+func DeleteDeadLetter(c fiber.Ctx) error {
+	seq, err := strconv.ParseUint(c.Params("seq"), 10, 64)
+	if err != nil {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need seq params"))
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), notificationTimeout)
+	defer cancel()
+
+	stream, err := deadLetterStream(ctx)
+	if err != nil {
+		return handlers.ServiceUnavailableErrorResponse(c, err)
+	}
+
+	if err := stream.DeleteMsg(ctx, seq); err != nil {
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			return handlers.NotFoundErrorResponse(c, fmt.Errorf("dead-lettered notification is not found"))
+		}
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditNotificationDelete, "notification", c.Params("seq"), nil, nil)
+
+	return handlers.SuccessResponse(c, true, "success to delete notification", nil, nil)
+}
+
+func PurgeDeadLetter(c fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.Context(), notificationTimeout)
+	defer cancel()
+
+	stream, err := deadLetterStream(ctx)
+	if err != nil {
+		return handlers.ServiceUnavailableErrorResponse(c, err)
+	}
+
+	if err := stream.Purge(ctx); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditNotificationDelete, "notification", "all", nil, nil)
+
+	return handlers.SuccessResponse(c, true, "success to purge dead-lettered notification", nil, nil)
+}
+
+func deadLetterStream(ctx context.Context) (jetstream.Stream, error) {
+	if broker.JetStream == nil {
+		return nil, fmt.Errorf("jetstream is not available")
+	}
+	return broker.JetStream.Stream(ctx, broker.NotificationDeadStream)
+}
+
+func requeueDeadLetter(ctx context.Context, stream jetstream.Stream, msg *jetstream.RawStreamMsg) error {
+	if _, err := broker.JetStream.Publish(ctx, broker.NotificationSubject, msg.Data); err != nil {
+		return err
+	}
+	return stream.DeleteMsg(ctx, msg.Sequence)
+}
+
+func deadLetterOf(msg *jetstream.RawStreamMsg) DeadLetter {
+	deliveries, _ := strconv.Atoi(msg.Header.Get(broker.DeadLetterHeaderDeliveries))
+
+	return DeadLetter{
+		Sequence:   msg.Sequence,
+		DeadAt:     msg.Time,
+		QueuedAt:   msg.Header.Get(broker.DeadLetterHeaderQueuedAt),
+		Deliveries: deliveries,
+		Error:      msg.Header.Get(broker.DeadLetterHeaderError),
+		Data:       handlers.RedactJSON(msg.Data, deadLetterSensitiveKeys...),
+	}
+}
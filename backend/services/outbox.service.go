@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"go-gerbang/broker"
+	"go-gerbang/config"
+	"go-gerbang/models"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	outboxBatch      = 100
+	outboxMaxBackoff = 5 * time.Minute
+)
+
+// runOutboxRelay publishes the pending outbox events in order. Every event
+// carries its id as Nats-Msg-Id so JetStream drops the copy published again
+// after a crash between publishing and marking. It stops at the first
+// failure, later events wait for it to keep their order.
+func runOutboxRelay() error {
+	if broker.JetStream == nil {
+		return fmt.Errorf("jetstream is not available")
+	}
+
+	events := []models.OutboxEvent{}
+	if err := models.FindPendingOutboxEvent(&events, outboxBatch).Error; err != nil {
+		return err
+	}
+
+	for i := range events {
+		if err := relayOutboxEvent(&events[i]); err != nil {
+			attempts := events[i].Attempts + 1
+			next := time.Now().Add(outboxBackoff(attempts))
+			if err := models.MarkOutboxEventFailed(events[i].ID, attempts, err, next).Error; err != nil {
+				log.Printf("error: outbox event %d: %s", events[i].ID, err)
+			}
+			return fmt.Errorf("outbox event %d, attempt %d: %w", events[i].ID, attempts, err)
+		}
+	}
+
+	if config.OutboxRetentionDays > 0 && len(events) < outboxBatch {
+		before := time.Now().AddDate(0, 0, -config.OutboxRetentionDays)
+		if err := models.DeletePublishedOutboxEventBefore(before).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func relayOutboxEvent(event *models.OutboxEvent) error {
+	id := strconv.FormatUint(event.ID, 10)
+
+	if len(event.Notification) > 0 {
+		if err := QueueNotification(json.RawMessage(event.Notification), jetstream.WithMsgID("outbox-"+id)); err != nil {
+			return err
+		}
+		if err := models.MarkOutboxEventQueued(event.ID).Error; err != nil {
+			return err
+		}
+	}
+
+	msg := nats.NewMsg(event.Event)
+	msg.Header.Set(jetstream.MsgIDHeader, "outbox-"+id)
+	msg.Data = event.Payload
+
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+	defer cancel()
+
+	if _, err := broker.JetStream.PublishMsg(ctx, msg); err != nil {
+		return err
+	}
+
+	return models.MarkOutboxEventPublished(event.ID).Error
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	delay := time.Second
+	for i := 1; i < attempts && delay < outboxMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxBackoff)
+}
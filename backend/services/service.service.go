@@ -0,0 +1,261 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"go-gerbang/config"
+	"go-gerbang/handlers"
+	"go-gerbang/models"
+	"go-gerbang/proxyroute"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	AuditServiceCreate = "service.create"
+	AuditServiceUpdate = "service.update"
+	AuditServiceDelete = "service.delete"
+	AuditServiceImport = "service.import"
+)
+
+// applyServiceConfig stores cfg in the configured source as a new config
+// version. With the database source the services table is replaced and
+// every instance reloads its routes, the file source is picked up by the
+// file watcher.
+func applyServiceConfig(cfg *types.ConfigServices, version *models.ConfigVersion) error {
+	if !config.ServicesFromDatabase() {
+		if err := handlers.SaveConfigVersion(config.BasePath+config.ConfigPath, cfg, version); err != nil {
+			return err
+		}
+		broadcastConfigReload(version.Version)
+		return nil
+	}
+
+	rows := make([]models.Service, 0, len(cfg.Services))
+	for _, service := range cfg.Services {
+		rows = append(rows, models.ServiceFromType(service))
+	}
+
+	if err := models.ReplaceServices(rows); err != nil {
+		return err
+	}
+
+	return afterServiceChange(version)
+}
+
+// afterServiceChange records the services table as a new version and
+// broadcasts the reload. With the file source the table is not active and
+// nothing happens.
+func afterServiceChange(version *models.ConfigVersion) error {
+	if !config.ServicesFromDatabase() {
+		return nil
+	}
+
+	cfg, err := models.LoadServiceConfig()
+	if err != nil {
+		return err
+	}
+
+	if err := handlers.RecordConfigVersion(cfg, version); err != nil {
+		log.Printf("error: record config version: %s", err)
+	}
+
+	return proxyroute.PublishServiceReload()
+}
+
+// validateServiceChange validates the services table as it would be after
+// replacing the row with idService (empty for a new row) by service.
+func validateServiceChange(idService string, service *types.Service) ([]handlers.ConfigIssue, error) {
+	rows := []models.Service{}
+	if err := models.FindService(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	cfg := &types.ConfigServices{Services: []types.Service{}}
+	for i := range rows {
+		if rows[i].IdService.String() == idService {
+			continue
+		}
+		cfg.Services = append(cfg.Services, rows[i].ToType())
+	}
+	if service != nil {
+		cfg.Services = append(cfg.Services, *service)
+	}
+
+	issues := handlers.ValidateConfig(cfg)
+	if len(handlers.ConfigIssueErrors(issues)) > 0 {
+		return issues, &handlers.ConfigValidationError{Issues: issues}
+	}
+
+	return issues, nil
+}
+
+func GetAllService(c fiber.Ctx) error {
+	var count int64
+
+	d := &[]models.Service{}
+	if err := models.FindService(d).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	if err := models.CountFindService(&count); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	return handlers.SuccessResponse(c, true, "success to get all service", d, &count)
+}
+
+func GetServiceById(c fiber.Ctx) error {
+	service := new(models.Service)
+	if err := models.FindServiceById(service, c.Params("id")); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	return handlers.SuccessResponse(c, true, "success to get service", service, nil)
+}
+
+func CreateService(c fiber.Ctx) error {
+	u := new(models.Service)
+
+	if err := handlers.ParseBody(c, u); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	if err := handlers.ValidateStruct(*u); err != nil {
+		return handlers.SuccessResponse(c, false, "error validation service", err, nil)
+	}
+
+	service := u.ToType()
+	if issues, err := validateServiceChange("", &service); err != nil {
+		return configValidationResponse(c, issues, err)
+	}
+
+	if user, ok := c.Locals("user").(*models.UserData); ok {
+		u.CreatedBy = user.Username
+	}
+
+	if err := models.CreateService(u).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditServiceCreate, "service", u.Path, nil, u)
+
+	if err := afterServiceChange(newConfigVersion(c, models.ConfigVersionSourceApi, "create service "+u.Path)); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	return handlers.SuccessResponse(c, true, "success to create service", u, nil)
+}
+
+func UpdateService(c fiber.Ctx) error {
+	idService := c.Params("id")
+
+	before := new(models.Service)
+	if err := models.FindServiceById(before, idService); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	u := new(models.Service)
+
+	if err := handlers.ParseBody(c, u); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	if err := handlers.ValidateStruct(*u); err != nil {
+		return handlers.SuccessResponse(c, false, "error validation service", err, nil)
+	}
+
+	service := u.ToType()
+	if issues, err := validateServiceChange(idService, &service); err != nil {
+		return configValidationResponse(c, issues, err)
+	}
+
+	if err := models.UpdateService(idService, u).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditServiceUpdate, "service", u.Path, before, u)
+
+	if err := afterServiceChange(newConfigVersion(c, models.ConfigVersionSourceApi, "update service "+u.Path)); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	return handlers.SuccessResponse(c, true, "success to update service", nil, nil)
+}
+
+func DeleteService(c fiber.Ctx) error {
+	idService := c.Params("id")
+
+	before := new(models.Service)
+	if err := models.FindServiceById(before, idService); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	if err := models.DeleteService(idService).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditServiceDelete, "service", before.Path, before, nil)
+
+	if err := afterServiceChange(newConfigVersion(c, models.ConfigVersionSourceApi, "delete service "+before.Path)); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	return handlers.SuccessResponse(c, true, "success to delete service", nil, nil)
+}
+
+// ImportServiceConfig copies the services of CONFIG_PATH_JSON into the
+// services table. It only runs on an empty table unless ?force=true.
+func ImportServiceConfig(c fiber.Ctx) error {
+	var count int64
+	if err := models.CountFindService(&count); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	if count > 0 && !fiber.Query[bool](c, "force") {
+		return handlers.ConflictErrorResponse(c, fmt.Errorf("services table already has %d services, use force=true to replace them", count))
+	}
+
+	cfg, err := handlers.LoadConfig(config.BasePath + config.ConfigPath)
+	if err != nil {
+		var validationErr *handlers.ConfigValidationError
+		if errors.As(err, &validationErr) {
+			return configValidationResponse(c, validationErr.Issues, validationErr)
+		}
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	rows := make([]models.Service, 0, len(cfg.Services))
+	for _, service := range cfg.Services {
+		row := models.ServiceFromType(service)
+		if user, ok := c.Locals("user").(*models.UserData); ok {
+			row.CreatedBy = user.Username
+		}
+		rows = append(rows, row)
+	}
+
+	if err := models.ReplaceServices(rows); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditServiceImport, "service", config.ConfigPath, nil, cfg)
+
+	if err := afterServiceChange(newConfigVersion(c, models.ConfigVersionSourceApi, "import "+config.ConfigPath)); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	total := int64(len(rows))
+	return handlers.SuccessResponse(c, true, "success to import "+strconv.Itoa(len(rows))+" services", rows, &total)
+}
+
+// GetServiceConcurrency reports the concurrency limiters of this instance.
+func GetServiceConcurrency(c fiber.Ctx) error {
+	stats := proxyroute.GetConcurrencyStats()
+
+	count := int64(len(stats))
+	return handlers.SuccessResponse(c, true, "success to get service concurrency", stats, &count)
+}
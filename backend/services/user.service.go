@@ -63,10 +63,12 @@ func CreateUser(c fiber.Ctx) error {
 		return handlers.SuccessResponse(c, false, "error validation user", err, nil)
 	}
 
-	if err := models.CreateUser(u).Error; err != nil {
+	if err := models.CreateUserWithEvent(u, nil); err != nil {
 		return handlers.InternalServerErrorResponse(c, err)
 	}
 
+	RecordAudit(c, AuditUserCreate, "user", u.IdAccount.String(), nil, u)
+
 	return handlers.SuccessResponse(c, true, "success to create user", u, nil)
 }
 
@@ -87,10 +89,19 @@ func UpdateUser(c fiber.Ctx) error {
 		return handlers.SuccessResponse(c, false, "error validation user", err, nil)
 	}
 
-	if err := models.UpdateUser(userId, u).Error; err != nil {
+	before := new(models.User)
+	if err := models.FindUserById(before, userId); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	if err := models.UpdateUserWithEvent(userId, u); err != nil {
 		return handlers.InternalServerErrorResponse(c, err)
 	}
 
+	after := new(models.User)
+	models.FindUserById(after, userId)
+	RecordAudit(c, AuditUserUpdate, "user", userId, before, after)
+
 	return handlers.SuccessResponse(c, true, "success to update user", nil, nil)
 }
 
@@ -101,10 +112,15 @@ func DeleteUser(c fiber.Ctx) error {
 		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need userId params"))
 	}
 
+	before := new(models.User)
+	models.FindUserById(before, userId)
+
 	if err := models.HardDeleteUser(userId); err != nil {
 		return handlers.NotFoundErrorResponse(c, err)
 	}
 
+	RecordAudit(c, AuditUserDelete, "user", userId, before, nil)
+
 	return handlers.SuccessResponse(c, true, "success to delete user", nil, nil)
 }
 
@@ -113,7 +129,6 @@ func SendUserInformation(c fiber.Ctx) error {
 	providerNotification := c.Query("provider")
 	querySender := c.Query("sender")
 	sendPass := fiber.Query[bool](c, "sendPass")
-	password := c.Query("password")
 
 	if userId == "" {
 		return handlers.InternalServerErrorResponse(c, fmt.Errorf("user Id cannot be null"))
@@ -125,9 +140,18 @@ func SendUserInformation(c fiber.Ctx) error {
 			return handlers.NotFoundErrorResponse(c, err)
 		}
 
-		user.Password = password
+		setPasswordUrl := ""
+		if sendPass {
+			var err error
+			if setPasswordUrl, err = newSetPasswordUrl(c, user); err != nil {
+				return handlers.UnprocessableEntityErrorResponse(c, err)
+			}
+			if err := models.CeneratePasswordResetToken(user.IdAccount, *user.PasswordResetToken).Error; err != nil {
+				return handlers.InternalServerErrorResponse(c, err)
+			}
+		}
 
-		QueueUserInformation(providerNotification, querySender, user, sendPass)
+		QueueUserInformation(providerNotification, querySender, c.Query("locale"), user, setPasswordUrl)
 		// fmt.Println(providerNotification)
 		// fmt.Println(querySender)
 		// fmt.Println(sendPass)
@@ -167,10 +191,12 @@ func CreateUserAssignment(c fiber.Ctx) error {
 		return handlers.SuccessResponse(c, false, "error validation user assignment", err, nil)
 	}
 
-	if err := models.CreateUserAssignment(u).Error; err != nil {
+	if err := models.SaveUserAssignmentsWithEvent([]models.UserAssignment{*u}, true); err != nil {
 		return handlers.InternalServerErrorResponse(c, err)
 	}
 
+	RecordAudit(c, AuditUserAssignmentCreate, "user_assignment", u.AccountId, nil, u)
+
 	return handlers.SuccessResponse(c, true, "success to create user assignment", nil, nil)
 }
 
@@ -187,10 +213,14 @@ func CreateUserAssignmentsBulk(c fiber.Ctx) error {
 		}
 	}
 
-	if err := models.CreateUserAssignments(*assignments).Error; err != nil {
+	if err := models.SaveUserAssignmentsWithEvent(*assignments, true); err != nil {
 		return handlers.InternalServerErrorResponse(c, err)
 	}
 
+	for _, a := range *assignments {
+		RecordAudit(c, AuditUserAssignmentCreate, "user_assignment", a.AccountId, nil, a)
+	}
+
 	return handlers.SuccessResponse(c, true, "success to bulk create user assignments", nil, nil)
 }
 
@@ -205,10 +235,12 @@ func UpdateUserAssignment(c fiber.Ctx) error {
 		return handlers.SuccessResponse(c, false, "error validation user assignment", err, nil)
 	}
 
-	if err := models.UpdateUserAssignment(u).Error; err != nil {
+	if err := models.SaveUserAssignmentsWithEvent([]models.UserAssignment{*u}, false); err != nil {
 		return handlers.InternalServerErrorResponse(c, err)
 	}
 
+	RecordAudit(c, AuditUserAssignmentUpdate, "user_assignment", u.AccountId, nil, u)
+
 	return handlers.SuccessResponse(c, true, "success to update user assignment", nil, nil)
 }
 
@@ -225,10 +257,14 @@ func UpdateUserAssignmentsBulk(c fiber.Ctx) error {
 		}
 	}
 
-	if err := models.UpdateUserAssignments(*assignments); err != nil {
+	if err := models.SaveUserAssignmentsWithEvent(*assignments, false); err != nil {
 		return handlers.InternalServerErrorResponse(c, err)
 	}
 
+	for _, a := range *assignments {
+		RecordAudit(c, AuditUserAssignmentUpdate, "user_assignment", a.AccountId, nil, a)
+	}
+
 	return handlers.SuccessResponse(c, true, "success to bulk update user assignments", nil, nil)
 }
 
@@ -244,5 +280,7 @@ func DeleteUserAssignment(c fiber.Ctx) error {
 		return handlers.NotFoundErrorResponse(c, err)
 	}
 
+	RecordAudit(c, AuditUserAssignmentDelete, "user_assignment", accountId, models.UserAssignment{AccountId: accountId, AuthRoleId: authRoleId}, nil)
+
 	return handlers.SuccessResponse(c, true, "success to delete user assignment", nil, nil)
 }
@@ -0,0 +1,474 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-gerbang/broker"
+	"go-gerbang/config"
+	"go-gerbang/handlers"
+	"go-gerbang/middleware"
+	"go-gerbang/models"
+	"go-gerbang/types"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"gorm.io/datatypes"
+)
+
+const (
+	AuditWebhookCreate = "webhook.create"
+	AuditWebhookUpdate = "webhook.update"
+	AuditWebhookDelete = "webhook.delete"
+
+	EventWebhookTest = "webhook.test"
+
+	webhookAckWait      = time.Minute
+	webhookMaxBackoff   = 6 * time.Hour
+	webhookResponseSize = 1024
+)
+
+// Headers of a delivery sent to a webhook.
+const (
+	HeaderWebhookEvent     = "X-Gateway-Event"
+	HeaderWebhookEventId   = "X-Gateway-Event-Id"
+	HeaderWebhookAttempt   = "X-Gateway-Attempt"
+	HeaderWebhookSignature = "X-Gateway-Signature"
+)
+
+var webhookClient = &http.Client{
+	Timeout: time.Duration(max(config.WebhookTimeout, 1)) * time.Second,
+	// a redirect is reported as a failed delivery, the signature is not
+	// forwarded to another host
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// StartWebhookConsumers dispatches the user and audit events to the
+// matching webhooks and sends the queued deliveries. Only events published
+// after the dispatcher was created are sent.
+func StartWebhookConsumers() error {
+	if broker.JetStream == nil {
+		return fmt.Errorf("jetstream is not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+	defer cancel()
+
+	for _, stream := range []string{broker.UserEventStream, broker.AuditEventStream} {
+		dispatcher, err := broker.JetStream.CreateOrUpdateConsumer(ctx, stream, jetstream.ConsumerConfig{
+			Durable:       broker.WebhookDispatcher,
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			DeliverPolicy: jetstream.DeliverNewPolicy,
+			MaxAckPending: 1,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := dispatcher.Consume(dispatchWebhookEvent); err != nil {
+			return err
+		}
+	}
+
+	// the attempts are counted here, see processNotification
+	sender, err := broker.JetStream.CreateOrUpdateConsumer(ctx, broker.WebhookStream, jetstream.ConsumerConfig{
+		Durable:       broker.WebhookConsumer,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       webhookAckWait,
+		MaxDeliver:    -1,
+		MaxAckPending: 32,
+		FilterSubject: broker.WebhookSubject,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = sender.Consume(processWebhookDelivery)
+	return err
+}
+
+// dispatchWebhookEvent queues one delivery per webhook listening to the
+// event. The delivery id is derived from the event so a redispatched event
+// is dropped by the stream.
+func dispatchWebhookEvent(msg jetstream.Msg) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		log.Printf("error: webhook event metadata: %s", err)
+		msg.Nak()
+		return
+	}
+
+	eventId := msg.Headers().Get(jetstream.MsgIDHeader)
+	if eventId == "" {
+		eventId = meta.Stream + "-" + strconv.FormatUint(meta.Sequence.Stream, 10)
+	}
+
+	webhooks := []models.Webhook{}
+	if err := models.FindWebhook(&webhooks, "disabled = ?", false).Error; err != nil {
+		log.Printf("error: webhook event %s: %s", eventId, err)
+		msg.NakWithDelay(webhookBackoff(1))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+	defer cancel()
+
+	for _, webhook := range webhooks {
+		if !webhookListens(webhook.Events, msg.Subject()) {
+			continue
+		}
+
+		delivery := nats.NewMsg(broker.WebhookSubject)
+		delivery.Header.Set(jetstream.MsgIDHeader, eventId+"."+webhook.IdWebhook.String())
+		delivery.Header.Set(broker.WebhookHeaderId, webhook.IdWebhook.String())
+		delivery.Header.Set(broker.WebhookHeaderEvent, msg.Subject())
+		delivery.Header.Set(broker.WebhookHeaderEventId, eventId)
+		delivery.Data = msg.Data()
+
+		if _, err := broker.JetStream.PublishMsg(ctx, delivery); err != nil {
+			log.Printf("error: queue webhook %s for event %s: %s", webhook.IdWebhook, eventId, err)
+			msg.NakWithDelay(webhookBackoff(1))
+			return
+		}
+	}
+
+	msg.Ack()
+}
+
+func processWebhookDelivery(msg jetstream.Msg) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		log.Printf("error: webhook delivery metadata: %s", err)
+		msg.Nak()
+		return
+	}
+
+	headers := msg.Headers()
+	webhook := new(models.Webhook)
+	if err := models.FindWebhookById(webhook, headers.Get(broker.WebhookHeaderId)); err != nil {
+		msg.TermWithReason(err.Error())
+		return
+	}
+	if webhook.Disabled {
+		msg.TermWithReason("webhook is disabled")
+		return
+	}
+
+	delivery := sendWebhook(webhook, headers.Get(broker.WebhookHeaderEvent), headers.Get(broker.WebhookHeaderEventId), msg.Data(), int(meta.NumDelivered), false)
+
+	maxAttempts := uint64(max(config.WebhookMaxAttempts, 1))
+	switch {
+	case delivery.Success:
+		msg.Ack()
+	case meta.NumDelivered >= maxAttempts:
+		log.Printf("error: webhook %s gave up event %s after %d attempts: %s", webhook.IdWebhook, delivery.EventId, meta.NumDelivered, delivery.Error)
+		msg.TermWithReason(delivery.Error)
+	default:
+		msg.NakWithDelay(webhookBackoff(meta.NumDelivered))
+	}
+}
+
+// sendWebhook posts one event to the webhook and logs the attempt, any
+// status other than 2xx is a failure.
+func sendWebhook(webhook *models.Webhook, event string, eventId string, body []byte, attempt int, test bool) *models.WebhookDelivery {
+	delivery := &models.WebhookDelivery{
+		WebhookId: webhook.IdWebhook.String(),
+		Event:     event,
+		EventId:   eventId,
+		Payload:   body,
+		Attempt:   attempt,
+		Test:      test,
+		CreatedAt: time.Now(),
+	}
+
+	req, err := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err == nil {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set(fiber.HeaderUserAgent, "go-gerbang-webhook")
+		req.Header.Set(HeaderWebhookEvent, event)
+		req.Header.Set(HeaderWebhookEventId, eventId)
+		req.Header.Set(HeaderWebhookAttempt, strconv.Itoa(attempt))
+		req.Header.Set(HeaderWebhookSignature, handlers.SignWebhook(webhook.Secret, delivery.CreatedAt.Unix(), body))
+
+		var resp *http.Response
+		resp, err = webhookClient.Do(req)
+		if err == nil {
+			response, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseSize))
+			resp.Body.Close()
+
+			delivery.StatusCode = resp.StatusCode
+			delivery.Response = strings.ToValidUTF8(string(response), "")
+			delivery.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+			if !delivery.Success {
+				err = fmt.Errorf("webhook responded %s", resp.Status)
+			}
+		}
+	}
+	delivery.DurationMs = time.Since(delivery.CreatedAt).Milliseconds()
+
+	if err != nil {
+		delivery.Error = err.Error()
+		if len(delivery.Error) > 512 {
+			delivery.Error = delivery.Error[:512]
+		}
+	}
+
+	if err := models.CreateWebhookDelivery(delivery).Error; err != nil {
+		log.Printf("error: log webhook %s delivery of %s: %s", webhook.IdWebhook, eventId, err)
+	}
+
+	return delivery
+}
+
+// webhookBackoff is WEBHOOK_BACKOFF doubled for every failed attempt, at
+// most six hours.
+func webhookBackoff(attempt uint64) time.Duration {
+	delay := time.Duration(max(config.WebhookBackoff, 1)) * time.Second
+	for i := uint64(1); i < attempt && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookMaxBackoff)
+}
+
+func webhookListens(filters []string, subject string) bool {
+	for _, filter := range filters {
+		if middleware.SubjectCovers(filter, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateWebhookEvents accepts the filters matching at least one user or
+// audit event.
+func validateWebhookEvents(filters []string) error {
+	known := append([]string{broker.AuditEventSubjects}, types.UserEvents...)
+
+	for _, filter := range filters {
+		if !middleware.ValidSubject(filter, true) {
+			return fmt.Errorf("invalid event filter %q", filter)
+		}
+
+		matched := false
+		for _, event := range known {
+			if middleware.SubjectsCollide(filter, event) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("event filter %q matches no event", filter)
+		}
+	}
+
+	return nil
+}
+
+func validateWebhookUrl(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("webhook url must be an http or https url")
+	}
+	return nil
+}
+
+func GetAllWebhook(c fiber.Ctx) error {
+	var count int64
+
+	d := &[]models.Webhook{}
+	if err := models.FindWebhook(d).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+	if err := models.CountFindWebhook(&count); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	return handlers.SuccessResponse(c, true, "success to get all webhook", d, &count)
+}
+
+func GetWebhookById(c fiber.Ctx) error {
+	webhook := new(models.Webhook)
+	if err := models.FindWebhookById(webhook, c.Params("id")); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	return handlers.SuccessResponse(c, true, "success to get webhook", webhook, nil)
+}
+
+func CreateWebhook(c fiber.Ctx) error {
+	input := new(types.WebhookInput)
+
+	if err := handlers.ParseBody(c, input); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	if err := handlers.ValidateStruct(*input); err != nil {
+		return handlers.SuccessResponse(c, false, "error validation webhook", err, nil)
+	}
+	if err := validateWebhookUrl(input.Url); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+	if err := validateWebhookEvents(input.Events); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	secret := input.Secret
+	if secret == "" {
+		secret = handlers.GenerateWebhookSecret()
+	}
+
+	webhook := &models.Webhook{
+		Name:     input.Name,
+		Url:      input.Url,
+		Events:   datatypes.NewJSONSlice(input.Events),
+		Secret:   secret,
+		Disabled: input.Disabled,
+	}
+
+	if user, ok := c.Locals("user").(*models.UserData); ok {
+		webhook.CreatedBy = user.Username
+	}
+
+	if err := models.CreateWebhook(webhook).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditWebhookCreate, "webhook", webhook.IdWebhook.String(), nil, webhook)
+
+	res := fiber.Map{
+		"item":   webhook,
+		"secret": secret,
+	}
+
+	return handlers.SuccessResponse(c, true, "success to create webhook, the secret is only shown once", res, nil)
+}
+
+func UpdateWebhook(c fiber.Ctx) error {
+	idWebhook := c.Params("id")
+
+	if idWebhook == "" {
+		return handlers.UnprocessableEntityErrorResponse(c, fmt.Errorf("need id params"))
+	}
+
+	input := new(types.WebhookInput)
+
+	if err := handlers.ParseBody(c, input); err != nil {
+		return handlers.BadRequestErrorResponse(c, err)
+	}
+
+	before := new(models.Webhook)
+	if err := models.FindWebhookById(before, idWebhook); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	data := map[string]interface{}{
+		"disabled": input.Disabled,
+	}
+	if input.Name != "" {
+		data["name"] = input.Name
+	}
+	if input.Url != "" {
+		if err := validateWebhookUrl(input.Url); err != nil {
+			return handlers.BadRequestErrorResponse(c, err)
+		}
+		data["url"] = input.Url
+	}
+	if len(input.Events) > 0 {
+		if err := validateWebhookEvents(input.Events); err != nil {
+			return handlers.BadRequestErrorResponse(c, err)
+		}
+		data["events"] = datatypes.NewJSONSlice(input.Events)
+	}
+	if input.Secret != "" {
+		data["secret"] = input.Secret
+	}
+
+	if err := models.UpdateWebhook(idWebhook, data).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	after := new(models.Webhook)
+	if err := models.FindWebhookById(after, idWebhook); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditWebhookUpdate, "webhook", idWebhook, before, after)
+
+	return handlers.SuccessResponse(c, true, "success to update webhook", after, nil)
+}
+
+func DeleteWebhook(c fiber.Ctx) error {
+	idWebhook := c.Params("id")
+
+	before := new(models.Webhook)
+	if err := models.FindWebhookById(before, idWebhook); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	if _, err := models.DeleteWebhook(idWebhook); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	RecordAudit(c, AuditWebhookDelete, "webhook", idWebhook, before, nil)
+
+	return handlers.SuccessResponse(c, true, "success to delete webhook", nil, nil)
+}
+
+// TestWebhook sends a webhook.test event right away, disabled webhooks
+// included, and returns the logged attempt.
+func TestWebhook(c fiber.Ctx) error {
+	webhook := new(models.Webhook)
+	if err := models.FindWebhookById(webhook, c.Params("id")); err != nil {
+		return handlers.NotFoundErrorResponse(c, err)
+	}
+
+	eventId := "test-" + handlers.RandomStringV1(12)
+	body, err := json.Marshal(fiber.Map{
+		"event":      EventWebhookTest,
+		"idWebhook":  webhook.IdWebhook,
+		"occurredAt": time.Now(),
+	})
+	if err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	delivery := sendWebhook(webhook, EventWebhookTest, eventId, body, 1, true)
+	if !delivery.Success {
+		return handlers.SuccessResponse(c, false, "webhook test failed: "+delivery.Error, delivery, nil)
+	}
+
+	return handlers.SuccessResponse(c, true, "success to test webhook", delivery, nil)
+}
+
+func GetAllWebhookDelivery(c fiber.Ctx) error {
+	webhookId := c.Params("id")
+
+	limit := fiber.Query[int](c, "limit")
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	offset := max(fiber.Query[int](c, "offset"), 0)
+
+	var count int64
+	if err := models.CountFindWebhookDelivery(&count, webhookId); err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	d := &[]models.WebhookDelivery{}
+	if err := models.FindWebhookDelivery(d, webhookId, limit, offset).Error; err != nil {
+		return handlers.InternalServerErrorResponse(c, err)
+	}
+
+	return handlers.SuccessResponse(c, true, "success to get webhook deliveries", d, &count)
+}
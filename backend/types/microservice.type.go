@@ -1,17 +1,187 @@
 package types
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Service struct {
-	Service           string `json:"service"`
-	Path              string `json:"path"`
-	Url               string `json:"url"`
-	AuthProtection    bool   `json:"auth_protection"`
-	SessionProtection bool   `json:"session_protection"`
-	CsrfProtection    bool   `json:"csrf_protection"`
-	RbacProtection    bool   `json:"rbac_protection"`
-	Status            bool   `json:"status"`
+	Service           string          `json:"service"`
+	Path              string          `json:"path"`
+	Url               string          `json:"url"`
+	Type              string          `json:"type,omitempty"` // "http" (default), "grpc" or "nats"
+	AuthProtection    bool            `json:"auth_protection"`
+	AuthMode          string          `json:"auth_mode,omitempty"` // "jwt" (default), "api_key" or "jwt_or_api_key"
+	SessionProtection bool            `json:"session_protection"`
+	CsrfProtection    bool            `json:"csrf_protection"`
+	RbacProtection    bool            `json:"rbac_protection"`
+	MtlsProtection    bool            `json:"mtls_protection"`
+	Status            bool            `json:"status"`
+	UpstreamTLS       *UpstreamTLS    `json:"upstream_tls,omitempty"`
+	RateLimits        []RateLimitRule `json:"rate_limits,omitempty"`
+	Concurrency       *Concurrency    `json:"concurrency,omitempty"`
+	Cache             *Cache          `json:"cache,omitempty"`
+	Coalesce          bool            `json:"coalesce,omitempty"` // share one upstream call between identical concurrent GETs
+	Headers           *HeaderPolicy   `json:"headers,omitempty"`
+	BodyTransform     *BodyTransforms `json:"body_transform,omitempty"`
+	OpenAPI           *OpenAPI        `json:"openapi,omitempty"`
+	Nats              *NatsTarget     `json:"nats,omitempty"`
 	// JwtProtection     bool   `json:"jwt_protection"`
 }
 
+const (
+	ServiceTypeHttp = "http"
+	ServiceTypeGrpc = "grpc"
+	ServiceTypeNats = "nats"
+)
+
+const (
+	AuthModeJWT         = "jwt"
+	AuthModeApiKey      = "api_key"
+	AuthModeJWTOrApiKey = "jwt_or_api_key"
+)
+
+// UpstreamTLS configures the TLS client used by the gateway towards the
+// upstream of a service, CertFile and KeyFile enable mTLS.
+type UpstreamTLS struct {
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	CAFile             string `json:"ca_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// RateLimitRule allows Limit requests per Window seconds for each key of By.
+// Rules are counted in Redis so every instance shares the same budget.
+type RateLimitRule struct {
+	Name      string   `json:"name,omitempty"`
+	By        string   `json:"by"`                  // "ip", "user", "api_key" or "route"
+	Limit     int      `json:"limit"`               // requests per window
+	Window    int      `json:"window"`              // seconds
+	Algorithm string   `json:"algorithm,omitempty"` // "sliding_window" (default) or "token_bucket"
+	Burst     int      `json:"burst,omitempty"`     // token bucket capacity, defaults to limit
+	Methods   []string `json:"methods,omitempty"`   // empty applies to every method
+}
+
+const (
+	RateLimitByIp     = "ip"
+	RateLimitByUser   = "user"
+	RateLimitByApiKey = "api_key"
+	RateLimitByRoute  = "route"
+
+	RateLimitSlidingWindow = "sliding_window"
+	RateLimitTokenBucket   = "token_bucket"
+)
+
+// Concurrency caps the requests in flight to the upstream of a service on
+// each instance. Requests over the limit wait in a bounded queue, with
+// Adaptive the limit moves between MinInFlight and MaxInFlight following
+// the upstream latency.
+type Concurrency struct {
+	MaxInFlight   int    `json:"max_in_flight"`
+	MinInFlight   int    `json:"min_in_flight,omitempty"`  // adaptive floor, defaults to 1
+	QueueSize     int    `json:"queue_size,omitempty"`     // 0 rejects at once
+	QueueTimeout  int    `json:"queue_timeout,omitempty"`  // milliseconds, defaults to 1000
+	Adaptive      string `json:"adaptive,omitempty"`       // "", "aimd" or "gradient"
+	LatencyTarget int    `json:"latency_target,omitempty"` // milliseconds, aimd backs off above it
+}
+
+const (
+	ConcurrencyAIMD     = "aimd"
+	ConcurrencyGradient = "gradient"
+)
+
+// Cache stores GET responses of a service in Redis for TTL seconds, then
+// serves them stale for StaleWhileRevalidate seconds while one instance
+// refreshes the entry. Cache-Control of the request and of the response
+// is honored, max-age and s-maxage override TTL.
+type Cache struct {
+	TTL                  int      `json:"ttl"`                              // seconds
+	StaleWhileRevalidate int      `json:"stale_while_revalidate,omitempty"` // seconds
+	Paths                []string `json:"paths,omitempty"`                  // path prefixes, empty caches the whole service
+	Tags                 []string `json:"tags,omitempty"`                   // purge tags added to every entry
+	MaxBodySize          int      `json:"max_body_size,omitempty"`          // bytes, defaults to 1MB
+	Key                  CacheKey `json:"key"`
+}
+
+// CacheKey selects what the cache key varies on besides the path. Services
+// behind auth must vary by user or role so entries are never shared between
+// callers with different access.
+type CacheKey struct {
+	IgnoreQuery bool     `json:"ignore_query,omitempty"`
+	Headers     []string `json:"headers,omitempty"`
+	User        bool     `json:"user,omitempty"`
+	Role        bool     `json:"role,omitempty"`
+}
+
+// HeaderPolicy rewrites the headers sent to the upstream and the headers
+// returned to the client. Values may use ${user.id}, ${user.username},
+// ${user.email}, ${request.id}, ${request.ip}, ${request.method},
+// ${request.path} and ${service}. Hop-by-hop headers are always dropped,
+// the gateway cookies (__SG*) are dropped from upstream requests unless
+// KeepGatewayCookies is set.
+type HeaderPolicy struct {
+	Request            *HeaderRules `json:"request,omitempty"`
+	Response           *HeaderRules `json:"response,omitempty"`
+	KeepGatewayCookies bool         `json:"keep_gateway_cookies,omitempty"`
+}
+
+// HeaderTemplateVariables are the names usable as ${name} in header values.
+var HeaderTemplateVariables = []string{
+	"user.id", "user.username", "user.email",
+	"request.id", "request.ip", "request.method", "request.path",
+	"service",
+}
+
+// HeaderRules are applied in the order remove, rename, set, add.
+type HeaderRules struct {
+	Remove []string          `json:"remove,omitempty"`
+	Rename map[string]string `json:"rename,omitempty"` // old name: new name
+	Set    map[string]string `json:"set,omitempty"`
+	Add    map[string]string `json:"add,omitempty"`
+}
+
+// BodyTransforms rewrite JSON bodies between the client and the upstream,
+// other content types pass through.
+type BodyTransforms struct {
+	Request  *BodyTransform `json:"request,omitempty"`
+	Response *BodyTransform `json:"response,omitempty"`
+}
+
+// BodyTransform is applied in the order allow, deny, rename, mask,
+// envelope. Paths are JSONPath, see handlers.ParseJSONPath.
+type BodyTransform struct {
+	Allow    []string          `json:"allow,omitempty"`    // keep only these paths
+	Deny     []string          `json:"deny,omitempty"`     // remove these paths
+	Rename   map[string]string `json:"rename,omitempty"`   // path: new field name
+	Mask     []FieldMask       `json:"mask,omitempty"`     // replace values by role
+	Envelope bool              `json:"envelope,omitempty"` // wrap 2xx responses like handlers.SuccessStruct
+}
+
+// FieldMask replaces the values at Paths with Replacement unless the user
+// has one of the Roles (auth role ids).
+type FieldMask struct {
+	Paths       []string `json:"paths"`
+	Roles       []int    `json:"roles,omitempty"`
+	Replacement string   `json:"replacement,omitempty"` // defaults to "***"
+}
+
+// OpenAPI attaches an OpenAPI 3 document to a service. Paths in the
+// document are the upstream paths, without the service path prefix.
+type OpenAPI struct {
+	Spec              string `json:"spec"`                          // file under BASE_PATH or http(s) url
+	Validate          bool   `json:"validate,omitempty"`            // reject requests that do not match the spec
+	AllowUnknownPaths bool   `json:"allow_unknown_paths,omitempty"` // let requests the spec does not describe through
+	SkipBody          bool   `json:"skip_body,omitempty"`           // do not validate request bodies
+}
+
+// NatsTarget sends the requests of a nats service to Subject with
+// request/reply, for workers that serve an API without an HTTP server.
+type NatsTarget struct {
+	Subject string `json:"subject"`
+	Timeout int    `json:"timeout,omitempty"` // milliseconds, defaults to 5000
+}
+
 type ConfigServices struct {
 	Services []Service `json:"services"`
 }
@@ -51,15 +221,89 @@ type ListEmail struct {
 	TypeBatchAddress string  `json:"type_batch_address"` // "all" or "single" default "single"
 }
 
+// SendingEmailToBroker is a queued email. With Template the email is
+// rendered from the template of the sender with Variables, otherwise Body
+// is sent as raw html. An empty provider is the one configured for Sender.
 type SendingEmailToBroker struct {
-	Sender   string  `json:"sender"`
-	Provider string  `json:"provider"`
-	Subject  string  `json:"subject"`
-	Title    string  `json:"title"`
-	BodyText string  `json:"bodyText"`
-	Body     string  `json:"body"`
-	Footer   string  `json:"footer"`
-	Emails   []Email `json:"emails"`
+	Sender    string            `json:"sender"`
+	Provider  string            `json:"provider"`
+	Template  string            `json:"template,omitempty"`
+	Locale    string            `json:"locale,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+	Subject   string            `json:"subject,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	BodyText  string            `json:"bodyText,omitempty"`
+	Body      string            `json:"body,omitempty"`
+	Footer    string            `json:"footer,omitempty"`
+	Emails    []Email           `json:"emails"`
+}
+
+// EmailTemplateInput saves a new version of a template, an empty sender is
+// the default of every sender.
+type EmailTemplateInput struct {
+	Sender   string `json:"sender"`
+	Name     string `json:"name" validate:"required"`
+	Locale   string `json:"locale" validate:"required,oneof=id en"`
+	Subject  string `json:"subject" validate:"required"`
+	Title    string `json:"title"`
+	BodyHtml string `json:"bodyHtml" validate:"required"`
+	BodyText string `json:"bodyText" validate:"required"`
+	Footer   string `json:"footer"`
+}
+
+// EmailTemplatePreviewInput renders the template in use for sender, name and
+// locale, or the draft in BodyHtml when set. Variables override the sample
+// data.
+type EmailTemplatePreviewInput struct {
+	Sender    string            `json:"sender"`
+	Name      string            `json:"name"`
+	Locale    string            `json:"locale"`
+	Subject   string            `json:"subject"`
+	Title     string            `json:"title"`
+	BodyHtml  string            `json:"bodyHtml"`
+	BodyText  string            `json:"bodyText"`
+	Footer    string            `json:"footer"`
+	Variables map[string]string `json:"variables"`
+}
+
+// PublishInput is the body of the pub/sub publish endpoint, Data is
+// published as is.
+type PublishInput struct {
+	Subject string          `json:"subject" validate:"required"`
+	Data    json.RawMessage `json:"data" validate:"required"`
+}
+
+// BrokerEvent is a message delivered to the SSE and WebSocket subscribers.
+type BrokerEvent struct {
+	Subject     string          `json:"subject"`
+	Data        json.RawMessage `json:"data"`
+	PublishedBy string          `json:"published_by,omitempty"`
+	ReceivedAt  string          `json:"received_at"`
+}
+
+// User lifecycle events, written to the outbox with the change and relayed
+// to NATS.
+const (
+	EventUserCreated     = "user.created"
+	EventUserUpdated     = "user.updated"
+	EventUserBlocked     = "user.blocked"
+	EventPasswordChanged = "password.changed"
+	EventRoleAssigned    = "role.assigned"
+)
+
+var UserEvents = []string{EventUserCreated, EventUserUpdated, EventUserBlocked, EventPasswordChanged, EventRoleAssigned}
+
+// UserEvent is the payload of the user lifecycle events, it never carries
+// secrets.
+type UserEvent struct {
+	Event         string    `json:"event"`
+	IdAccount     string    `json:"idAccount"`
+	Username      string    `json:"username,omitempty"`
+	FullName      string    `json:"fullName,omitempty"`
+	Email         string    `json:"email,omitempty"`
+	StatusAccount int8      `json:"statusAccount"`
+	AuthRoleId    int       `json:"authRoleId,omitempty"`
+	OccurredAt    time.Time `json:"occurredAt"`
 }
 
 type ResendKey struct {
@@ -82,3 +326,33 @@ type SMTPConfig struct {
 	SMTPPassword string
 	ImageElement *string
 }
+
+type ApiKeyInput struct {
+	Name       string   `json:"name" validate:"required"`
+	OwnerId    string   `json:"ownerId" validate:"required"`
+	Scopes     []string `json:"scopes"`
+	AllowedIps []string `json:"allowedIps"`
+	RateLimit  int      `json:"rateLimit" validate:"min=0"`
+	ExpiresAt  int64    `json:"expiresAt" validate:"min=0"`
+}
+
+// ApiKeyUpdateInput replaces the scopes, IPs and limits of a key, scopes
+// must be sent, an empty list removes every scope.
+type ApiKeyUpdateInput struct {
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes" validate:"required"`
+	AllowedIps []string `json:"allowedIps"`
+	RateLimit  int      `json:"rateLimit" validate:"min=0"`
+	ExpiresAt  int64    `json:"expiresAt" validate:"min=0"`
+}
+
+// WebhookInput creates or updates a webhook, an empty secret on create
+// generates one. Events are NATS subject filters on the user and audit
+// events.
+type WebhookInput struct {
+	Name     string   `json:"name" validate:"required"`
+	Url      string   `json:"url" validate:"required,url"`
+	Events   []string `json:"events" validate:"required,min=1"`
+	Secret   string   `json:"secret"`
+	Disabled bool     `json:"disabled"`
+}
@@ -42,7 +42,7 @@ import {
 } from "@/components/ui/table"
 import { Switch } from "@/components/ui/switch";
 
-import { BackendUrlBase } from "@/services/baseService";
+import { BackendUrlBase, FetchCsrfToken } from "@/services/baseService";
 
 import MetricsInfoSkeleton from "./metrics-info-skeleton";
 import { fetchSWR, SWRDashboardConfig } from "@/services/use-swr-service";
@@ -69,10 +69,11 @@ const CircuitBreaker = () => {
 
   const handleRestart = async () => {
     toast.promise(
-      fetch(`${BackendUrlBase}/restart`, {
+      FetchCsrfToken().then((getCsrf) => fetch(`${BackendUrlBase}/restart`, {
         method: "POST",
         credentials: "include",
-      }).then(async (res) => {
+        headers: { "X-SGCsrf-Token": getCsrf },
+      })).then(async (res) => {
         if (!res.ok) throw new Error("Request failed")
         const data = await res.json()
         if (!data.status) throw new Error(data.message || "Failed to login")
@@ -167,12 +168,12 @@ const ServicesInfo = () => {
     //   delete obj.status;
     // });
     toast.promise(
-      fetch(`${BackendUrlBase}/config-file`, {
+      FetchCsrfToken().then((getCsrf) => fetch(`${BackendUrlBase}/config-file`, {
         method: "POST",
         credentials: "include",
-        headers: { "Content-Type": "application/json" },
+        headers: { "Content-Type": "application/json", "X-SGCsrf-Token": getCsrf },
         body: JSON.stringify({services: listInfoData}),
-      }).then(async (res) => {
+      })).then(async (res) => {
         if (!res.ok) throw new Error("Request failed")
         const data = await res.json()
         if (!data.status) throw new Error(data.message || "Failed to login")
@@ -222,8 +223,8 @@ const ServicesInfo = () => {
           </TableHeader>
 
           <TableBody>
-            {infoData?.length > 0 ? (
-              infoData?.map((el: InfoDataType, index: number) => (
+            {infoData?.services?.length > 0 ? (
+              infoData?.services?.map((el: InfoDataType, index: number) => (
                 <TableRow key={`${el.url}-${index}`}>
                   <TableCell>
                     {edit ? (
@@ -217,6 +217,8 @@ function FormModule({
       try {
         const response = await fetch(`${BackendUrlBase}/upload-file`, {
           method: "POST",
+          credentials: "include",
+          headers: { "X-SGCsrf-Token": getCsrf },
           body: uploadData,
         });
 
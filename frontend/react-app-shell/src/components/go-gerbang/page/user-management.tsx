@@ -282,7 +282,7 @@ const UserPasswordForm = ({value, setOpen, mutate}: FormProps<Account>) => {
         // const sender = "SISKOR"
         const provider = "SMTP"
         const sender = "DEV-REZA"
-        await (await fetch(`${BackendUrlBase}/users/send-information/${value?.idAccount}?provider=${provider}&sender=${sender}&sendPass=true&password=${form.password}`, { credentials: 'include' })).json();
+        await (await fetch(`${BackendUrlBase}/users/send-information/${value?.idAccount}?provider=${provider}&sender=${sender}&sendPass=true&baseUrl=${BackendUrlBase}`, { credentials: 'include' })).json();
       }
       toast.success(response?.message ?? "Success to save");
     }